@@ -0,0 +1,364 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin implements subcommands for inspecting and repairing networks and endpoints of
+// the driver on a host, so that it can be debugged without hand-written PowerShell and Contrail
+// API calls.
+package admin
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/codilime/contrail-windows-docker/agent"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/config"
+	"github.com/codilime/contrail-windows-docker/driver"
+	"github.com/codilime/contrail-windows-docker/hns"
+	"github.com/codilime/contrail-windows-docker/hyperv"
+	dockerClient "github.com/docker/docker/client"
+)
+
+// Env is what subcommands work with.
+type Env struct {
+	// Out is where results are printed. Logs go to logrus as usual.
+	Out         io.Writer
+	VSwitchName string
+	Agent       *agent.Client
+	HNS         hns.Service
+	// Config is the effective configuration, as loaded from file, environment and flags.
+	Config *config.Config
+	// ConfigErr is why Config is invalid, if it is. Then only "config show" is run; it prints
+	// the configuration and fails with ConfigErr.
+	ConfigErr error
+	// NewDriver connects to Contrail and creates the driver. It's only called by subcommands
+	// that need it, so that the rest works when Contrail is unreachable.
+	NewDriver func() (*driver.ContrailDriver, error)
+}
+
+type command struct {
+	name string
+	args string
+	help string
+	// setup defines flags of the command and returns function that runs it.
+	setup func(fs *flag.FlagSet) func(env *Env, args []string) error
+}
+
+var commands = []command{
+	{"status", "", "check docker, HNS, Hyper-V extension, Contrail and vRouter Agent", status},
+	{"networks", "", "list networks of the driver in docker, state file, HNS and Contrail",
+		networks},
+	{"endpoints", "", "list endpoints of the driver in docker, state file, HNS, Contrail and " +
+		"vRouter Agent", endpoints},
+	{"inspect", "<endpoint>", "show endpoint with docker endpoint, container or HNS endpoint " +
+		"ID starting with <endpoint>", inspect},
+	{"cleanup", "", "remove objects whose docker networks or endpoints are gone. Stop the " +
+		"service first.", cleanup},
+	{"reset-hns", "", "remove all HNS networks and endpoints, including ones that aren't " +
+		"driver's, by resetting HNS", resetHNS},
+	{"config", "show", "print effective configuration, with secrets masked", showConfig},
+}
+
+// IsCommand checks if name is a subcommand.
+func IsCommand(name string) bool {
+	_, err := findCommand(name)
+	return err == nil
+}
+
+func findCommand(name string) (*command, error) {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown command: %s", name)
+}
+
+// Usage writes list of subcommands to w.
+func Usage(w io.Writer) {
+	fmt.Fprintln(w, "Commands (run '<command> -h' for their flags):")
+	t := newTable(w)
+	for _, c := range commands {
+		t.row(" ", strings.TrimSpace(c.name+" "+c.args), c.help)
+	}
+	t.flush()
+}
+
+// Run runs subcommand args[0] with the rest of args.
+func Run(env *Env, args []string) error {
+	if len(args) == 0 {
+		return errors.New("No command")
+	}
+	c, err := findCommand(args[0])
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(env.Out)
+	run := c.setup(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	return run(env, fs.Args())
+}
+
+// Status is health of components that the driver depends on. Components are "ok" or describe
+// the failure.
+type Status struct {
+	VSwitch          string `json:"vswitch"`
+	ExtensionEnabled bool   `json:"extension_enabled"`
+	ExtensionRunning bool   `json:"extension_running"`
+	Docker           string `json:"docker"`
+	HNS              string `json:"hns"`
+	Controller       string `json:"controller"`
+	Agent            string `json:"agent"`
+	Networks         int    `json:"networks"`
+	Endpoints        int    `json:"endpoints"`
+	Problems         int    `json:"problems"`
+}
+
+const statusOK = "ok"
+
+func statusOf(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return statusOK
+}
+
+func status(fs *flag.FlagSet) func(env *Env, args []string) error {
+	asJSON := jsonFlag(fs)
+	return func(env *Env, args []string) error {
+		vswitchName := common.VSwitchName(env.VSwitchName)
+		s := Status{VSwitch: env.VSwitchName}
+		s.ExtensionEnabled, _ = hyperv.IsExtensionEnabled(vswitchName)
+		s.ExtensionRunning, _ = hyperv.IsExtensionRunning(vswitchName)
+		s.Docker = statusOf(pingDocker())
+		_, err := env.HNS.ListHNSNetworks()
+		s.HNS = statusOf(err)
+		s.Agent = statusOf(env.Agent.Ping())
+
+		d, err := env.NewDriver()
+		s.Controller = statusOf(err)
+		if err == nil && s.Docker == statusOK && s.HNS == statusOK {
+			nets, err := d.InspectNetworks()
+			if err != nil {
+				return err
+			}
+			eps, err := d.InspectEndpoints(nil)
+			if err != nil {
+				return err
+			}
+			s.Networks = len(nets)
+			s.Endpoints = len(eps)
+			for _, n := range nets {
+				s.Problems += len(n.Problems)
+			}
+			for _, ep := range eps {
+				s.Problems += len(ep.Problems)
+			}
+		}
+
+		if *asJSON {
+			return writeJSON(env.Out, s)
+		}
+		t := newTable(env.Out)
+		t.row("vSwitch:", s.VSwitch)
+		t.row("Extension enabled:", fmt.Sprint(s.ExtensionEnabled))
+		t.row("Extension running:", fmt.Sprint(s.ExtensionRunning))
+		t.row("Docker:", s.Docker)
+		t.row("HNS:", s.HNS)
+		t.row("Contrail controller:", s.Controller)
+		t.row("vRouter Agent:", s.Agent)
+		t.row("Networks:", fmt.Sprint(s.Networks))
+		t.row("Endpoints:", fmt.Sprint(s.Endpoints))
+		t.row("Problems:", fmt.Sprint(s.Problems))
+		return t.flush()
+	}
+}
+
+func pingDocker() error {
+	docker, err := dockerClient.NewEnvClient()
+	if err != nil {
+		return err
+	}
+	_, err = docker.ServerVersion(context.Background())
+	return err
+}
+
+func networks(fs *flag.FlagSet) func(env *Env, args []string) error {
+	asJSON := jsonFlag(fs)
+	return func(env *Env, args []string) error {
+		d, err := env.NewDriver()
+		if err != nil {
+			return err
+		}
+		nets, err := d.InspectNetworks()
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return writeJSON(env.Out, nets)
+		}
+		t := newTable(env.Out)
+		t.row("DOCKER ID", "NAME", "TENANT", "NETWORK", "SUBNETS", "HNS ID", "CONTRAIL UUID",
+			"PROBLEMS")
+		for _, n := range nets {
+			t.row(shortID(n.DockerID), n.Name, n.Tenant, n.Network, list(n.SubnetCIDRs),
+				n.HNSID, n.ContrailUuid, problems(n.Problems))
+		}
+		return t.flush()
+	}
+}
+
+func endpoints(fs *flag.FlagSet) func(env *Env, args []string) error {
+	asJSON := jsonFlag(fs)
+	return func(env *Env, args []string) error {
+		d, err := env.NewDriver()
+		if err != nil {
+			return err
+		}
+		eps, err := d.InspectEndpoints(env.Agent)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return writeJSON(env.Out, eps)
+		}
+		t := newTable(env.Out)
+		t.row("DOCKER ID", "CONTAINER", "NETWORK", "IP", "HNS ID", "VMI UUID", "AGENT PORT",
+			"PROBLEMS")
+		for _, ep := range eps {
+			container := ep.ContainerName
+			if container == "" {
+				container = shortID(ep.ContainerID)
+			}
+			t.row(shortID(ep.DockerID), container, ep.Network, ep.IPAddress, ep.HNSEndpointID,
+				ep.VMIUuid, ep.AgentPort, problems(ep.Problems))
+		}
+		return t.flush()
+	}
+}
+
+func inspect(fs *flag.FlagSet) func(env *Env, args []string) error {
+	asJSON := jsonFlag(fs)
+	return func(env *Env, args []string) error {
+		if len(args) != 1 {
+			return errors.New("inspect needs exactly one endpoint ID")
+		}
+		d, err := env.NewDriver()
+		if err != nil {
+			return err
+		}
+		ep, err := d.InspectEndpoint(args[0], env.Agent)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return writeJSON(env.Out, ep)
+		}
+		t := newTable(env.Out)
+		t.row("Docker endpoint:", ep.DockerID)
+		t.row("Docker network:", ep.NetworkID)
+		t.row("Container:", ep.ContainerID)
+		t.row("Container name:", ep.ContainerName)
+		t.row("Tenant:", ep.Tenant)
+		t.row("Network:", ep.Network)
+		t.row("In state file:", fmt.Sprint(ep.Stored))
+		t.row("HNS endpoint:", ep.HNSEndpointID)
+		t.row("IP address:", ep.IPAddress)
+		t.row("MAC address:", ep.MacAddress)
+		t.row("Contrail interface:", ep.VMIUuid)
+		t.row("Contrail instance:", ep.VMUuid)
+		t.row("vRouter Agent port:", ep.AgentPort)
+		t.row("Problems:", problems(ep.Problems))
+		return t.flush()
+	}
+}
+
+func cleanup(fs *flag.FlagSet) func(env *Env, args []string) error {
+	asJSON := jsonFlag(fs)
+	dryRun := fs.Bool("dry-run", false, "only report what would be removed")
+	return func(env *Env, args []string) error {
+		d, err := env.NewDriver()
+		if err != nil {
+			return err
+		}
+		orphans, gcErr := d.CollectGarbage(*dryRun)
+		if *asJSON {
+			if err := writeJSON(env.Out, orphans); err != nil {
+				return err
+			}
+			return gcErr
+		}
+		verb := "Removed"
+		if *dryRun {
+			verb = "Found"
+		}
+		for _, o := range orphans {
+			fmt.Fprintln(env.Out, verb, "orphaned", o)
+		}
+		if len(orphans) == 0 {
+			fmt.Fprintln(env.Out, "No orphaned objects")
+		}
+		return gcErr
+	}
+}
+
+func resetHNS(fs *flag.FlagSet) func(env *Env, args []string) error {
+	force := fs.Bool("force", false, "confirm that all HNS networks may be removed")
+	return func(env *Env, args []string) error {
+		if !*force {
+			return errors.New("reset-hns removes all HNS networks and endpoints on the host, " +
+				"run it with -force to confirm")
+		}
+		if err := common.HardResetHNS(); err != nil {
+			return err
+		}
+		fmt.Fprintln(env.Out, "HNS was reset")
+		return nil
+	}
+}
+
+func showConfig(fs *flag.FlagSet) func(env *Env, args []string) error {
+	asJSON := jsonFlag(fs)
+	return func(env *Env, args []string) error {
+		if len(args) != 1 || args[0] != "show" {
+			return errors.New("Usage: config show")
+		}
+		masked := env.Config.Masked()
+		var err error
+		if *asJSON {
+			err = writeJSON(env.Out, masked)
+		} else {
+			err = masked.Write(env.Out)
+		}
+		if err != nil {
+			return err
+		}
+		if env.ConfigErr != nil {
+			return fmt.Errorf("Invalid configuration: %v", env.ConfigErr)
+		}
+		return nil
+	}
+}
+
+func jsonFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("json", false, "print JSON instead of a table")
+}
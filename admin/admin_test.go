@@ -0,0 +1,109 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/codilime/contrail-windows-docker/config"
+	"github.com/codilime/contrail-windows-docker/driver"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+func TestAdmin(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("admin_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Admin test suite",
+		[]Reporter{junitReporter})
+}
+
+var _ = Describe("Admin commands", func() {
+
+	var out *bytes.Buffer
+	var env *Env
+	var driverCreated bool
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		driverCreated = false
+		env = &Env{
+			Out: out,
+			NewDriver: func() (*driver.ContrailDriver, error) {
+				driverCreated = true
+				return nil, errors.New("Contrail is unreachable")
+			},
+		}
+	})
+
+	It("lists all commands in usage", func() {
+		Usage(out)
+		for _, name := range []string{"status", "networks", "endpoints", "inspect", "cleanup",
+			"reset-hns"} {
+			Expect(IsCommand(name)).To(BeTrue())
+			Expect(out.String()).To(ContainSubstring(name))
+		}
+	})
+
+	It("rejects unknown command", func() {
+		Expect(IsCommand("frobnicate")).To(BeFalse())
+		Expect(Run(env, []string{"frobnicate"})).ToNot(Succeed())
+	})
+
+	It("rejects unknown flags", func() {
+		Expect(Run(env, []string{"networks", "-yaml"})).ToNot(Succeed())
+		Expect(driverCreated).To(BeFalse())
+	})
+
+	It("reports that Contrail is unreachable", func() {
+		err := Run(env, []string{"networks"})
+		Expect(err).To(MatchError("Contrail is unreachable"))
+	})
+
+	It("requires endpoint ID to inspect", func() {
+		Expect(Run(env, []string{"inspect"})).ToNot(Succeed())
+		Expect(driverCreated).To(BeFalse())
+	})
+
+	It("doesn't reset HNS without confirmation", func() {
+		Expect(Run(env, []string{"reset-hns"})).ToNot(Succeed())
+		Expect(out.String()).To(BeEmpty())
+	})
+
+	It("shows invalid configuration and fails with the reason", func() {
+		env.Config = config.Defaults()
+		env.Config.Default.Scope = "universal"
+		env.ConfigErr = env.Config.Validate()
+		Expect(env.ConfigErr).To(HaveOccurred())
+
+		err := Run(env, []string{"config", "show"})
+		Expect(err).To(MatchError(ContainSubstring(env.ConfigErr.Error())))
+		Expect(out.String()).To(ContainSubstring("universal"))
+	})
+
+	Context("table", func() {
+		It("aligns columns and fills empty cells", func() {
+			t := newTable(out)
+			t.row("ID", "NAME")
+			t.row(shortID("0123456789abcdef0123"), "")
+			Expect(t.flush()).To(Succeed())
+			Expect(out.String()).To(Equal("ID            NAME\n0123456789ab  -\n"))
+		})
+	})
+})
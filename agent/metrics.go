@@ -0,0 +1,50 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "agent_request_duration_seconds",
+		Help:      "Duration of requests to vRouter Agent, by operation.",
+		Buckets:   metrics.DefaultBuckets,
+	}, []string{"operation"})
+	requestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "agent_request_errors_total",
+		Help:      "Number of requests to vRouter Agent that failed, by operation.",
+	}, []string{"operation"})
+)
+
+func init() {
+	metrics.DefaultRegistry.MustRegister(requestDuration, requestErrors)
+}
+
+// observeRequest records request that started at start. It's deferred with pointer to named
+// error result, so that the final error is seen.
+func observeRequest(op string, start time.Time, err *error) {
+	requestDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		requestErrors.WithLabelValues(op).Inc()
+	}
+}
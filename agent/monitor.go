@@ -0,0 +1,119 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPollInterval is how often Monitor checks vRouter Agent by default.
+const DefaultPollInterval = 10 * time.Second
+
+// Monitor detects vRouter Agent restarts, after which agent no longer knows ports that were
+// added through its API. Agent is considered restarted when it becomes reachable again after
+// it couldn't be reached, or when it forgets a port that was added successfully.
+type Monitor struct {
+	client    *Client
+	queue     *Queue
+	interval  time.Duration
+	onRestart func()
+
+	// reachable is only accessed by the polling goroutine.
+	reachable bool
+
+	stopChan    chan interface{}
+	stoppedChan chan interface{}
+}
+
+// NewMonitor creates a monitor that checks agent with client every interval and calls onRestart
+// when agent was restarted. Ports added through queue are used to check if agent forgot them.
+// Agent is assumed unreachable at first, so onRestart is also called after the first successful
+// check, because agent could have restarted before the monitor was started.
+func NewMonitor(client *Client, queue *Queue, interval time.Duration,
+	onRestart func()) *Monitor {
+	return &Monitor{
+		client:      client,
+		queue:       queue,
+		interval:    interval,
+		onRestart:   onRestart,
+		stopChan:    make(chan interface{}),
+		stoppedChan: make(chan interface{}),
+	}
+}
+
+// Start starts polling agent in the background.
+func (m *Monitor) Start() {
+	go m.run()
+}
+
+// Stop stops polling and waits for onRestart to return, if it's running.
+func (m *Monitor) Stop() {
+	close(m.stopChan)
+	<-m.stoppedChan
+}
+
+func (m *Monitor) run() {
+	defer close(m.stoppedChan)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if m.agentRestarted() {
+			m.onRestart()
+		}
+		select {
+		case <-m.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) agentRestarted() bool {
+	err := m.probe()
+	if IsUnreachable(err) {
+		if m.reachable {
+			log.Warnln("vRouter Agent became unreachable:", err)
+		}
+		m.reachable = false
+		return false
+	}
+
+	if !m.reachable {
+		log.Infoln("vRouter Agent is reachable")
+		m.reachable = true
+		return true
+	}
+	if IsNotFound(err) {
+		log.Warnln("vRouter Agent forgot added ports, it must have restarted:", err)
+		return true
+	}
+	return false
+}
+
+// probe asks agent about any port that was added successfully. If there are no such ports, or
+// agent was unreachable, it only checks if agent responds, not to log errors on every poll.
+func (m *Monitor) probe() error {
+	if m.reachable {
+		for _, status := range m.queue.Statuses() {
+			if status.Op == OpAddPort && status.State == PortOK {
+				return m.client.GetPort(status.VifUuid)
+			}
+		}
+	}
+	return m.client.Ping()
+}
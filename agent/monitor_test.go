@@ -0,0 +1,99 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("vRouter Agent monitor", func() {
+
+	var server *httptest.Server
+	var client *Client
+	var queue *Queue
+	var monitor *Monitor
+	var restarts chan interface{}
+
+	var mutex sync.Mutex
+	var knownPorts map[string]bool
+
+	BeforeEach(func() {
+		knownPorts = make(map[string]bool)
+		restarts = make(chan interface{}, 10)
+
+		// stands in for vRouter Agent that remembers added ports
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch {
+			case r.Method == http.MethodPost:
+				knownPorts["vif_uuid"] = true
+			case r.Method == http.MethodGet && knownPorts["vif_uuid"] &&
+				r.URL.Path == "/port/vif_uuid":
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		client = NewClient(server.URL, time.Second)
+		queue = NewQueue(client, 0, time.Millisecond)
+		monitor = NewMonitor(client, queue, 10*time.Millisecond, func() {
+			select {
+			case restarts <- 1:
+			default:
+			}
+		})
+	})
+	AfterEach(func() {
+		monitor.Stop()
+		queue.Close()
+		server.Close()
+	})
+
+	forgetPorts := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		knownPorts = make(map[string]bool)
+	}
+
+	It("reports restart after the first successful check", func() {
+		monitor.Start()
+		Eventually(restarts).Should(Receive())
+		Consistently(restarts, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("reports restart when agent forgets added port", func() {
+		Expect(<-queue.AddPort(Port{VifUuid: "vif_uuid"})).To(Succeed())
+		monitor.Start()
+		Eventually(restarts).Should(Receive())
+		Consistently(restarts, 100*time.Millisecond).ShouldNot(Receive())
+
+		forgetPorts()
+		Eventually(restarts).Should(Receive())
+	})
+
+	It("doesn't report restart while agent is unreachable", func() {
+		server.Close()
+		monitor.Start()
+		Consistently(restarts, 100*time.Millisecond).ShouldNot(Receive())
+	})
+})
@@ -0,0 +1,84 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PortFiles is a directory of port files, which vRouter Agent reads when it starts, so that
+// ports survive agent and host restarts. Every file is named by the port's VifUuid and contains
+// the same JSON that is sent to agent's port API.
+type PortFiles struct {
+	dir string
+}
+
+// NewPortFiles returns port files kept in dir (like common.AgentPortsDir()). The directory is
+// created when the first file is written.
+func NewPortFiles(dir string) *PortFiles {
+	return &PortFiles{dir: dir}
+}
+
+// Dir returns the directory of port files.
+func (f *PortFiles) Dir() string {
+	return f.dir
+}
+
+// Write creates or replaces the file of port.
+func (f *PortFiles) Write(port Port) error {
+	data, err := json.MarshalIndent(newPortRequest(port), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		log.Errorf("When trying to create port files dir: %v", err)
+		return err
+	}
+
+	// Agent could read a half-written file, so it's written under a temporary name first.
+	path := f.path(port.VifUuid)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Errorf("When trying to write port file: %v", err)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		log.Errorf("When trying to replace port file: %v", err)
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Remove removes the file of port. Removing a file that doesn't exist is not an error.
+func (f *PortFiles) Remove(vifUuid string) error {
+	err := os.Remove(f.path(vifUuid))
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("When trying to remove port file: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (f *PortFiles) path(vifUuid string) string {
+	return filepath.Join(f.dir, vifUuid)
+}
@@ -0,0 +1,95 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("vRouter Agent port files", func() {
+
+	var dir string
+	var files *PortFiles
+
+	testPort := Port{
+		VmUuid:      "vm_uuid",
+		VifUuid:     "vif_uuid",
+		VnUuid:      "vn_uuid",
+		IfName:      "Container--vif_uuid",
+		Mac:         "11:22:aa:bb:cc:dd",
+		IPAddress:   "10.0.0.2",
+		DisplayName: "container_id",
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ports")
+		Expect(err).ToNot(HaveOccurred())
+		files = NewPortFiles(filepath.Join(dir, "ports"))
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	readPortFile := func(vifUuid string) map[string]interface{} {
+		data, err := ioutil.ReadFile(filepath.Join(files.Dir(), vifUuid))
+		Expect(err).ToNot(HaveOccurred())
+		var contents map[string]interface{}
+		Expect(json.Unmarshal(data, &contents)).To(Succeed())
+		return contents
+	}
+
+	It("writes port in agent's format to file named by VifUuid", func() {
+		Expect(files.Write(testPort)).To(Succeed())
+
+		contents := readPortFile("vif_uuid")
+		Expect(contents).To(HaveKeyWithValue("id", "vif_uuid"))
+		Expect(contents).To(HaveKeyWithValue("instance-id", "vm_uuid"))
+		Expect(contents).To(HaveKeyWithValue("system-name", "Container--vif_uuid"))
+		Expect(contents).To(HaveKeyWithValue("ip-address", "10.0.0.2"))
+
+		infos, err := ioutil.ReadDir(files.Dir())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(infos).To(HaveLen(1))
+	})
+
+	It("replaces file of the same port", func() {
+		Expect(files.Write(testPort)).To(Succeed())
+		updatedPort := testPort
+		updatedPort.IPAddress = "10.0.0.3"
+		Expect(files.Write(updatedPort)).To(Succeed())
+
+		Expect(readPortFile("vif_uuid")).To(HaveKeyWithValue("ip-address", "10.0.0.3"))
+	})
+
+	It("removes port file", func() {
+		Expect(files.Write(testPort)).To(Succeed())
+		Expect(files.Remove("vif_uuid")).To(Succeed())
+
+		_, err := os.Stat(filepath.Join(files.Dir(), "vif_uuid"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("ignores removing file that doesn't exist", func() {
+		Expect(files.Remove("vif_uuid")).To(Succeed())
+	})
+})
@@ -0,0 +1,276 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxRetries is how many times a failed request is retried by default.
+	DefaultMaxRetries = 3
+
+	// DefaultBackoff is the delay before the first retry. It doubles with every retry.
+	DefaultBackoff = time.Second
+)
+
+// Operations of queued requests.
+const (
+	OpAddPort    = "add port"
+	OpDeletePort = "delete port"
+)
+
+// PortState is the outcome of the last request for a port.
+type PortState string
+
+const (
+	// PortPending means the last request is waiting in the queue or being retried.
+	PortPending PortState = "pending"
+	// PortOK means the last request succeeded.
+	PortOK PortState = "ok"
+	// PortFailed means the last request failed, even after retries.
+	PortFailed PortState = "failed"
+)
+
+// ErrQueueClosed is returned for requests enqueued after the queue was closed.
+var ErrQueueClosed = errors.New("vRouter Agent queue is closed")
+
+// PortStatus describes the last request for a port.
+type PortStatus struct {
+	VifUuid string
+	// DisplayName is display name of the port, like docker container ID.
+	DisplayName string
+	Op          string
+	State       PortState
+	// Attempts is how many times the request was sent so far.
+	Attempts int
+	// LastError is the error of the last attempt, if it failed.
+	LastError string
+	Updated   time.Time
+}
+
+type queuedRequest struct {
+	op          string
+	vifUuid     string
+	displayName string
+	do          func() error
+	result      chan error
+}
+
+// Queue sends requests for every port to vRouter Agent one at a time, in order in which they
+// were enqueued, so that requests for the same port never overlap. Ports are served by
+// separate workers, so a port whose requests are being retried doesn't hold up the others.
+// Failed requests are retried with backoff, and the outcome of the last request for every port
+// is kept, until the port is deleted.
+type Queue struct {
+	client     *Client
+	maxRetries int
+	backoff    time.Duration
+	// workers tracks running port workers, so that Close can wait for them.
+	workers sync.WaitGroup
+
+	// closeMutex guards closed flag, which must not be set while a request is enqueued.
+	closeMutex sync.RWMutex
+	closed     bool
+
+	mutex sync.Mutex
+	// pending holds requests of every port that has a running worker, the one being sent
+	// first.
+	pending  map[string][]*queuedRequest
+	statuses map[string]PortStatus
+}
+
+// NewQueue creates a queue that sends requests with client. Every request is sent at most
+// 1 + maxRetries times.
+func NewQueue(client *Client, maxRetries int, backoff time.Duration) *Queue {
+	return &Queue{
+		client:     client,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+		pending:    make(map[string][]*queuedRequest),
+		statuses:   make(map[string]PortStatus),
+	}
+}
+
+// AddPort enqueues adding port to vRouter Agent. The returned channel receives the result,
+// once the request succeeds or runs out of retries. Callers that don't care can ignore it.
+func (q *Queue) AddPort(port Port) <-chan error {
+	return q.enqueue(&queuedRequest{
+		op:          OpAddPort,
+		vifUuid:     port.VifUuid,
+		displayName: port.DisplayName,
+		do: func() error {
+			return q.client.AddPort(port)
+		},
+	})
+}
+
+// DeletePort enqueues deleting port from vRouter Agent. Port that agent doesn't know is
+// considered deleted.
+func (q *Queue) DeletePort(vifUuid string) <-chan error {
+	return q.enqueue(&queuedRequest{
+		op:      OpDeletePort,
+		vifUuid: vifUuid,
+		do: func() error {
+			if err := q.client.DeletePort(vifUuid); err != nil && !IsNotFound(err) {
+				return err
+			}
+			return nil
+		},
+	})
+}
+
+// Status returns status of the last request for the port.
+func (q *Queue) Status(vifUuid string) (PortStatus, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	status, exists := q.statuses[vifUuid]
+	return status, exists
+}
+
+// Statuses returns statuses of all known ports, sorted by VifUuid.
+func (q *Queue) Statuses() []PortStatus {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var statuses []PortStatus
+	for _, status := range q.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].VifUuid < statuses[j].VifUuid })
+	return statuses
+}
+
+// Close waits for already enqueued requests to finish. Requests enqueued later fail with
+// ErrQueueClosed.
+func (q *Queue) Close() {
+	q.closeMutex.Lock()
+	if q.closed {
+		q.closeMutex.Unlock()
+		return
+	}
+	q.closed = true
+	q.closeMutex.Unlock()
+
+	q.workers.Wait()
+}
+
+func (q *Queue) enqueue(r *queuedRequest) <-chan error {
+	r.result = make(chan error, 1)
+
+	q.closeMutex.RLock()
+	defer q.closeMutex.RUnlock()
+	if q.closed {
+		r.result <- ErrQueueClosed
+		return r.result
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.setStatus(r, PortPending, 0, nil)
+	q.pending[r.vifUuid] = append(q.pending[r.vifUuid], r)
+	if len(q.pending[r.vifUuid]) == 1 {
+		q.workers.Add(1)
+		go q.work(r.vifUuid)
+	}
+	return r.result
+}
+
+// work sends pending requests of a port until there are none left.
+func (q *Queue) work(vifUuid string) {
+	defer q.workers.Done()
+	for {
+		q.mutex.Lock()
+		r := q.pending[vifUuid][0]
+		q.mutex.Unlock()
+
+		r.result <- q.process(r)
+
+		q.mutex.Lock()
+		q.pending[vifUuid] = q.pending[vifUuid][1:]
+		if len(q.pending[vifUuid]) == 0 {
+			delete(q.pending, vifUuid)
+			q.mutex.Unlock()
+			return
+		}
+		q.mutex.Unlock()
+	}
+}
+
+func (q *Queue) process(r *queuedRequest) error {
+	backoff := q.backoff
+	for attempt := 1; ; attempt++ {
+		err := r.do()
+		if err == nil {
+			q.mutex.Lock()
+			q.setStatus(r, PortOK, attempt, nil)
+			q.mutex.Unlock()
+			return nil
+		}
+
+		if attempt > q.maxRetries || !isRetriable(err) {
+			log.Errorf("Failed to %s %s after %d attempts: %v", r.op, r.vifUuid, attempt, err)
+			q.mutex.Lock()
+			q.setStatus(r, PortFailed, attempt, err)
+			q.mutex.Unlock()
+			return err
+		}
+
+		log.Warnf("Failed to %s %s (attempt %d of %d), retrying in %v: %v", r.op, r.vifUuid,
+			attempt, q.maxRetries+1, backoff, err)
+		q.mutex.Lock()
+		q.setStatus(r, PortPending, attempt, err)
+		q.mutex.Unlock()
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// setStatus must be called with mutex locked.
+func (q *Queue) setStatus(r *queuedRequest, state PortState, attempts int, err error) {
+	if r.op == OpDeletePort && state == PortOK {
+		delete(q.statuses, r.vifUuid)
+		return
+	}
+
+	status := PortStatus{
+		VifUuid:     r.vifUuid,
+		DisplayName: r.displayName,
+		Op:          r.op,
+		State:       state,
+		Attempts:    attempts,
+		Updated:     time.Now(),
+	}
+	if status.DisplayName == "" {
+		status.DisplayName = q.statuses[r.vifUuid].DisplayName
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	q.statuses[r.vifUuid] = status
+}
+
+// isRetriable checks if request can succeed when sent again: agent was unreachable or failed
+// internally. Requests that agent refused are not retried.
+func isRetriable(err error) bool {
+	agentErr, ok := err.(*Error)
+	return ok && (agentErr.StatusCode == 0 || agentErr.StatusCode >= 500)
+}
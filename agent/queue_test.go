@@ -0,0 +1,195 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("vRouter Agent queue", func() {
+
+	const maxRetries = 2
+
+	var server *httptest.Server
+	var queue *Queue
+
+	var mutex sync.Mutex
+	var requests []string
+	// responses are returned in order; when they run out, agent responds with 200
+	var responses []int
+	// failingPath always gets 500
+	const failingPath = "/port/failing_vif"
+
+	testPort := Port{
+		VmUuid:      "vm_uuid",
+		VifUuid:     "vif_uuid",
+		DisplayName: "container_id",
+	}
+
+	BeforeEach(func() {
+		requests = nil
+		responses = nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			status := http.StatusOK
+			if r.URL.Path == failingPath {
+				status = http.StatusInternalServerError
+			} else if len(responses) > 0 {
+				status = responses[0]
+				responses = responses[1:]
+			}
+			w.WriteHeader(status)
+		}))
+		queue = NewQueue(NewClient(server.URL, time.Second), maxRetries, time.Millisecond)
+	})
+	AfterEach(func() {
+		queue.Close()
+		server.Close()
+	})
+
+	respondWith := func(statuses ...int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		responses = statuses
+	}
+
+	receivedRequests := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return requests
+	}
+
+	It("sends requests in order", func() {
+		queue.AddPort(testPort)
+		queue.DeletePort("vif_uuid")
+		err := <-queue.AddPort(testPort)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(receivedRequests()).To(Equal([]string{
+			"POST /port",
+			"DELETE /port/vif_uuid",
+			"POST /port",
+		}))
+	})
+
+	It("records outcome of successful request", func() {
+		err := <-queue.AddPort(testPort)
+		Expect(err).ToNot(HaveOccurred())
+
+		status, exists := queue.Status("vif_uuid")
+		Expect(exists).To(BeTrue())
+		Expect(status.State).To(Equal(PortOK))
+		Expect(status.Op).To(Equal(OpAddPort))
+		Expect(status.DisplayName).To(Equal("container_id"))
+		Expect(status.Attempts).To(Equal(1))
+		Expect(status.LastError).To(BeEmpty())
+	})
+
+	It("retries when agent fails internally", func() {
+		respondWith(http.StatusInternalServerError, http.StatusServiceUnavailable)
+
+		err := <-queue.AddPort(testPort)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(receivedRequests()).To(HaveLen(3))
+
+		status, _ := queue.Status("vif_uuid")
+		Expect(status.State).To(Equal(PortOK))
+		Expect(status.Attempts).To(Equal(3))
+	})
+
+	It("gives up after max retries", func() {
+		respondWith(http.StatusInternalServerError, http.StatusInternalServerError,
+			http.StatusInternalServerError, http.StatusInternalServerError)
+
+		err := <-queue.AddPort(testPort)
+		Expect(err).To(HaveOccurred())
+		Expect(receivedRequests()).To(HaveLen(1 + maxRetries))
+
+		status, _ := queue.Status("vif_uuid")
+		Expect(status.State).To(Equal(PortFailed))
+		Expect(status.LastError).ToNot(BeEmpty())
+	})
+
+	It("doesn't retry requests refused by agent", func() {
+		respondWith(http.StatusBadRequest)
+
+		err := <-queue.AddPort(testPort)
+		Expect(err).To(HaveOccurred())
+		Expect(receivedRequests()).To(HaveLen(1))
+	})
+
+	It("forgets ports after they are deleted", func() {
+		<-queue.AddPort(testPort)
+		err := <-queue.DeletePort("vif_uuid")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, exists := queue.Status("vif_uuid")
+		Expect(exists).To(BeFalse())
+		Expect(queue.Statuses()).To(BeEmpty())
+	})
+
+	It("treats deleting unknown port as success", func() {
+		respondWith(http.StatusNotFound)
+
+		err := <-queue.DeletePort("vif_uuid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(receivedRequests()).To(HaveLen(1))
+	})
+
+	It("keeps display name of port that failed to be deleted", func() {
+		<-queue.AddPort(testPort)
+		respondWith(http.StatusBadRequest)
+		<-queue.DeletePort("vif_uuid")
+
+		status, _ := queue.Status("vif_uuid")
+		Expect(status.State).To(Equal(PortFailed))
+		Expect(status.Op).To(Equal(OpDeletePort))
+		Expect(status.DisplayName).To(Equal("container_id"))
+	})
+
+	It("doesn't hold up other ports while retrying requests of a failing one", func() {
+		const backoff = 200 * time.Millisecond
+		slowQueue := NewQueue(NewClient(server.URL, time.Second), maxRetries, backoff)
+		defer slowQueue.Close()
+
+		failed := slowQueue.DeletePort("failing_vif")
+		Eventually(receivedRequests).Should(ContainElement("DELETE " + failingPath))
+
+		start := time.Now()
+		err := <-slowQueue.AddPort(testPort)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", backoff))
+
+		Expect(<-failed).To(HaveOccurred())
+	})
+
+	It("refuses requests after it's closed", func() {
+		queue.Close()
+
+		err := <-queue.AddPort(testPort)
+		Expect(err).To(Equal(ErrQueueClosed))
+	})
+})
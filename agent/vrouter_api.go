@@ -16,31 +16,237 @@
 package agent
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/codilime/contrail-windows-docker/common"
 )
 
-func AddPort(vmUuid, vifUuid, ifName, mac, dockerID, ipAddress, vnUuid string) error {
-	stdout, stderr, err := common.Call("python", common.AgentAPIWrapperScriptPath(),
-		"add", vmUuid, vifUuid, fmt.Sprintf("\"%s\"", ifName), mac, dockerID,
-		ipAddress, vnUuid)
-	log.Debugf("Called Agent API wrapper: stdout: %s, stderr: %s", stdout, stderr)
+const (
+	// DefaultURL is the address of vRouter Agent's local port API.
+	DefaultURL = "http://127.0.0.1:9091"
+
+	// DefaultTimeout is time to wait for vRouter Agent to handle a request.
+	DefaultTimeout = 10 * time.Second
+
+	// noVlan is VLAN ID of ports that don't use VLANs, like container interfaces.
+	noVlan = -1
+
+	// maxErrorMessageLen limits how much of agent's error response is kept in Error.
+	maxErrorMessageLen = 512
+)
+
+// PortType tells vRouter Agent what kind of port it handles.
+type PortType int
+
+const (
+	// NovaVMPort is a port of virtual machine or container.
+	NovaVMPort PortType = 0
+	// NameSpacePort is a port of network namespace.
+	NameSpacePort PortType = 1
+)
+
+// Port describes container interface that vRouter Agent should handle.
+type Port struct {
+	// VmUuid is UUID of Contrail virtual-machine of the container.
+	VmUuid string
+	// VifUuid is UUID of Contrail virtual-machine-interface. It identifies the port.
+	VifUuid string
+	// VnUuid is UUID of Contrail virtual-network the interface is connected to.
+	VnUuid string
+	// VmProjectUuid is UUID of Contrail project of the virtual-machine.
+	VmProjectUuid string
+	// IfName is name of the interface in the system, as seen by vRouter Forwarding Extension.
+	IfName string
+	// Mac is MAC address of the interface, like 11:22:aa:bb:cc:dd.
+	Mac string
+	// IPAddress is IPv4 address of the interface.
+	IPAddress string
+	// IPv6Address is IPv6 address of the interface, if it has one.
+	IPv6Address string
+	// DisplayName is human readable name of the port, like docker container ID.
+	DisplayName string
+	// Type is type of the port. Containers use NovaVMPort.
+	Type PortType
+}
+
+// portRequest is the JSON body of port add request, as expected by vRouter Agent. Port files
+// have the same format.
+type portRequest struct {
+	ID            string   `json:"id"`
+	InstanceID    string   `json:"instance-id"`
+	VnID          string   `json:"vn-id"`
+	VmProjectID   string   `json:"vm-project-id"`
+	SystemName    string   `json:"system-name"`
+	MacAddress    string   `json:"mac-address"`
+	IPAddress     string   `json:"ip-address"`
+	IP6Address    string   `json:"ip6-address"`
+	DisplayName   string   `json:"display-name"`
+	Type          PortType `json:"type"`
+	RxVlanID      int      `json:"rx-vlan-id"`
+	TxVlanID      int      `json:"tx-vlan-id"`
+	VhostUserMode int      `json:"vhostuser-mode"`
+	Author        string   `json:"author"`
+	Time          string   `json:"time"`
+}
+
+func newPortRequest(port Port) portRequest {
+	return portRequest{
+		ID:          port.VifUuid,
+		InstanceID:  port.VmUuid,
+		VnID:        port.VnUuid,
+		VmProjectID: port.VmProjectUuid,
+		SystemName:  port.IfName,
+		MacAddress:  port.Mac,
+		IPAddress:   port.IPAddress,
+		IP6Address:  port.IPv6Address,
+		DisplayName: port.DisplayName,
+		Type:        port.Type,
+		RxVlanID:    noVlan,
+		TxVlanID:    noVlan,
+		Author:      "contrail-windows-docker",
+		Time:        time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// Error is returned when vRouter Agent can't be reached or refuses a request.
+type Error struct {
+	// Op is the operation that failed, like "add port".
+	Op string
+	// VifUuid identifies the port.
+	VifUuid string
+	// StatusCode is HTTP status of agent's response, or 0 if there was no response.
+	StatusCode int
+	// Message is the body of agent's response.
+	Message string
+	// Err is the transport error, if there was no response.
+	Err error
+}
+
+func (e *Error) Error() string {
+	what := strings.TrimSpace(e.Op + " " + e.VifUuid)
+	if e.Err != nil {
+		return fmt.Sprintf("vRouter Agent %s: %v", what, e.Err)
+	}
+	return fmt.Sprintf("vRouter Agent %s: HTTP %d: %s", what, e.StatusCode, e.Message)
+}
+
+// IsNotFound checks if err is an Error returned because agent doesn't know the port.
+func IsNotFound(err error) bool {
+	agentErr, ok := err.(*Error)
+	return ok && agentErr.StatusCode == http.StatusNotFound
+}
+
+// IsUnreachable checks if err is an Error returned because agent didn't respond.
+func IsUnreachable(err error) bool {
+	agentErr, ok := err.(*Error)
+	return ok && agentErr.StatusCode == 0
+}
+
+// Client talks to vRouter Agent's port API, which is served on localhost.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient creates a client of vRouter Agent at url (like DefaultURL). Requests that take
+// longer than timeout fail.
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{
+		url: strings.TrimRight(url, "/"),
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// AddPort tells vRouter Agent to handle the port. Adding a port that agent already knows
+// updates it.
+func (c *Client) AddPort(port Port) error {
+	body, err := json.Marshal(newPortRequest(port))
 	if err != nil {
-		log.Errorf("When calling Agent API wrapper script: %s, %s", stdout, stderr)
 		return err
 	}
-	return nil
+
+	req, err := http.NewRequest(http.MethodPost, c.url+"/port", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Debugln("Adding port to vRouter Agent:", string(body))
+	return c.do(req, "add port", port.VifUuid)
 }
 
-func DeletePort(vifUuid string) error {
-	stdout, stderr, err := common.Call("python", common.AgentAPIWrapperScriptPath(),
-		"delete", vifUuid)
-	log.Debugln("Called Agent API wrapper: ", stdout)
+// DeletePort tells vRouter Agent to stop handling the port. If agent doesn't know the port,
+// returned error satisfies IsNotFound.
+func (c *Client) DeletePort(vifUuid string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.url+"/port/"+vifUuid, nil)
 	if err != nil {
-		log.Errorf("When calling Agent API wrapper script: %s, %s", stdout, stderr)
 		return err
 	}
+
+	log.Debugln("Deleting port from vRouter Agent:", vifUuid)
+	return c.do(req, "delete port", vifUuid)
+}
+
+// GetPort checks if vRouter Agent handles the port. If it doesn't, returned error satisfies
+// IsNotFound.
+func (c *Client) GetPort(vifUuid string) error {
+	req, err := http.NewRequest(http.MethodGet, c.url+"/port/"+vifUuid, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, "get port", vifUuid)
+}
+
+// Ping checks if vRouter Agent is reachable. Any response, even an error one, means it is.
+func (c *Client) Ping() error {
+	resp, err := c.httpClient.Get(c.url + "/port")
+	if err != nil {
+		return &Error{
+			Op:  "ping",
+			Err: err,
+		}
+	}
+	resp.Body.Close()
 	return nil
 }
+
+func (c *Client) do(req *http.Request, op, vifUuid string) (err error) {
+	defer observeRequest(op, time.Now(), &err)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		agentErr := &Error{
+			Op:      op,
+			VifUuid: vifUuid,
+			Err:     err,
+		}
+		log.Errorln(agentErr)
+		return agentErr
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if len(msg) > maxErrorMessageLen {
+		msg = msg[:maxErrorMessageLen]
+	}
+	agentErr := &Error{
+		Op:         op,
+		VifUuid:    vifUuid,
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(msg)),
+	}
+	log.Errorln(agentErr)
+	return agentErr
+}
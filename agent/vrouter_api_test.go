@@ -0,0 +1,215 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func TestAgent(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("agent_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "vRouter Agent client test suite",
+		[]Reporter{junitReporter})
+}
+
+type receivedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+var _ = Describe("vRouter Agent client", func() {
+
+	var server *httptest.Server
+	var client *Client
+	var received chan receivedRequest
+	var respStatus int
+	var respBody string
+
+	testPort := Port{
+		VmUuid:        "vm_uuid",
+		VifUuid:       "vif_uuid",
+		VnUuid:        "vn_uuid",
+		VmProjectUuid: "project_uuid",
+		IfName:        "Container--vif_uuid",
+		Mac:           "11:22:aa:bb:cc:dd",
+		IPAddress:     "10.0.0.2",
+		DisplayName:   "container_id",
+		Type:          NovaVMPort,
+	}
+
+	BeforeEach(func() {
+		received = make(chan receivedRequest, 1)
+		respStatus = http.StatusOK
+		respBody = ""
+
+		// stands in for vRouter Agent
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received <- receivedRequest{
+				method: r.Method,
+				path:   r.URL.Path,
+				header: r.Header,
+				body:   body,
+			}
+			w.WriteHeader(respStatus)
+			w.Write([]byte(respBody))
+		}))
+		client = NewClient(server.URL, time.Second)
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("on AddPort", func() {
+		It("posts port description", func() {
+			err := client.AddPort(testPort)
+			Expect(err).ToNot(HaveOccurred())
+
+			var req receivedRequest
+			Eventually(received).Should(Receive(&req))
+			Expect(req.method).To(Equal(http.MethodPost))
+			Expect(req.path).To(Equal("/port"))
+			Expect(req.header.Get("Content-Type")).To(Equal("application/json"))
+
+			var body map[string]interface{}
+			Expect(json.Unmarshal(req.body, &body)).To(Succeed())
+			Expect(body).To(HaveKeyWithValue("id", "vif_uuid"))
+			Expect(body).To(HaveKeyWithValue("instance-id", "vm_uuid"))
+			Expect(body).To(HaveKeyWithValue("vn-id", "vn_uuid"))
+			Expect(body).To(HaveKeyWithValue("vm-project-id", "project_uuid"))
+			Expect(body).To(HaveKeyWithValue("system-name", "Container--vif_uuid"))
+			Expect(body).To(HaveKeyWithValue("mac-address", "11:22:aa:bb:cc:dd"))
+			Expect(body).To(HaveKeyWithValue("ip-address", "10.0.0.2"))
+			Expect(body).To(HaveKeyWithValue("display-name", "container_id"))
+			Expect(body).To(HaveKeyWithValue("type", BeEquivalentTo(NovaVMPort)))
+			Expect(body).To(HaveKeyWithValue("rx-vlan-id", BeEquivalentTo(noVlan)))
+			Expect(body).To(HaveKeyWithValue("tx-vlan-id", BeEquivalentTo(noVlan)))
+		})
+		It("returns error with agent's response if agent refuses the port", func() {
+			respStatus = http.StatusInternalServerError
+			respBody = "Invalid port"
+
+			err := client.AddPort(testPort)
+			Expect(err).To(HaveOccurred())
+			agentErr, ok := err.(*Error)
+			Expect(ok).To(BeTrue())
+			Expect(agentErr.Op).To(Equal("add port"))
+			Expect(agentErr.VifUuid).To(Equal("vif_uuid"))
+			Expect(agentErr.StatusCode).To(Equal(http.StatusInternalServerError))
+			Expect(agentErr.Message).To(Equal("Invalid port"))
+		})
+	})
+
+	Context("on DeletePort", func() {
+		It("sends delete request for the port", func() {
+			err := client.DeletePort("vif_uuid")
+			Expect(err).ToNot(HaveOccurred())
+
+			var req receivedRequest
+			Eventually(received).Should(Receive(&req))
+			Expect(req.method).To(Equal(http.MethodDelete))
+			Expect(req.path).To(Equal("/port/vif_uuid"))
+		})
+		It("returns not found error if agent doesn't know the port", func() {
+			respStatus = http.StatusNotFound
+
+			err := client.DeletePort("vif_uuid")
+			Expect(err).To(HaveOccurred())
+			Expect(IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Context("on GetPort", func() {
+		It("asks about the port", func() {
+			err := client.GetPort("vif_uuid")
+			Expect(err).ToNot(HaveOccurred())
+
+			var req receivedRequest
+			Eventually(received).Should(Receive(&req))
+			Expect(req.method).To(Equal(http.MethodGet))
+			Expect(req.path).To(Equal("/port/vif_uuid"))
+		})
+		It("returns not found error if agent doesn't know the port", func() {
+			respStatus = http.StatusNotFound
+
+			err := client.GetPort("vif_uuid")
+			Expect(IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Context("on Ping", func() {
+		It("succeeds even if agent responds with error", func() {
+			respStatus = http.StatusNotFound
+
+			err := client.Ping()
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns unreachable error if agent doesn't respond", func() {
+			server.Close()
+
+			err := client.Ping()
+			Expect(IsUnreachable(err)).To(BeTrue())
+		})
+	})
+
+	It("returns error without status if agent is unreachable", func() {
+		server.Close()
+
+		err := client.DeletePort("vif_uuid")
+		Expect(err).To(HaveOccurred())
+		Expect(IsNotFound(err)).To(BeFalse())
+		Expect(IsUnreachable(err)).To(BeTrue())
+		agentErr, ok := err.(*Error)
+		Expect(ok).To(BeTrue())
+		Expect(agentErr.StatusCode).To(Equal(0))
+		Expect(agentErr.Err).To(HaveOccurred())
+	})
+
+	It("times out if agent doesn't respond", func() {
+		blocked := make(chan interface{})
+		slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			<-blocked
+		}))
+		defer slowServer.Close()
+		defer close(blocked)
+
+		slowClient := NewClient(slowServer.URL, 100*time.Millisecond)
+		err := slowClient.AddPort(testPort)
+		Expect(err).To(HaveOccurred())
+		agentErr, ok := err.(*Error)
+		Expect(ok).To(BeTrue())
+		Expect(agentErr.Err).To(HaveOccurred())
+	})
+})
@@ -18,19 +18,22 @@ package common
 import (
 	"os"
 	"path/filepath"
-
-	"github.com/kardianos/osext"
+	"time"
 )
 
 const (
-	// DomainName specifies domain name in Contrail
-	DomainName = "default-domain"
-
 	// DriverName is name of the driver that is to be specified during docker network creation
 	DriverName = "Contrail"
 
-	// HNSNetworkPrefix is a prefix given too all HNS network names managed by the driver
-	HNSNetworkPrefix = "Contrail"
+	// IpamDriverName is name of the IPAM driver that is to be specified during docker network
+	// creation (--ipam-driver)
+	IpamDriverName = "ContrailIPAM"
+
+	// IpamLocalAddressSpace is the name of default local address space exposed by IPAM driver
+	IpamLocalAddressSpace = "ContrailLocal"
+
+	// IpamGlobalAddressSpace is the name of default global address space exposed by IPAM driver
+	IpamGlobalAddressSpace = "ContrailGlobal"
 
 	// WinServiceName is the name of the Windows Service that the driver is ran as
 	WinServiceName = "ContrailDockerDriver"
@@ -39,30 +42,43 @@ const (
 	// having a virtual switch
 	RootNetworkName = "ContrailRootNetwork"
 
-	// AdapterReconnectTimeout is a time (in ms) to wait for adapter to reacquire IP after a new
+	// HNSTransparentInterfaceName is the name of transparent HNS vswitch interface name
+	HNSTransparentInterfaceName = "vEthernet (HNSTransparent)"
+
+	// HyperVExtensionName is the name of vRouter Hyper-V Extension
+	HyperVExtensionName = "vRouter forwarding extension"
+
+	// StateFileName is a file name of driver's persistent state store
+	StateFileName = "state.json"
+
+	// ConfigFileName is a file name of driver's configuration file
+	ConfigFileName = "contrail-docker-driver.conf"
+)
+
+// Tunables below can be changed in configuration file (see config package) before the driver
+// starts.
+var (
+	// DomainName specifies domain name in Contrail
+	DomainName = "default-domain"
+
+	// HNSNetworkPrefix is a prefix given too all HNS network names managed by the driver
+	HNSNetworkPrefix = "Contrail"
+
+	// AdapterReconnectTimeout is a time to wait for adapter to reacquire IP after a new
 	// HNS network is created. https://github.com/Microsoft/hcsshim/issues/108
-	AdapterReconnectTimeout = 15000
+	AdapterReconnectTimeout = 15 * time.Second
 
-	// AdapterPollingRate is rate (in ms) of polling of network adapter while waiting for it to
+	// AdapterPollingRate is rate of polling of network adapter while waiting for it to
 	// reacquire IP.
-	AdapterPollingRate = 300
-
-	// HNSTransparentInterfaceName is the name of transparent HNS vswitch interface name
-	HNSTransparentInterfaceName = "vEthernet (HNSTransparent)"
+	AdapterPollingRate = 300 * time.Millisecond
 
-	// PipePollingTimeout is time (in ms) to wait for named pipe to appear/disappear in the
+	// PipePollingTimeout is time to wait for named pipe to appear/disappear in the
 	// filesystem
-	PipePollingTimeout = 5000
+	PipePollingTimeout = 5 * time.Second
 
-	// PipePollingRate is rate (in ms) of polling named pipe if it appeared/disappeared in the
+	// PipePollingRate is rate of polling named pipe if it appeared/disappeared in the
 	// filesystem yet
-	PipePollingRate = 300
-
-	// HyperVExtensionName is the name of vRouter Hyper-V Extension
-	HyperVExtensionName = "vRouter forwarding extension"
-
-	// AgentAPIWrapperScriptFileName is a file name of python script that calls vRouter Agent API
-	AgentAPIWrapperScriptFileName = "agent_api.py"
+	PipePollingRate = 300 * time.Millisecond
 )
 
 // PluginSpecDir returns path to directory where docker daemon looks for plugin spec files.
@@ -75,8 +91,19 @@ func PluginSpecFilePath() string {
 	return filepath.Join(PluginSpecDir(), DriverName+".spec")
 }
 
-// AgentAPIWrapperScriptPath is path to python script that calls vRouter Agent API
-func AgentAPIWrapperScriptPath() string {
-	executable, _ := osext.Executable()
-	return filepath.Join(filepath.Dir(executable), AgentAPIWrapperScriptFileName)
+// StateFilePath returns path to file where driver persists docker networks and endpoints it
+// manages, so that they're known after service restart.
+func StateFilePath() string {
+	return filepath.Join(os.Getenv("ProgramData"), WinServiceName, StateFileName)
+}
+
+// ConfigFilePath returns path to driver's configuration file.
+func ConfigFilePath() string {
+	return filepath.Join(os.Getenv("ProgramData"), WinServiceName, ConfigFileName)
+}
+
+// AgentPortsDir returns path to directory where vRouter Agent looks for port files when it
+// starts. It's the Windows counterpart of /var/lib/contrail/ports.
+func AgentPortsDir() string {
+	return filepath.Join(os.Getenv("ProgramData"), "Contrail", "var", "lib", "contrail", "ports")
 }
@@ -104,9 +104,9 @@ func WaitForInterface(ifname AdapterName) error {
 			}
 		}
 
-		if time.Since(pollingStart) > time.Millisecond*AdapterReconnectTimeout {
+		if time.Since(pollingStart) > AdapterReconnectTimeout {
 			return errors.New("Waited for net adapter to reconnect for too long.")
 		}
-		time.Sleep(time.Millisecond * AdapterPollingRate)
+		time.Sleep(AdapterPollingRate)
 	}
 }
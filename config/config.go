@@ -0,0 +1,522 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config implements driver's configuration. Every setting can be given in an INI file
+// (like contrail-vrouter-agent.conf), an environment variable and a command line flag. They take
+// precedence in order: flag, environment variable, file, default.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/agent"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/transport"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sections of configuration file.
+const (
+	SectionDefault    = "DEFAULT"
+	SectionController = "CONTROLLER"
+	SectionKeystone   = "KEYSTONE"
+	SectionAgent      = "AGENT"
+	SectionTransport  = "TRANSPORT"
+	SectionHNS        = "HNS"
+)
+
+// envPrefix prefixes environment variables of settings, except for Keystone ones, which use
+// names of OpenStack clients.
+const envPrefix = "CONTRAIL_DRIVER_"
+
+// maskedSecret replaces values of secrets when configuration is shown.
+const maskedSecret = "********"
+
+// DefaultConfig is the [DEFAULT] section: general settings of the driver.
+type DefaultConfig struct {
+	Adapter            string
+	VSwitchName        string
+	Scope              string
+	LogPath            string
+	LogLevel           string
+	StateFile          string
+	GCMode             string
+	MetricsAddress     string
+	ForceAsInteractive bool
+}
+
+// ControllerConfig is the [CONTROLLER] section: Contrail Controller API.
+type ControllerConfig struct {
+	IP                 string
+	Port               int
+	DomainName         string
+	Scheme             string
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// KeystoneConfig is the [KEYSTONE] section: credentials of Contrail Controller API.
+type KeystoneConfig struct {
+	AuthURL    string
+	Username   string
+	TenantName string
+	Password   string
+	Token      string
+
+	// Keystone v3 only, used when auth URL ends with /v3.
+	UserID            string
+	UserDomainName    string
+	UserDomainID      string
+	ProjectName       string
+	ProjectID         string
+	ProjectDomainName string
+	ProjectDomainID   string
+}
+
+// AgentConfig is the [AGENT] section: vRouter Agent port API.
+type AgentConfig struct {
+	URL          string
+	RequirePort  bool
+	PollInterval time.Duration
+	PortsDir     string
+	Timeout      time.Duration
+	MaxRetries   int
+	Backoff      time.Duration
+}
+
+// TransportConfig is the [TRANSPORT] section: how docker reaches the driver.
+type TransportConfig struct {
+	Kind               string
+	PluginSpecDir      string
+	TCPHost            string
+	SocketDir          string
+	TLSCert            string
+	TLSKey             string
+	TLSCA              string
+	TLSClientCert      string
+	TLSClientKey       string
+	PipePollingTimeout time.Duration
+	PipePollingRate    time.Duration
+}
+
+// HNSConfig is the [HNS] section: HNS networks and net adapter.
+type HNSConfig struct {
+	NetworkPrefix           string
+	AdapterReconnectTimeout time.Duration
+	AdapterPollingRate      time.Duration
+}
+
+// Config is configuration of the driver, grouped like sections of configuration file.
+type Config struct {
+	Default    DefaultConfig
+	Controller ControllerConfig
+	Keystone   KeystoneConfig
+	Agent      AgentConfig
+	Transport  TransportConfig
+	HNS        HNSConfig
+}
+
+// Defaults returns configuration with default values of all settings.
+func Defaults() *Config {
+	return &Config{
+		Default: DefaultConfig{
+			Adapter:     "Ethernet0",
+			VSwitchName: "Layered <adapter>",
+			Scope:       "local",
+			LogPath:     common.LogFilepath(),
+			LogLevel:    "Info",
+			StateFile:   common.StateFilePath(),
+			GCMode:      "on",
+		},
+		Controller: ControllerConfig{
+			IP:         "127.0.0.1",
+			Port:       8082,
+			DomainName: common.DomainName,
+			Scheme:     "http",
+		},
+		Agent: AgentConfig{
+			URL:          agent.DefaultURL,
+			PollInterval: agent.DefaultPollInterval,
+			PortsDir:     common.AgentPortsDir(),
+			Timeout:      agent.DefaultTimeout,
+			MaxRetries:   agent.DefaultMaxRetries,
+			Backoff:      agent.DefaultBackoff,
+		},
+		Transport: TransportConfig{
+			Kind:               transport.NamedPipe,
+			PluginSpecDir:      common.PluginSpecDir(),
+			TCPHost:            "127.0.0.1",
+			SocketDir:          "/run/docker/plugins",
+			PipePollingTimeout: common.PipePollingTimeout,
+			PipePollingRate:    common.PipePollingRate,
+		},
+		HNS: HNSConfig{
+			NetworkPrefix:           common.HNSNetworkPrefix,
+			AdapterReconnectTimeout: common.AdapterReconnectTimeout,
+			AdapterPollingRate:      common.AdapterPollingRate,
+		},
+	}
+}
+
+// setting is a single configuration value, with its names in configuration file, environment
+// and command line.
+type setting struct {
+	section string
+	key     string
+	flag    string
+	env     string
+	help    string
+	secret  bool
+	value   value
+}
+
+// settings lists all settings of c, in order in which they're shown.
+func (c *Config) settings() []setting {
+	d := &c.Default
+	ctrl := &c.Controller
+	k := &c.Keystone
+	a := &c.Agent
+	t := &c.Transport
+	h := &c.HNS
+	return []setting{
+		{section: SectionDefault, key: "adapter", flag: "adapter",
+			value: (*stringValue)(&d.Adapter), help: "net adapter for HNS switch, must be physical"},
+		{section: SectionDefault, key: "vswitch_name", flag: "vswitchName",
+			value: (*stringValue)(&d.VSwitchName),
+			help: "Name of Transparent virtual switch. Special wildcard \"<adapter>\" will be " +
+				"interpretted as value of netAdapter parameter. For example, if netAdapter is " +
+				"\"Ethernet0\", then vswitchName will equal \"Layered Ethernet0\". You can use " +
+				"Get-VMSwitch PowerShell command to check how the switch is called on your " +
+				"version of OS."},
+		{section: SectionDefault, key: "scope", flag: "scope", value: (*stringValue)(&d.Scope),
+			help: "scope of the driver (possible values: local|global). Use global scope to " +
+				"allow docker swarm to allocate Contrail networks on manager nodes."},
+		{section: SectionDefault, key: "log_path", flag: "logPath",
+			value: (*stringValue)(&d.LogPath), help: "log filepath"},
+		{section: SectionDefault, key: "log_level", flag: "logLevel",
+			value: (*stringValue)(&d.LogLevel),
+			help:  "log verbosity (possible values: Debug|Info|Warn|Error|Fatal|Panic)"},
+		{section: SectionDefault, key: "state_file", flag: "stateFile",
+			value: (*stringValue)(&d.StateFile),
+			help: "path of file where docker networks and endpoints managed by the driver are " +
+				"stored"},
+		{section: SectionDefault, key: "gc", flag: "gc", value: (*stringValue)(&d.GCMode),
+			help: "garbage collection of orphaned HNS and Contrail objects on startup " +
+				"(possible values: on|off|dry-run). In dry-run mode, orphans are only reported " +
+				"in the log."},
+		{section: SectionDefault, key: "metrics_address", flag: "metricsAddress",
+			value: (*stringValue)(&d.MetricsAddress),
+			help: "address (like 127.0.0.1:9127) to serve Prometheus metrics on, at /metrics. " +
+				"If empty, metrics are not served."},
+		{section: SectionDefault, key: "force_as_interactive", flag: "forceAsInteractive",
+			value: (*boolValue)(&d.ForceAsInteractive),
+			help: "if true, will act as if ran from interactive mode. This is useful when " +
+				"running this service from remote powershell session, because they're not " +
+				"interactive."},
+
+		{section: SectionController, key: "ip", flag: "controllerIP",
+			value: (*stringValue)(&ctrl.IP),
+			help: "IP address of Contrail Controller API. Several addresses of config nodes, " +
+				"separated by commas, can be given for failover. Address may have its own " +
+				"port, like 10.0.0.1:8082."},
+		{section: SectionController, key: "port", flag: "controllerPort",
+			value: (*intValue)(&ctrl.Port),
+			help:  "port of Contrail Controller API, for addresses without one"},
+		{section: SectionController, key: "domain_name", flag: "domainName",
+			value: (*stringValue)(&ctrl.DomainName),
+			help:  "Contrail domain of projects (tenants) that networks belong to"},
+		{section: SectionController, key: "scheme", flag: "controllerScheme",
+			value: (*stringValue)(&ctrl.Scheme),
+			help:  "scheme of Contrail Controller API (possible values: http|https)"},
+		{section: SectionController, key: "ca_file", flag: "controllerCAFile",
+			value: (*stringValue)(&ctrl.CAFile),
+			help: "certificates of CAs that Contrail Controller API and Keystone are verified " +
+				"with. If empty, system CAs are used."},
+		{section: SectionController, key: "cert_file", flag: "controllerCert",
+			value: (*stringValue)(&ctrl.CertFile),
+			help: "client certificate for Contrail Controller API and Keystone, if they " +
+				"require one"},
+		{section: SectionController, key: "key_file", flag: "controllerKey",
+			value: (*stringValue)(&ctrl.KeyFile), help: "private key of -controllerCert"},
+		{section: SectionController, key: "insecure_skip_verify",
+			flag: "controllerInsecureSkipVerify", value: (*boolValue)(&ctrl.InsecureSkipVerify),
+			help: "if true, certificates of Contrail Controller API and Keystone are not " +
+				"verified. Use it only for testing."},
+
+		{section: SectionKeystone, key: "auth_url", flag: "os_auth_url", env: "OS_AUTH_URL",
+			value: (*stringValue)(&k.AuthURL), help: "Keystone auth url"},
+		{section: SectionKeystone, key: "username", flag: "os_username", env: "OS_USERNAME",
+			value: (*stringValue)(&k.Username), help: "Contrail username"},
+		{section: SectionKeystone, key: "tenant_name", flag: "os_tenant_name",
+			env: "OS_TENANT_NAME", value: (*stringValue)(&k.TenantName), help: "Tenant name"},
+		{section: SectionKeystone, key: "password", flag: "os_password", env: "OS_PASSWORD",
+			value: (*stringValue)(&k.Password), help: "Contrail password", secret: true},
+		{section: SectionKeystone, key: "token", flag: "os_token", env: "OS_TOKEN",
+			value: (*stringValue)(&k.Token), help: "Keystone token", secret: true},
+		{section: SectionKeystone, key: "user_id", flag: "os_user_id", env: "OS_USER_ID",
+			value: (*stringValue)(&k.UserID), help: "Keystone v3 user ID, instead of username"},
+		{section: SectionKeystone, key: "user_domain_name", flag: "os_user_domain_name",
+			env: "OS_USER_DOMAIN_NAME", value: (*stringValue)(&k.UserDomainName),
+			help: "Keystone v3 domain name of user"},
+		{section: SectionKeystone, key: "user_domain_id", flag: "os_user_domain_id",
+			env: "OS_USER_DOMAIN_ID", value: (*stringValue)(&k.UserDomainID),
+			help: "Keystone v3 domain ID of user"},
+		{section: SectionKeystone, key: "project_name", flag: "os_project_name",
+			env: "OS_PROJECT_NAME", value: (*stringValue)(&k.ProjectName),
+			help: "Keystone v3 project name. Defaults to tenant name."},
+		{section: SectionKeystone, key: "project_id", flag: "os_project_id", env: "OS_PROJECT_ID",
+			value: (*stringValue)(&k.ProjectID), help: "Keystone v3 project ID"},
+		{section: SectionKeystone, key: "project_domain_name", flag: "os_project_domain_name",
+			env: "OS_PROJECT_DOMAIN_NAME", value: (*stringValue)(&k.ProjectDomainName),
+			help: "Keystone v3 domain name of project"},
+		{section: SectionKeystone, key: "project_domain_id", flag: "os_project_domain_id",
+			env: "OS_PROJECT_DOMAIN_ID", value: (*stringValue)(&k.ProjectDomainID),
+			help: "Keystone v3 domain ID of project"},
+
+		{section: SectionAgent, key: "url", flag: "agentURL", value: (*stringValue)(&a.URL),
+			help: "URL of vRouter Agent port API"},
+		{section: SectionAgent, key: "require_port", flag: "requireAgentPort",
+			value: (*boolValue)(&a.RequirePort),
+			help: "if true, containers fail to start when their interfaces can't be added to " +
+				"vRouter Agent. Otherwise, the failure is only logged."},
+		{section: SectionAgent, key: "poll_interval", flag: "agentPollInterval",
+			value: (*durationValue)(&a.PollInterval),
+			help: "how often vRouter Agent is checked for restarts, after which ports of all " +
+				"containers are added to it again. Zero disables the checks."},
+		{section: SectionAgent, key: "ports_dir", flag: "agentPortsDir",
+			value: (*stringValue)(&a.PortsDir),
+			help: "directory where port files are kept for vRouter Agent to read when it " +
+				"starts. If empty, port files are not written."},
+		{section: SectionAgent, key: "timeout", flag: "agentTimeout",
+			value: (*durationValue)(&a.Timeout),
+			help:  "time to wait for vRouter Agent to handle a request"},
+		{section: SectionAgent, key: "max_retries", flag: "agentMaxRetries",
+			value: (*intValue)(&a.MaxRetries),
+			help:  "how many times a failed request to vRouter Agent is retried"},
+		{section: SectionAgent, key: "backoff", flag: "agentBackoff",
+			value: (*durationValue)(&a.Backoff),
+			help: "delay before the first retry of request to vRouter Agent. It doubles with " +
+				"every retry."},
+
+		{section: SectionTransport, key: "kind", flag: "transport",
+			value: (*stringValue)(&t.Kind),
+			help:  "how docker reaches the driver (possible values: npipe|tcp|unix)"},
+		{section: SectionTransport, key: "plugin_spec_dir", flag: "pluginSpecDir",
+			value: (*stringValue)(&t.PluginSpecDir),
+			help:  "directory where docker looks for plugin spec files"},
+		{section: SectionTransport, key: "tcp_host", flag: "tcpHost",
+			value: (*stringValue)(&t.TCPHost),
+			help: "address to listen on with tcp transport. Ports are chosen by the system " +
+				"and published in spec files."},
+		{section: SectionTransport, key: "socket_dir", flag: "socketDir",
+			value: (*stringValue)(&t.SocketDir), help: "directory of sockets with unix transport"},
+		{section: SectionTransport, key: "tls_cert", flag: "tlsCert",
+			value: (*stringValue)(&t.TLSCert),
+			help:  "certificate to serve tcp transport with. If empty, TLS is not used."},
+		{section: SectionTransport, key: "tls_key", flag: "tlsKey",
+			value: (*stringValue)(&t.TLSKey), help: "private key of -tlsCert"},
+		{section: SectionTransport, key: "tls_ca", flag: "tlsCA", value: (*stringValue)(&t.TLSCA),
+			help: "certificate of CA that signed -tlsCert and -tlsClientCert. Docker uses it " +
+				"to verify the driver."},
+		{section: SectionTransport, key: "tls_client_cert", flag: "tlsClientCert",
+			value: (*stringValue)(&t.TLSClientCert),
+			help: "certificate docker authenticates itself with. If empty, docker doesn't have " +
+				"to present a certificate."},
+		{section: SectionTransport, key: "tls_client_key", flag: "tlsClientKey",
+			value: (*stringValue)(&t.TLSClientKey), help: "private key of -tlsClientCert"},
+		{section: SectionTransport, key: "pipe_polling_timeout", flag: "pipePollingTimeout",
+			value: (*durationValue)(&t.PipePollingTimeout),
+			help:  "time to wait for named pipe to appear or disappear"},
+		{section: SectionTransport, key: "pipe_polling_rate", flag: "pipePollingRate",
+			value: (*durationValue)(&t.PipePollingRate),
+			help:  "rate of polling named pipe while waiting for it"},
+
+		{section: SectionHNS, key: "network_prefix", flag: "hnsNetworkPrefix",
+			value: (*stringValue)(&h.NetworkPrefix),
+			help:  "prefix of names of HNS networks managed by the driver"},
+		{section: SectionHNS, key: "adapter_reconnect_timeout", flag: "adapterReconnectTimeout",
+			value: (*durationValue)(&h.AdapterReconnectTimeout),
+			help:  "time to wait for net adapter to reacquire IP after HNS network is created"},
+		{section: SectionHNS, key: "adapter_polling_rate", flag: "adapterPollingRate",
+			value: (*durationValue)(&h.AdapterPollingRate),
+			help:  "rate of polling net adapter while waiting for it to reacquire IP"},
+	}
+}
+
+// envName returns name of environment variable of setting s.
+func (s *setting) envName() string {
+	if s.env != "" {
+		return s.env
+	}
+	if s.section == SectionDefault {
+		return envPrefix + strings.ToUpper(s.key)
+	}
+	return envPrefix + s.section + "_" + strings.ToUpper(s.key)
+}
+
+// Validate checks if settings have values that make sense together.
+func (c *Config) Validate() error {
+	if c.Default.Scope != "local" && c.Default.Scope != "global" {
+		return fmt.Errorf("Invalid scope: %s", c.Default.Scope)
+	}
+	if _, err := log.ParseLevel(c.Default.LogLevel); err != nil {
+		return err
+	}
+	switch c.Default.GCMode {
+	case "on", "off", "dry-run":
+	default:
+		return fmt.Errorf("Invalid garbage collection mode: %s", c.Default.GCMode)
+	}
+	if c.Controller.Port <= 0 || c.Controller.Port > 65535 {
+		return fmt.Errorf("Invalid controller port: %d", c.Controller.Port)
+	}
+	if _, err := c.ControllerEndpoints(); err != nil {
+		return err
+	}
+	if c.Controller.DomainName == "" {
+		return errors.New("Contrail domain name is empty")
+	}
+	if c.Controller.Scheme != "http" && c.Controller.Scheme != "https" {
+		return fmt.Errorf("Invalid controller scheme: %s", c.Controller.Scheme)
+	}
+	if (c.Controller.CertFile == "") != (c.Controller.KeyFile == "") {
+		return errors.New("Controller client certificate and key must be set together")
+	}
+	if c.Agent.PollInterval < 0 {
+		return fmt.Errorf("Invalid vRouter Agent poll interval: %s", c.Agent.PollInterval)
+	}
+	if c.Agent.Timeout <= 0 {
+		return fmt.Errorf("Invalid vRouter Agent timeout: %s", c.Agent.Timeout)
+	}
+	if c.Agent.MaxRetries < 0 {
+		return fmt.Errorf("Invalid number of vRouter Agent retries: %d", c.Agent.MaxRetries)
+	}
+	if c.Agent.Backoff < 0 {
+		return fmt.Errorf("Invalid vRouter Agent backoff: %s", c.Agent.Backoff)
+	}
+	if _, err := transport.New(c.TransportConfig()); err != nil {
+		return err
+	}
+	if c.Transport.PipePollingTimeout <= 0 || c.Transport.PipePollingRate <= 0 {
+		return errors.New("Named pipe polling timeout and rate must be positive")
+	}
+	if c.HNS.NetworkPrefix == "" || strings.Contains(c.HNS.NetworkPrefix, ":") {
+		return fmt.Errorf("Invalid HNS network prefix: %q", c.HNS.NetworkPrefix)
+	}
+	if c.HNS.AdapterReconnectTimeout <= 0 || c.HNS.AdapterPollingRate <= 0 {
+		return errors.New("Adapter reconnect timeout and polling rate must be positive")
+	}
+	return nil
+}
+
+// TransportConfig returns configuration of transport package.
+func (c *Config) TransportConfig() transport.Config {
+	t := c.Transport
+	config := transport.Config{
+		Kind:      t.Kind,
+		SpecDir:   t.PluginSpecDir,
+		TCPHost:   t.TCPHost,
+		SocketDir: t.SocketDir,
+	}
+	if t.TLSCert != "" || t.TLSKey != "" {
+		config.TLS = &transport.TLSConfig{
+			CertFile:       t.TLSCert,
+			KeyFile:        t.TLSKey,
+			CAFile:         t.TLSCA,
+			ClientCertFile: t.TLSClientCert,
+			ClientKeyFile:  t.TLSClientKey,
+		}
+	}
+	return config
+}
+
+// ControllerEndpoints returns addresses (host:port) of Contrail Controller API endpoints, in
+// order of preference.
+func (c *Config) ControllerEndpoints() ([]string, error) {
+	var endpoints []string
+	for _, address := range strings.Split(c.Controller.IP, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			// no port
+			host, port = address, strconv.Itoa(c.Controller.Port)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 || host == "" {
+			return nil, fmt.Errorf("Invalid controller address: %s", address)
+		}
+		endpoints = append(endpoints, net.JoinHostPort(host, port))
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("Controller address is empty")
+	}
+	return endpoints, nil
+}
+
+// VSwitchName returns name of vswitch, with "<adapter>" wildcard replaced.
+func (c *Config) VSwitchName() string {
+	return strings.Replace(c.Default.VSwitchName, "<adapter>", c.Default.Adapter, -1)
+}
+
+// ApplyTunables sets tunables of common package. It must be called before the driver starts.
+func (c *Config) ApplyTunables() {
+	common.DomainName = c.Controller.DomainName
+	common.HNSNetworkPrefix = c.HNS.NetworkPrefix
+	common.AdapterReconnectTimeout = c.HNS.AdapterReconnectTimeout
+	common.AdapterPollingRate = c.HNS.AdapterPollingRate
+	common.PipePollingTimeout = c.Transport.PipePollingTimeout
+	common.PipePollingRate = c.Transport.PipePollingRate
+}
+
+// Masked returns copy of c with secrets masked, so that it can be shown.
+func (c *Config) Masked() *Config {
+	masked := *c
+	for _, s := range masked.settings() {
+		if s.secret && s.value.String() != "" {
+			s.value.Set(maskedSecret)
+		}
+	}
+	return &masked
+}
+
+// Write writes c in format of configuration file, with every setting documented.
+func (c *Config) Write(w io.Writer) error {
+	section := ""
+	for _, s := range c.settings() {
+		if s.section != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			section = s.section
+			fmt.Fprintf(w, "[%s]\n", section)
+		}
+		fmt.Fprintf(w, "# %s (flag -%s, environment variable %s)\n", s.help, s.flag,
+			s.envName())
+		if _, err := fmt.Fprintf(w, "%s = %s\n", s.key, s.value.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
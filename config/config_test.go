@@ -0,0 +1,225 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+)
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("config_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Config test suite",
+		[]Reporter{junitReporter})
+}
+
+var _ = Describe("Config", func() {
+
+	var dir string
+	var configPath string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "config")
+		Expect(err).ToNot(HaveOccurred())
+		configPath = filepath.Join(dir, "driver.conf")
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+		os.Unsetenv("CONTRAIL_DRIVER_CONTROLLER_IP")
+		os.Unsetenv("CONTRAIL_DRIVER_SCOPE")
+		os.Unsetenv("OS_PASSWORD")
+	})
+
+	writeFile := func(contents string) {
+		Expect(ioutil.WriteFile(configPath, []byte(contents), 0644)).To(Succeed())
+	}
+
+	newLoader := func(args ...string) (*Loader, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		loader := NewLoader(fs)
+		return loader, fs.Parse(args)
+	}
+
+	load := func(args ...string) (*Config, error) {
+		loader, err := newLoader(args...)
+		if err != nil {
+			return nil, err
+		}
+		return loader.Load()
+	}
+
+	It("has valid defaults", func() {
+		Expect(Defaults().Validate()).To(Succeed())
+	})
+
+	It("ignores missing default file", func() {
+		c, err := load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Controller.IP).To(Equal("127.0.0.1"))
+	})
+
+	It("fails if specified file is missing", func() {
+		_, err := load("-config", configPath)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reads file in INI format", func() {
+		writeFile(`
+# comment
+adapter = Ethernet1
+
+[CONTROLLER]
+ip = 10.0.0.1
+port = 8083
+
+; another comment
+[agent]
+poll_interval = 30s
+require_port = true
+`)
+		c, err := load("-config", configPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Default.Adapter).To(Equal("Ethernet1"))
+		Expect(c.VSwitchName()).To(Equal("Layered Ethernet1"))
+		Expect(c.Controller.IP).To(Equal("10.0.0.1"))
+		Expect(c.Controller.Port).To(Equal(8083))
+		Expect(c.Agent.PollInterval).To(Equal(30 * time.Second))
+		Expect(c.Agent.RequirePort).To(BeTrue())
+	})
+
+	It("prefers flags to environment, and environment to file", func() {
+		writeFile("[CONTROLLER]\nip = 10.0.0.1\nport = 8083\n[DEFAULT]\nscope = global\n")
+		os.Setenv("CONTRAIL_DRIVER_CONTROLLER_IP", "10.0.0.2")
+		os.Setenv("CONTRAIL_DRIVER_SCOPE", "local")
+
+		c, err := load("-config", configPath, "-scope", "global")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Controller.IP).To(Equal("10.0.0.2"))
+		Expect(c.Controller.Port).To(Equal(8083))
+		Expect(c.Default.Scope).To(Equal("global"))
+	})
+
+	It("lists controller endpoints with default port", func() {
+		c, err := load("-controllerIP", "10.0.0.1, 10.0.0.2:8083,", "-controllerPort", "8082")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.ControllerEndpoints()).To(Equal([]string{"10.0.0.1:8082", "10.0.0.2:8083"}))
+
+		_, err = load("-controllerIP", "10.0.0.1:eighty")
+		Expect(err).To(HaveOccurred())
+		_, err = load("-controllerIP", ",")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reads Keystone credentials from OpenStack environment variables", func() {
+		os.Setenv("OS_PASSWORD", "secret")
+		c, err := load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Keystone.Password).To(Equal("secret"))
+	})
+
+	It("accepts boolean flags without value", func() {
+		c, err := load("-requireAgentPort")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Agent.RequirePort).To(BeTrue())
+	})
+
+	It("rejects unknown settings in file", func() {
+		writeFile("[CONTROLLER]\naddress = 10.0.0.1\n")
+		_, err := load("-config", configPath)
+		Expect(err).To(MatchError(ContainSubstring("line 2")))
+	})
+
+	It("rejects malformed values", func() {
+		writeFile("[CONTROLLER]\nport = eighty\n")
+		_, err := load("-config", configPath)
+		Expect(err).To(HaveOccurred())
+
+		_, err = load("-agentPollInterval", "often")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects invalid values", func() {
+		_, err := load("-scope", "universal")
+		Expect(err).To(HaveOccurred())
+		_, err = load("-controllerPort", "70000")
+		Expect(err).To(HaveOccurred())
+		_, err = load("-transport", "carrier-pigeon")
+		Expect(err).To(HaveOccurred())
+		_, err = load("-hnsNetworkPrefix", "Con:trail")
+		Expect(err).To(HaveOccurred())
+		_, err = load("-controllerScheme", "ftp")
+		Expect(err).To(HaveOccurred())
+		_, err = load("-controllerCert", "client.pem")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("loads invalid values without validation, so that they can be shown", func() {
+		loader, err := newLoader("-scope", "universal")
+		Expect(err).ToNot(HaveOccurred())
+		c, err := loader.LoadUnvalidated()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Default.Scope).To(Equal("universal"))
+		Expect(c.Validate()).ToNot(Succeed())
+
+		loader, err = newLoader("-config", configPath)
+		Expect(err).ToNot(HaveOccurred())
+		writeFile("[CONTROLLER]\nport = eighty\n")
+		_, err = loader.LoadUnvalidated()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("masks secrets when shown", func() {
+		c := Defaults()
+		c.Keystone.Username = "admin"
+		c.Keystone.Password = "secret"
+
+		masked := c.Masked()
+		Expect(masked.Keystone.Username).To(Equal("admin"))
+		Expect(masked.Keystone.Password).To(Equal(maskedSecret))
+		Expect(masked.Keystone.Token).To(BeEmpty())
+		Expect(c.Keystone.Password).To(Equal("secret"))
+
+		var buf bytes.Buffer
+		Expect(masked.Write(&buf)).To(Succeed())
+		Expect(buf.String()).ToNot(ContainSubstring("secret"))
+		Expect(buf.String()).To(ContainSubstring("password = " + maskedSecret))
+	})
+
+	It("reads what it writes", func() {
+		c := Defaults()
+		c.Controller.IP = "10.0.0.1"
+		c.HNS.AdapterPollingRate = time.Second
+
+		var buf bytes.Buffer
+		Expect(c.Write(&buf)).To(Succeed())
+		read := Defaults()
+		Expect(read.Read(strings.NewReader(buf.String()))).To(Succeed())
+		Expect(read).To(Equal(c))
+	})
+})
@@ -0,0 +1,234 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/common"
+)
+
+// value is a setting that can be parsed from string, like flag.Value.
+type value interface {
+	String() string
+	Set(string) error
+}
+
+type stringValue string
+
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+
+type intValue int
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v = intValue(i)
+	return nil
+}
+
+type boolValue bool
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration (like 300ms or 5s)", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+// flagRecorder remembers value of flag, so that it can be applied after the file and
+// environment.
+type flagRecorder struct {
+	setting *setting
+	value   *string
+}
+
+func (f *flagRecorder) String() string {
+	if f.setting == nil {
+		// flag package calls String of zero value to check if default is empty
+		return ""
+	}
+	return f.setting.value.String()
+}
+
+func (f *flagRecorder) Set(s string) error {
+	// Validate right away, so that flag package reports which flag is wrong.
+	if err := f.setting.value.Set(s); err != nil {
+		return err
+	}
+	f.value = &s
+	return nil
+}
+
+func (f *flagRecorder) IsBoolFlag() bool {
+	_, isBool := f.setting.value.(*boolValue)
+	return isBool
+}
+
+// Loader defines flags of all settings and loads configuration from them, the environment and
+// configuration file.
+type Loader struct {
+	flags      *flag.FlagSet
+	configPath *string
+	recorders  []*flagRecorder
+}
+
+// NewLoader defines flags of all settings, and -config flag with path of configuration file,
+// in flags.
+func NewLoader(flags *flag.FlagSet) *Loader {
+	l := &Loader{flags: flags}
+	l.configPath = flags.String("config", common.ConfigFilePath(),
+		"configuration file. Settings in it are overridden by environment variables, and "+
+			"those by flags. Missing default file is ignored.")
+	defaults := Defaults()
+	for _, s := range defaults.settings() {
+		s := s
+		recorder := &flagRecorder{setting: &s}
+		flags.Var(recorder, s.flag, s.help)
+		l.recorders = append(l.recorders, recorder)
+	}
+	return l
+}
+
+// Load returns configuration from configuration file, the environment and flags, which must be
+// parsed already. The result is validated.
+func (l *Loader) Load() (*Config, error) {
+	c, err := l.LoadUnvalidated()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadUnvalidated works like Load, but doesn't validate the result, so that invalid
+// configuration can be shown. Malformed values are still rejected.
+func (l *Loader) LoadUnvalidated() (*Config, error) {
+	c := Defaults()
+	settings := c.settings()
+
+	if err := l.loadFile(c); err != nil {
+		return nil, err
+	}
+
+	for i := range settings {
+		s := &settings[i]
+		if value := os.Getenv(s.envName()); value != "" {
+			if err := s.value.Set(value); err != nil {
+				return nil, fmt.Errorf("Invalid environment variable %s: %v", s.envName(), err)
+			}
+		}
+	}
+
+	// Recorders are in the same order as settings.
+	for i, recorder := range l.recorders {
+		if recorder.value != nil {
+			if err := settings[i].value.Set(*recorder.value); err != nil {
+				return nil, fmt.Errorf("Invalid flag -%s: %v", settings[i].flag, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+func (l *Loader) loadFile(c *Config) error {
+	explicit := false
+	l.flags.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+
+	f, err := os.Open(*l.configPath)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := c.Read(f); err != nil {
+		return fmt.Errorf("Invalid configuration file %s: %v", *l.configPath, err)
+	}
+	return nil
+}
+
+// Read sets settings from configuration file in INI format. Keys outside of any section belong
+// to [DEFAULT]. Lines starting with # or ; are comments.
+func (c *Config) Read(r io.Reader) error {
+	settings := c.settings()
+	section := SectionDefault
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToUpper(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		var found *setting
+		for i := range settings {
+			if settings[i].section == section && settings[i].key == key {
+				found = &settings[i]
+			}
+		}
+		if found == nil {
+			return fmt.Errorf("line %d: unknown setting %s in section [%s]", lineNo, key,
+				section)
+		}
+		if err := found.value.Set(value); err != nil {
+			return fmt.Errorf("line %d: invalid %s: %v", lineNo, key, err)
+		}
+	}
+	return scanner.Err()
+}
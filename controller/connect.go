@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Juniper/contrail-go-api"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnavailable is returned by requests of controller that isn't connected to Contrail yet.
+var ErrUnavailable = errors.New("Contrail controller unavailable: still connecting to it " +
+	"in the background")
+
+// Backoff of connection attempts in the background. It doubles after every failed attempt, up
+// to the maximum.
+var (
+	connectBackoff    = time.Second
+	maxConnectBackoff = time.Minute
+)
+
+// Connector connects to Contrail API served by several config nodes. Endpoints, TLS transport
+// and Keystone client are set up once, when it's created, so that only authentication is
+// repeated when connecting is retried.
+type Connector struct {
+	keystone keystoneClient
+	client   contrail.ApiClient
+}
+
+// NewConnector prepares connecting to Contrail API at addresses in host:port form. If tlsConfig
+// is nil, Contrail API and Keystone are reached with default TLS settings.
+func NewConnector(addresses []string, keys *KeystoneEnvs, tlsConfig *TLSConfig) (*Connector,
+	error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("No Contrail API endpoints")
+	}
+
+	if keys.Os_auth_url == "" {
+		// this corner case is not handled by keystone.Authenticate. Causes panic.
+		return nil, errors.New("Empty Keystone auth URL")
+	}
+
+	// Contrail API and Keystone get their own transport, so that TLS configuration doesn't
+	// affect other HTTP clients of the process. contrail-go-api clients reach Contrail API
+	// through relays that use it.
+	var transport http.RoundTripper
+	var relaySecret string
+	if tlsConfig != nil {
+		tlsTransport, err := newTLSTransport(tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		transport = tlsTransport
+		if relaySecret, err = newRelaySecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	keystone, err := newKeystoneClient(keys, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiClients []contrail.ApiClient
+	for _, address := range addresses {
+		host, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid port of Contrail API endpoint %s", address)
+		}
+		var apiClient *contrail.Client
+		if transport != nil {
+			relay, err := newAPIRelay(tlsConfig.Scheme, address, transport, relaySecret)
+			if err != nil {
+				return nil, err
+			}
+			apiClient = contrail.NewClient("127.0.0.1", relay.port())
+			apiClient.SetAuthenticator(&relayAuthenticator{Authenticator: keystone,
+				secret: relaySecret})
+		} else {
+			apiClient = contrail.NewClient(host, port)
+			apiClient.SetAuthenticator(keystone)
+		}
+		apiClients = append(apiClients, newInstrumentedApiClient(apiClient))
+	}
+	log.Infoln("Contrail API endpoints:", strings.Join(addresses, ", "))
+
+	return &Connector{
+		keystone: keystone,
+		client:   newRetryingApiClient(newFailoverApiClient(addresses, apiClients), keystone),
+	}, nil
+}
+
+// Connect authenticates in Keystone and returns controller that sends requests to Contrail API.
+// It may be called again if it fails.
+func (c *Connector) Connect() (*Controller, error) {
+	if err := c.keystone.Authenticate(); err != nil {
+		log.Errorln("Keystone error:", err)
+		return nil, err
+	}
+	return &Controller{ApiClient: c.client}, nil
+}
+
+// lazyApiClient fails all requests with ErrUnavailable until it's given client connected to
+// Contrail API, and then passes requests to it.
+type lazyApiClient struct {
+	interceptedApiClient
+	mutex  sync.RWMutex
+	client contrail.ApiClient
+}
+
+func newLazyApiClient() *lazyApiClient {
+	l := &lazyApiClient{}
+	l.interceptedApiClient = interceptedApiClient{intercept: l.do}
+	return l
+}
+
+func (l *lazyApiClient) get() (contrail.ApiClient, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if l.client == nil {
+		return nil, ErrUnavailable
+	}
+	return l.client, nil
+}
+
+func (l *lazyApiClient) set(client contrail.ApiClient) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.client = client
+}
+
+func (l *lazyApiClient) do(op, typename string, idempotent bool,
+	call func(client contrail.ApiClient) error) error {
+	client, err := l.get()
+	if err != nil {
+		return err
+	}
+	return call(client)
+}
+
+// NewDisconnectedController returns controller whose requests fail with ErrUnavailable until
+// it's connected with KeepConnecting.
+func NewDisconnectedController() *Controller {
+	return &Controller{ApiClient: newLazyApiClient()}
+}
+
+// IsConnected tells if requests can be sent to Contrail API already.
+func (c *Controller) IsConnected() bool {
+	lazy, isLazy := c.ApiClient.(*lazyApiClient)
+	if !isLazy {
+		return true
+	}
+	_, err := lazy.get()
+	return err == nil
+}
+
+// KeepConnecting calls connect, like Connector.Connect, until it succeeds, with backoff between
+// attempts, and then makes c send requests through the connected controller. c must be created
+// with NewDisconnectedController. It returns false if stop is closed before connect succeeds.
+func (c *Controller) KeepConnecting(connect func() (*Controller, error),
+	stop <-chan struct{}) bool {
+	lazy := c.ApiClient.(*lazyApiClient)
+	backoff := connectBackoff
+	for {
+		connected, err := connect()
+		if err == nil {
+			lazy.set(connected.ApiClient)
+			log.Infoln("Connected to Contrail controller")
+			return true
+		}
+		log.Warnf("Failed to connect to Contrail controller, retrying in %v: %v", backoff, err)
+		select {
+		case <-stop:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
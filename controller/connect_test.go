@@ -0,0 +1,103 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connecting in the background", func() {
+
+	var savedBackoff time.Duration
+
+	BeforeEach(func() {
+		savedBackoff = connectBackoff
+		connectBackoff = time.Millisecond
+	})
+	AfterEach(func() {
+		connectBackoff = savedBackoff
+	})
+
+	It("fails requests as unavailable until connected", func() {
+		c := NewDisconnectedController()
+		Expect(c.IsConnected()).To(BeFalse())
+		_, err := c.ApiClient.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).To(Equal(ErrUnavailable))
+
+		apiClient := &failingApiClient{}
+		attempts := 0
+		connected := c.KeepConnecting(func() (*Controller, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, errors.New("Keystone is down")
+			}
+			return &Controller{ApiClient: apiClient}, nil
+		}, nil)
+		Expect(connected).To(BeTrue())
+		Expect(attempts).To(Equal(3))
+
+		Expect(c.IsConnected()).To(BeTrue())
+		_, err = c.ApiClient.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(apiClient.requests).To(Equal(1))
+	})
+
+	It("only authenticates again when connecting is retried", func() {
+		keystone := &countingKeystone{err: errors.New("Keystone is down")}
+		apiClient := &failingApiClient{}
+		connector := &Connector{keystone: keystone, client: apiClient}
+		c := NewDisconnectedController()
+		attempts := 0
+		connected := c.KeepConnecting(func() (*Controller, error) {
+			attempts++
+			if attempts == 2 {
+				keystone.err = nil
+			}
+			return connector.Connect()
+		}, nil)
+		Expect(connected).To(BeTrue())
+		Expect(keystone.authentications).To(Equal(2))
+
+		_, err := c.ApiClient.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(apiClient.requests).To(Equal(1))
+	})
+
+	It("refuses invalid endpoints before connecting", func() {
+		keys := &KeystoneEnvs{Os_auth_url: "http://keystone:5000/v2.0"}
+		_, err := NewConnector(nil, keys, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewConnector([]string{"contrail:api"}, keys, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewConnector([]string{"contrail:8082"}, &KeystoneEnvs{}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("stops connecting when asked to", func() {
+		c := NewDisconnectedController()
+		stop := make(chan struct{})
+		close(stop)
+		connected := c.KeepConnecting(func() (*Controller, error) {
+			return nil, errors.New("Keystone is down")
+		}, stop)
+		Expect(connected).To(BeFalse())
+		Expect(c.IsConnected()).To(BeFalse())
+	})
+})
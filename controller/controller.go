@@ -16,19 +16,32 @@
 package controller
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	net_ "net"
 	"os"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Juniper/contrail-go-api"
 	"github.com/Juniper/contrail-go-api/types"
 	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/pborman/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// IPv4Family is Contrail's name of IPv4 instance IP family
+	IPv4Family = "v4"
+
+	// IPv6Family is Contrail's name of IPv6 instance IP family
+	IPv6Family = "v6"
+)
+
 type Info struct {
 }
 
@@ -42,8 +55,20 @@ type KeystoneEnvs struct {
 	Os_tenant_name string
 	Os_password    string
 	Os_token       string
+
+	// Keystone v3 only. Project name defaults to Os_tenant_name.
+	Os_user_id             string
+	Os_user_domain_name    string
+	Os_user_domain_id      string
+	Os_project_name        string
+	Os_project_id          string
+	Os_project_domain_name string
+	Os_project_domain_id   string
 }
 
+// keystoneV2Vars is the number of leading KeystoneEnvs fields that Keystone v2.0 uses.
+const keystoneV2Vars = 5
+
 func (k *KeystoneEnvs) LoadFromEnvironment() {
 
 	k.Os_auth_url = k.GetenvIfNil(k.Os_auth_url, "OS_AUTH_URL")
@@ -51,10 +76,17 @@ func (k *KeystoneEnvs) LoadFromEnvironment() {
 	k.Os_tenant_name = k.GetenvIfNil(k.Os_tenant_name, "OS_TENANT_NAME")
 	k.Os_password = k.GetenvIfNil(k.Os_password, "OS_PASSWORD")
 	k.Os_token = k.GetenvIfNil(k.Os_token, "OS_TOKEN")
-
-	// print a warning for every empty variable
+	k.Os_user_id = k.GetenvIfNil(k.Os_user_id, "OS_USER_ID")
+	k.Os_user_domain_name = k.GetenvIfNil(k.Os_user_domain_name, "OS_USER_DOMAIN_NAME")
+	k.Os_user_domain_id = k.GetenvIfNil(k.Os_user_domain_id, "OS_USER_DOMAIN_ID")
+	k.Os_project_name = k.GetenvIfNil(k.Os_project_name, "OS_PROJECT_NAME")
+	k.Os_project_id = k.GetenvIfNil(k.Os_project_id, "OS_PROJECT_ID")
+	k.Os_project_domain_name = k.GetenvIfNil(k.Os_project_domain_name, "OS_PROJECT_DOMAIN_NAME")
+	k.Os_project_domain_id = k.GetenvIfNil(k.Os_project_domain_id, "OS_PROJECT_DOMAIN_ID")
+
+	// print a warning for every empty variable, except for optional v3 ones
 	keysReflection := reflect.ValueOf(*k)
-	for i := 0; i < keysReflection.NumField(); i++ {
+	for i := 0; i < keystoneV2Vars; i++ {
 		if keysReflection.Field(i).String() == "" {
 			log.Warn("Keystone variable empty: ", keysReflection.Type().Field(i).Name)
 		}
@@ -69,24 +101,23 @@ func (k *KeystoneEnvs) GetenvIfNil(currentVal, envVar string) string {
 	return currentVal
 }
 
-func NewController(ip string, port int, keys *KeystoneEnvs) (*Controller, error) {
-	client := &Controller{}
-	client.ApiClient = contrail.NewClient(ip, port)
-
-	if keys.Os_auth_url == "" {
-		// this corner case is not handled by keystone.Authenticate. Causes panic.
-		return nil, errors.New("Empty Keystone auth URL")
-	}
+// NewController connects to Contrail API at ip:port. If tlsConfig is nil, Contrail API and
+// Keystone are reached with default TLS settings.
+func NewController(ip string, port int, keys *KeystoneEnvs, tlsConfig *TLSConfig) (*Controller,
+	error) {
+	return NewControllerWithEndpoints([]string{net_.JoinHostPort(ip, strconv.Itoa(port))}, keys,
+		tlsConfig)
+}
 
-	keystone := contrail.NewKeepaliveKeystoneClient(keys.Os_auth_url, keys.Os_tenant_name,
-		keys.Os_username, keys.Os_password, keys.Os_token)
-	err := keystone.Authenticate()
+// NewControllerWithEndpoints connects to Contrail API served by several config nodes, at
+// addresses in host:port form. Requests go to the first healthy one, and fail over to others.
+func NewControllerWithEndpoints(addresses []string, keys *KeystoneEnvs,
+	tlsConfig *TLSConfig) (*Controller, error) {
+	connector, err := NewConnector(addresses, keys, tlsConfig)
 	if err != nil {
-		log.Errorln("Keystone error:", err)
 		return nil, err
 	}
-	client.ApiClient.(*contrail.Client).SetAuthenticator(keystone)
-	return client, nil
+	return connector.Connect()
 }
 
 func (c *Controller) GetNetwork(tenantName, networkName string) (*types.VirtualNetwork,
@@ -100,16 +131,8 @@ func (c *Controller) GetNetwork(tenantName, networkName string) (*types.VirtualN
 	return net, nil
 }
 
-// GetIpamSubnet returns IPAM subnet of specified virtual network with specified CIDR.
-// If virtual network has only one subnet, CIDR is ignored.
-func (c *Controller) GetIpamSubnet(net *types.VirtualNetwork, CIDR string) (
-	*types.IpamSubnetType, error) {
-
-	if strings.HasPrefix(CIDR, "0.0.0.0") {
-		// this means that the user didn't provide a subnet
-		CIDR = ""
-	}
-
+// GetIpamSubnets returns all IPAM subnets of specified virtual network.
+func (c *Controller) GetIpamSubnets(net *types.VirtualNetwork) ([]types.IpamSubnetType, error) {
 	ipamReferences, err := net.GetNetworkIpamRefs()
 	if err != nil {
 		log.Errorf("Failed to get ipam references: %v", err)
@@ -124,6 +147,18 @@ func (c *Controller) GetIpamSubnet(net *types.VirtualNetwork, CIDR string) (
 			allIpamSubnets = append(allIpamSubnets, ipamSubnet)
 		}
 	}
+	return allIpamSubnets, nil
+}
+
+// GetIpamSubnet returns IPAM subnet of specified virtual network with specified CIDR.
+// If virtual network has only one subnet, CIDR may be empty.
+func (c *Controller) GetIpamSubnet(net *types.VirtualNetwork, CIDR string) (
+	*types.IpamSubnetType, error) {
+
+	allIpamSubnets, err := c.GetIpamSubnets(net)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(allIpamSubnets) == 0 {
 		err = errors.New("No Ipam subnets found")
@@ -141,13 +176,21 @@ func (c *Controller) GetIpamSubnet(net *types.VirtualNetwork, CIDR string) (
 		return &allIpamSubnets[0], nil
 	}
 
+	_, ipNet, err := net_.ParseCIDR(CIDR)
+	if err != nil {
+		log.Errorf("Failed to parse subnet CIDR %s: %v", CIDR, err)
+		return nil, err
+	}
+
 	// there are multiple subnets to choose from
 	for _, ipam := range allIpamSubnets {
+		thisIP := net_.ParseIP(ipam.Subnet.IpPrefix)
+		thisMask := net_.CIDRMask(ipam.Subnet.IpPrefixLen, len(thisIP.To16())*8)
+		if thisIP.To4() != nil {
+			thisMask = net_.CIDRMask(ipam.Subnet.IpPrefixLen, 32)
+		}
 
-		thisCIDR := fmt.Sprintf("%s/%v", ipam.Subnet.IpPrefix,
-			ipam.Subnet.IpPrefixLen)
-
-		if thisCIDR == CIDR {
+		if thisIP.Equal(ipNet.IP) && bytes.Equal(thisMask, ipNet.Mask) {
 			return &ipam, nil
 		}
 	}
@@ -157,6 +200,92 @@ func (c *Controller) GetIpamSubnet(net *types.VirtualNetwork, CIDR string) (
 	return nil, err
 }
 
+// GetIpamSubnetOfFamily returns the only IPAM subnet of specified virtual network in specified
+// IP family ("v4" or "v6").
+func (c *Controller) GetIpamSubnetOfFamily(net *types.VirtualNetwork, family string) (
+	*types.IpamSubnetType, error) {
+
+	allIpamSubnets, err := c.GetIpamSubnets(net)
+	if err != nil {
+		return nil, err
+	}
+
+	var familySubnets []types.IpamSubnetType
+	for _, ipam := range allIpamSubnets {
+		if SubnetFamily(&ipam) == family {
+			familySubnets = append(familySubnets, ipam)
+		}
+	}
+	if len(familySubnets) != 1 {
+		err = fmt.Errorf("Expected one Contrail %s subnet, found %v", family,
+			len(familySubnets))
+		log.Error(err)
+		return nil, err
+	}
+	return &familySubnets[0], nil
+}
+
+// DHCP options that carry DNS configuration, as defined in RFC 2132. Contrail accepts both option
+// codes and names.
+var (
+	dhcpOptionDnsServers = []string{"6", "domain-name-servers"}
+	dhcpOptionDomainName = []string{"15", "domain-name"}
+)
+
+// GetDnsConfig returns DNS servers and domain that endpoints in specified subnet should use.
+// DHCP options of the subnet take precedence over its DNS nameservers and DNS server address.
+func (c *Controller) GetDnsConfig(subnet *types.IpamSubnetType) (servers []string,
+	domain string) {
+
+	if value, ok := dhcpOption(subnet, dhcpOptionDnsServers); ok {
+		servers = strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	} else if len(subnet.DnsNameservers) > 0 {
+		servers = subnet.DnsNameservers
+	} else if ip := net_.ParseIP(subnet.DnsServerAddress); ip != nil && !ip.IsUnspecified() {
+		servers = []string{subnet.DnsServerAddress}
+	}
+
+	domain, _ = dhcpOption(subnet, dhcpOptionDomainName)
+	return servers, domain
+}
+
+// GetHostRoutes returns host routes configured in specified subnet.
+func (c *Controller) GetHostRoutes(subnet *types.IpamSubnetType) []types.RouteType {
+	if subnet.HostRoutes == nil {
+		return nil
+	}
+	return subnet.HostRoutes.Route
+}
+
+func dhcpOption(subnet *types.IpamSubnetType, names []string) (string, bool) {
+	if subnet.DhcpOptionList == nil {
+		return "", false
+	}
+	for _, option := range subnet.DhcpOptionList.DhcpOption {
+		for _, name := range names {
+			if option.DhcpOptionName == name {
+				return option.DhcpOptionValue, true
+			}
+		}
+	}
+	return "", false
+}
+
+// IpFamily returns Contrail IP family name ("v4" or "v6") of specified address.
+func IpFamily(ip net_.IP) string {
+	if ip.To4() != nil {
+		return IPv4Family
+	}
+	return IPv6Family
+}
+
+// SubnetFamily returns Contrail IP family name ("v4" or "v6") of specified IPAM subnet.
+func SubnetFamily(subnet *types.IpamSubnetType) string {
+	return IpFamily(net_.ParseIP(subnet.Subnet.IpPrefix))
+}
+
 func (c *Controller) GetDefaultGatewayIp(subnet *types.IpamSubnetType) (string, error) {
 	gw := subnet.DefaultGateway
 	if gw == "" {
@@ -167,46 +296,105 @@ func (c *Controller) GetDefaultGatewayIp(subnet *types.IpamSubnetType) (string,
 	return gw, nil
 }
 
+// GetOrCreateInstance returns virtual machine representing a container and makes sure that
+// specified interface belongs to it. A container connected to multiple networks is a single
+// virtual machine with an interface per network. Returns true if the interface wasn't attached
+// to the instance before, so that DetachInstance undoes the call.
 func (c *Controller) GetOrCreateInstance(vif *types.VirtualMachineInterface, containerId string) (
-	*types.VirtualMachine, error) {
+	*types.VirtualMachine, bool, error) {
 	instance, err := types.VirtualMachineByName(c.ApiClient, containerId)
-	if err == nil && instance != nil {
-		return instance, nil
+	if err != nil || instance == nil {
+		instance, err = c.createInstance(containerId)
+		if err != nil {
+			return nil, false, err
+		}
 	}
 
-	instance = new(types.VirtualMachine)
-	instance.SetName(containerId)
-	err = c.ApiClient.Create(instance)
+	vmRefs, err := vif.GetVirtualMachineRefs()
 	if err != nil {
-		log.Errorf("Failed to create instance: %v", err)
-		return nil, err
+		log.Errorf("Failed to get instance references of vif: %v", err)
+		return nil, false, err
 	}
-
-	createdInstance, err := types.VirtualMachineByName(c.ApiClient, containerId)
-	if err != nil {
-		log.Errorf("Failed to retreive instance %s by name: %v", containerId, err)
-		return nil, err
+	for _, ref := range vmRefs {
+		if ref.Uuid == instance.GetUuid() {
+			return instance, false, nil
+		}
 	}
-	log.Infoln("Created instance: ", createdInstance.GetFQName())
 
-	err = vif.AddVirtualMachine(createdInstance)
+	err = vif.AddVirtualMachine(instance)
 	if err != nil {
 		log.Errorf("Failed to add instance to vif")
-		return nil, err
+		return nil, false, err
 	}
 	err = c.ApiClient.Update(vif)
 	if err != nil {
 		log.Errorf("Failed to update vif")
+		return nil, false, err
+	}
+
+	return instance, true, nil
+}
+
+// DetachInstance removes reference from interface to instance. Instance is deleted if it has no
+// interfaces left.
+func (c *Controller) DetachInstance(vif *types.VirtualMachineInterface,
+	instance *types.VirtualMachine) error {
+	if err := vif.DeleteVirtualMachine(instance.GetUuid()); err != nil {
+		log.Errorf("Failed to remove instance from vif: %v", err)
+		return err
+	}
+	if err := c.ApiClient.Update(vif); err != nil {
+		log.Errorf("Failed to update vif: %v", err)
+		return err
+	}
+
+	// back references are only fetched once, so instance has to be read again
+	instance, err := types.VirtualMachineByUuid(c.ApiClient, instance.GetUuid())
+	if err != nil {
+		log.Errorf("Failed to get instance: %v", err)
+		return err
+	}
+	ifaceRefs, err := instance.GetVirtualMachineInterfaceBackRefs()
+	if err != nil {
+		log.Errorf("Failed to get vif back references of instance: %v", err)
+		return err
+	}
+	if len(ifaceRefs) == 0 {
+		log.Infoln("Instance has no interfaces left, deleting it:", instance.GetUuid())
+		return c.DeleteElementRecursive(instance)
+	}
+	return nil
+}
+
+func (c *Controller) createInstance(containerId string) (*types.VirtualMachine, error) {
+	instance := new(types.VirtualMachine)
+	instance.SetName(containerId)
+	err := c.ApiClient.Create(instance)
+	if err != nil {
+		log.Errorf("Failed to create instance: %v", err)
 		return nil, err
 	}
 
+	createdInstance, err := types.VirtualMachineByName(c.ApiClient, containerId)
+	if err != nil {
+		log.Errorf("Failed to retreive instance %s by name: %v", containerId, err)
+		return nil, err
+	}
+	log.Infoln("Created instance: ", createdInstance.GetFQName())
 	return createdInstance, nil
 }
 
+// InterfaceName returns name of virtual machine interface that connects docker endpoint to
+// Contrail network. Endpoints are unique per docker network, so a container can have many
+// interfaces, one per network.
+func InterfaceName(networkName, endpointId string) string {
+	return fmt.Sprintf("%s_%s", networkName, endpointId)
+}
+
 func (c *Controller) GetExistingInterface(net *types.VirtualNetwork, tenantName,
-	containerId string) (*types.VirtualMachineInterface, error) {
+	ifaceName string) (*types.VirtualMachineInterface, error) {
 
-	fqName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, containerId)
+	fqName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, ifaceName)
 	iface, err := types.VirtualMachineInterfaceByName(c.ApiClient, fqName)
 	if err != nil {
 		return nil, err
@@ -219,35 +407,224 @@ func (c *Controller) GetExistingInterface(net *types.VirtualNetwork, tenantName,
 	return nil, errors.New("Interface does not exist")
 }
 
+// InterfaceParams are optional settings of virtual machine interface.
+type InterfaceParams struct {
+	// Mac is MAC address of the interface. If empty, Contrail generates one.
+	Mac string
+	// SecurityGroups are attached to the interface.
+	SecurityGroups []*types.SecurityGroup
+}
+
 func (c *Controller) GetOrCreateInterface(net *types.VirtualNetwork, tenantName,
-	containerId string) (*types.VirtualMachineInterface, error) {
+	ifaceName string) (*types.VirtualMachineInterface, error) {
+	iface, _, err := c.GetOrCreateInterfaceWithParams(net, tenantName, ifaceName,
+		InterfaceParams{})
+	return iface, err
+}
 
-	fqName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, containerId)
+// GetOrCreateInterfaceWithParams works like GetOrCreateInterface, but the interface is set up
+// according to specified params. Contrail doesn't allow to change MAC of existing interface, so
+// it is an error if the interface already exists with another MAC. Security groups missing on
+// existing interface are attached to it. Returns true if the interface was created.
+func (c *Controller) GetOrCreateInterfaceWithParams(net *types.VirtualNetwork, tenantName,
+	ifaceName string, params InterfaceParams) (*types.VirtualMachineInterface, bool, error) {
+
+	mac := params.Mac
+	if mac != "" {
+		hwAddr, err := net_.ParseMAC(mac)
+		if err != nil {
+			log.Errorf("Invalid MAC address %s: %v", mac, err)
+			return nil, false, err
+		}
+		// contrail MACs are like 11:22:aa:bb:cc:dd
+		mac = hwAddr.String()
+	}
+
+	fqName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, ifaceName)
 	iface, err := types.VirtualMachineInterfaceByName(c.ApiClient, fqName)
 	if err == nil && iface != nil {
-		return iface, nil
+		if mac != "" {
+			existingMac, err := c.GetInterfaceMac(iface)
+			if err != nil {
+				return nil, false, err
+			}
+			if existingMac != mac {
+				err = fmt.Errorf("Interface %s already exists with MAC %s", ifaceName,
+					existingMac)
+				log.Error(err)
+				return nil, false, err
+			}
+		}
+		if _, err := c.AddSecurityGroups(iface, params.SecurityGroups); err != nil {
+			return nil, false, err
+		}
+		return iface, false, nil
 	}
 
 	iface = new(types.VirtualMachineInterface)
-	iface.SetFQName("project", []string{common.DomainName, tenantName, containerId})
+	iface.SetFQName("project", []string{common.DomainName, tenantName, ifaceName})
+	if mac != "" {
+		macs := new(types.MacAddressesType)
+		macs.AddMacAddress(mac)
+		iface.SetVirtualMachineInterfaceMacAddresses(macs)
+	}
+	for _, group := range params.SecurityGroups {
+		err = iface.AddSecurityGroup(group)
+		if err != nil {
+			log.Errorf("Failed to add security group to interface: %v", err)
+			return nil, false, err
+		}
+	}
 	err = iface.AddVirtualNetwork(net)
 	if err != nil {
 		log.Errorf("Failed to add network to interface: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 	err = c.ApiClient.Create(iface)
 	if err != nil {
 		log.Errorf("Failed to create interface: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 
 	createdIface, err := types.VirtualMachineInterfaceByName(c.ApiClient, fqName)
 	if err != nil {
 		log.Errorf("Failed to retreive vmi %s by name: %v", fqName, err)
-		return nil, err
+		return nil, false, err
 	}
 	log.Infoln("Created instance: ", createdIface.GetFQName())
-	return createdIface, nil
+	return createdIface, true, nil
+}
+
+// AddSecurityGroups attaches security groups to virtual machine interface, skipping the ones that
+// are already attached. It returns the groups that were attached, so that they can be removed
+// again with RemoveSecurityGroups.
+func (c *Controller) AddSecurityGroups(iface *types.VirtualMachineInterface,
+	groups []*types.SecurityGroup) ([]*types.SecurityGroup, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	refs, err := iface.GetSecurityGroupRefs()
+	if err != nil {
+		log.Errorf("Failed to get security groups of interface: %v", err)
+		return nil, err
+	}
+	attached := make(map[string]bool)
+	for _, ref := range refs {
+		attached[ref.Uuid] = true
+	}
+
+	var added []*types.SecurityGroup
+	for _, group := range groups {
+		if attached[group.GetUuid()] {
+			continue
+		}
+		if err := iface.AddSecurityGroup(group); err != nil {
+			log.Errorf("Failed to add security group to interface: %v", err)
+			return nil, err
+		}
+		attached[group.GetUuid()] = true
+		added = append(added, group)
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	if err := c.ApiClient.Update(iface); err != nil {
+		log.Errorf("Failed to update interface: %v", err)
+		return nil, err
+	}
+	return added, nil
+}
+
+// RemoveSecurityGroups detaches security groups from virtual machine interface.
+func (c *Controller) RemoveSecurityGroups(iface *types.VirtualMachineInterface,
+	groups []*types.SecurityGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	for _, group := range groups {
+		if err := iface.DeleteSecurityGroup(group.GetUuid()); err != nil {
+			log.Errorf("Failed to remove security group from interface: %v", err)
+			return err
+		}
+	}
+	if err := c.ApiClient.Update(iface); err != nil {
+		log.Errorf("Failed to update interface: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetSecurityGroups looks up security groups by their UUIDs or FQNames. Names without domain and
+// project (like "web") are looked up in specified tenant.
+func (c *Controller) GetSecurityGroups(tenantName string, names []string) (
+	[]*types.SecurityGroup, error) {
+	var groups []*types.SecurityGroup
+	for _, name := range names {
+		var group *types.SecurityGroup
+		var err error
+		if uuid.Parse(name) != nil {
+			group, err = types.SecurityGroupByUuid(c.ApiClient, name)
+		} else {
+			fqName := name
+			if !strings.Contains(name, ":") {
+				fqName = fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, name)
+			}
+			group, err = types.SecurityGroupByName(c.ApiClient, fqName)
+		}
+		if err != nil || group == nil {
+			err = fmt.Errorf("Security group %s doesn't exist: %v", name, err)
+			log.Error(err)
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// DeleteInterface removes virtual machine interface together with its instance IPs. Virtual
+// machine that the interface belonged to is removed too, if it has no interfaces left.
+func (c *Controller) DeleteInterface(iface *types.VirtualMachineInterface) error {
+	vmRefs, err := iface.GetVirtualMachineRefs()
+	if err != nil {
+		log.Errorf("Failed to get instance references of vif: %v", err)
+		return err
+	}
+
+	if err := c.DeleteElementRecursive(iface); err != nil {
+		return err
+	}
+
+	for _, ref := range vmRefs {
+		instance, err := types.VirtualMachineByUuid(c.ApiClient, ref.Uuid)
+		if err != nil {
+			log.Warnf("Instance %s of removed vif wasn't found: %v", ref.Uuid, err)
+			continue
+		}
+		ifaceRefs, err := instance.GetVirtualMachineInterfaceBackRefs()
+		if err != nil {
+			log.Errorf("Failed to get vif back references of instance: %v", err)
+			return err
+		}
+		if len(ifaceRefs) == 0 {
+			log.Infoln("Instance has no interfaces left, deleting it:", instance.GetUuid())
+			if err := c.DeleteElementRecursive(instance); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetInterfaceByUuid returns interface with specified UUID.
+func (c *Controller) GetInterfaceByUuid(uuid string) (*types.VirtualMachineInterface, error) {
+	iface, err := types.VirtualMachineInterfaceByUuid(c.ApiClient, uuid)
+	if err != nil {
+		log.Errorf("Failed to get interface %s: %v", uuid, err)
+		return nil, err
+	}
+	return iface, nil
 }
 
 func (c *Controller) GetInterfaceMac(iface *types.VirtualMachineInterface) (string, error) {
@@ -260,41 +637,344 @@ func (c *Controller) GetInterfaceMac(iface *types.VirtualMachineInterface) (stri
 	return macs.MacAddress[0], nil
 }
 
+// GetOrCreateInstanceIp returns IPv4 instance IP of virtual machine interface, allocating it in
+// specified subnet if it doesn't exist yet. Returns true if the instance IP was created.
 func (c *Controller) GetOrCreateInstanceIp(net *types.VirtualNetwork,
-	iface *types.VirtualMachineInterface, subnetUuid string) (*types.InstanceIp, error) {
-	instIp, err := types.InstanceIpByName(c.ApiClient, iface.GetName())
+	iface *types.VirtualMachineInterface, subnetUuid string) (*types.InstanceIp, bool, error) {
+	return c.getOrCreateInstanceIp(net, iface, subnetUuid, instanceIpName(iface, IPv4Family),
+		IPv4Family, "")
+}
+
+// GetOrCreateInstanceIpv6 works like GetOrCreateInstanceIp, but allocates an IPv6 address, so
+// that an interface of dual-stack network can have both.
+func (c *Controller) GetOrCreateInstanceIpv6(net *types.VirtualNetwork,
+	iface *types.VirtualMachineInterface, subnetUuid string) (*types.InstanceIp, bool, error) {
+	return c.getOrCreateInstanceIp(net, iface, subnetUuid, instanceIpName(iface, IPv6Family),
+		IPv6Family, "")
+}
+
+// GetOrCreateFixedInstanceIp works like GetOrCreateInstanceIp, but reserves specified address
+// in specified subnet instead of letting Contrail pick one.
+func (c *Controller) GetOrCreateFixedInstanceIp(net *types.VirtualNetwork,
+	iface *types.VirtualMachineInterface, subnet *types.IpamSubnetType, address string) (
+	*types.InstanceIp, bool, error) {
+
+	ip := net_.ParseIP(address)
+	if ip == nil {
+		err := fmt.Errorf("Invalid IP address: %s", address)
+		log.Error(err)
+		return nil, false, err
+	}
+	_, subnetNet, err := net_.ParseCIDR(fmt.Sprintf("%s/%v", subnet.Subnet.IpPrefix,
+		subnet.Subnet.IpPrefixLen))
+	if err != nil {
+		log.Errorf("Failed to parse Contrail subnet: %v", err)
+		return nil, false, err
+	}
+	if !subnetNet.Contains(ip) {
+		err = fmt.Errorf("Address %s is outside of subnet %s", address, subnetNet)
+		log.Error(err)
+		return nil, false, err
+	}
+
+	family := SubnetFamily(subnet)
+	instIp, created, err := c.getOrCreateInstanceIp(net, iface, subnet.SubnetUuid,
+		instanceIpName(iface, family), family, ip.String())
+	if err != nil {
+		return nil, false, err
+	}
+	if !net_.ParseIP(instIp.GetInstanceIpAddress()).Equal(ip) {
+		err = fmt.Errorf("Interface %s already has address %s", iface.GetName(),
+			instIp.GetInstanceIpAddress())
+		log.Error(err)
+		return nil, false, err
+	}
+	return instIp, created, nil
+}
+
+func instanceIpName(iface *types.VirtualMachineInterface, family string) string {
+	if family == IPv6Family {
+		return iface.GetName() + "_v6"
+	}
+	return iface.GetName()
+}
+
+func (c *Controller) getOrCreateInstanceIp(net *types.VirtualNetwork,
+	iface *types.VirtualMachineInterface, subnetUuid, name, family, address string) (
+	*types.InstanceIp, bool, error) {
+	instIp, err := types.InstanceIpByName(c.ApiClient, name)
 	if err == nil && instIp != nil {
-		return instIp, nil
+		return instIp, false, nil
 	}
 
 	instIp = &types.InstanceIp{}
-	instIp.SetName(iface.GetName())
+	instIp.SetName(name)
 	instIp.SetSubnetUuid(subnetUuid)
+	instIp.SetInstanceIpFamily(family)
+	if address != "" {
+		instIp.SetInstanceIpAddress(address)
+	}
 
 	err = instIp.AddVirtualNetwork(net)
 	if err != nil {
 		log.Errorf("Failed to add network to instanceIP object: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 	err = instIp.AddVirtualMachineInterface(iface)
 	if err != nil {
 		log.Errorf("Failed to add vmi to instanceIP object: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 	err = c.ApiClient.Create(instIp)
 	if err != nil {
 		log.Errorf("Failed to instanceIP: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 
 	allocatedIP, err := types.InstanceIpByUuid(c.ApiClient, instIp.GetUuid())
 	if err != nil {
 		log.Errorf("Failed to retreive instanceIP object %s by name: %v", instIp.GetUuid(), err)
+		return nil, false, err
+	}
+	return allocatedIP, true, nil
+}
+
+// AllocateInstanceIp reserves an address in specified subnet of virtual network, without
+// binding it to any interface. If address is empty, Contrail picks the next free one.
+func (c *Controller) AllocateInstanceIp(net *types.VirtualNetwork,
+	subnet *types.IpamSubnetType, address string) (*types.InstanceIp, error) {
+
+	instIp := &types.InstanceIp{}
+	instIp.SetName(uuid.New())
+	instIp.SetSubnetUuid(subnet.SubnetUuid)
+	instIp.SetInstanceIpFamily(SubnetFamily(subnet))
+	if address != "" {
+		instIp.SetInstanceIpAddress(address)
+	}
+
+	err := instIp.AddVirtualNetwork(net)
+	if err != nil {
+		log.Errorf("Failed to add network to instanceIP object: %v", err)
+		return nil, err
+	}
+	err = c.ApiClient.Create(instIp)
+	if err != nil {
+		log.Errorf("Failed to allocate instanceIP: %v", err)
+		return nil, err
+	}
+
+	allocatedIP, err := types.InstanceIpByUuid(c.ApiClient, instIp.GetUuid())
+	if err != nil {
+		log.Errorf("Failed to retreive instanceIP object %s by uuid: %v", instIp.GetUuid(), err)
 		return nil, err
 	}
+	log.Infoln("Allocated instance IP:", allocatedIP.GetInstanceIpAddress())
 	return allocatedIP, nil
 }
 
+// GetInstanceIpByAddress looks for instance IP with specified address among instance IPs of
+// virtual network. Returns nil if there is no such instance IP. Addresses of instance IPs are
+// fetched with one detailed list, limited to the address field; back references of the network
+// tell which of them belong to it.
+func (c *Controller) GetInstanceIpByAddress(net *types.VirtualNetwork, address string) (
+	*types.InstanceIp, error) {
+	refs, err := net.GetInstanceIpBackRefs()
+	if err != nil {
+		log.Errorf("Failed to get instanceIP back references: %v", err)
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	inNetwork := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		inNetwork[ref.Uuid] = true
+	}
+
+	objs, err := c.ApiClient.ListDetail("instance-ip", []string{"instance_ip_address"})
+	if err != nil {
+		log.Errorf("Failed to list instanceIP objects: %v", err)
+		return nil, err
+	}
+	ip := net_.ParseIP(address)
+	for _, obj := range objs {
+		instIp := obj.(*types.InstanceIp)
+		if inNetwork[instIp.GetUuid()] && net_.ParseIP(instIp.GetInstanceIpAddress()).Equal(ip) {
+			return types.InstanceIpByUuid(c.ApiClient, instIp.GetUuid())
+		}
+	}
+	return nil, nil
+}
+
+// contrailTimeLayout is layout of timestamps in id_perms of Contrail objects, which are in UTC.
+const contrailTimeLayout = "2006-01-02T15:04:05.999999"
+
+// ListUnboundInstanceIps returns instance IPs of virtual network that are bound to no interface
+// and named by UUID, like those reserved by AllocateInstanceIp, if they were created before
+// createdBefore. Instance IPs with unknown creation time are returned too.
+func (c *Controller) ListUnboundInstanceIps(net *types.VirtualNetwork, createdBefore time.Time) (
+	[]*types.InstanceIp, error) {
+	refs, err := net.GetInstanceIpBackRefs()
+	if err != nil {
+		log.Errorf("Failed to get instanceIP back references: %v", err)
+		return nil, err
+	}
+	uuidNamed := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		if uuid.Parse(ref.To[len(ref.To)-1]) != nil {
+			uuidNamed[ref.Uuid] = true
+		}
+	}
+	if len(uuidNamed) == 0 {
+		return nil, nil
+	}
+
+	objs, err := c.ApiClient.ListDetail("instance-ip",
+		[]string{"virtual_machine_interface_refs", "id_perms"})
+	if err != nil {
+		log.Errorf("Failed to list instanceIP objects: %v", err)
+		return nil, err
+	}
+	var unbound []*types.InstanceIp
+	for _, obj := range objs {
+		instIp := obj.(*types.InstanceIp)
+		if !uuidNamed[instIp.GetUuid()] {
+			continue
+		}
+		created, err := time.Parse(contrailTimeLayout, instIp.GetIdPerms().Created)
+		if err == nil && !created.Before(createdBefore) {
+			continue
+		}
+		vifRefs, err := instIp.GetVirtualMachineInterfaceRefs()
+		if err != nil {
+			log.Errorf("Failed to get vif references of instanceIP: %v", err)
+			return nil, err
+		}
+		if len(vifRefs) == 0 {
+			unbound = append(unbound, instIp)
+		}
+	}
+	return unbound, nil
+}
+
+// AssignInstanceIp binds previously allocated instance IP to virtual machine interface.
+func (c *Controller) AssignInstanceIp(instIp *types.InstanceIp,
+	iface *types.VirtualMachineInterface) error {
+	err := instIp.AddVirtualMachineInterface(iface)
+	if err != nil {
+		log.Errorf("Failed to add vmi to instanceIP object: %v", err)
+		return err
+	}
+	err = c.ApiClient.Update(instIp)
+	if err != nil {
+		log.Errorf("Failed to update instanceIP: %v", err)
+		return err
+	}
+	return nil
+}
+
+// UnassignInstanceIp unbinds instance IP from virtual machine interface, undoing
+// AssignInstanceIp. Instance IP itself stays allocated.
+func (c *Controller) UnassignInstanceIp(instIp *types.InstanceIp,
+	iface *types.VirtualMachineInterface) error {
+	err := instIp.DeleteVirtualMachineInterface(iface.GetUuid())
+	if err != nil {
+		log.Errorf("Failed to remove vmi from instanceIP object: %v", err)
+		return err
+	}
+	err = c.ApiClient.Update(instIp)
+	if err != nil {
+		log.Errorf("Failed to update instanceIP: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetOrCreateFloatingIp returns floating IP of virtual machine interface, allocated from
+// floating IP pool with specified FQName (like "domain:project:network:pool"). Floating IP is
+// created if it doesn't exist yet. Port mappings are (re)applied in both cases; if there are
+// none, all ports of the floating IP are forwarded to the interface.
+func (c *Controller) GetOrCreateFloatingIp(poolFQName, tenantName string,
+	iface *types.VirtualMachineInterface, portMappings []types.PortMap) (*types.FloatingIp,
+	error) {
+
+	pool, err := types.FloatingIpPoolByName(c.ApiClient, poolFQName)
+	if err != nil {
+		log.Errorf("Failed to get floating IP pool %s: %v", poolFQName, err)
+		return nil, err
+	}
+
+	fqName := append(pool.GetFQName(), iface.GetName())
+	floatingIp, err := types.FloatingIpByName(c.ApiClient, strings.Join(fqName, ":"))
+	exists := err == nil && floatingIp != nil
+	if !exists {
+		floatingIp = new(types.FloatingIp)
+		floatingIp.SetFQName("floating-ip-pool", fqName)
+
+		project, err := types.ProjectByName(c.ApiClient, fmt.Sprintf("%s:%s",
+			common.DomainName, tenantName))
+		if err != nil {
+			log.Errorf("Failed to get project %s: %v", tenantName, err)
+			return nil, err
+		}
+		err = floatingIp.AddProject(project)
+		if err != nil {
+			log.Errorf("Failed to add project to floating IP: %v", err)
+			return nil, err
+		}
+		err = floatingIp.AddVirtualMachineInterface(iface)
+		if err != nil {
+			log.Errorf("Failed to add vmi to floating IP: %v", err)
+			return nil, err
+		}
+	}
+
+	mappings := new(types.PortMappings)
+	for i := range portMappings {
+		mappings.AddPortMappings(&portMappings[i])
+	}
+	floatingIp.SetFloatingIpPortMappingsEnable(len(portMappings) > 0)
+	floatingIp.SetFloatingIpPortMappings(mappings)
+
+	if exists {
+		err = c.ApiClient.Update(floatingIp)
+	} else {
+		err = c.ApiClient.Create(floatingIp)
+	}
+	if err != nil {
+		log.Errorf("Failed to save floating IP: %v", err)
+		return nil, err
+	}
+
+	savedIp, err := types.FloatingIpByUuid(c.ApiClient, floatingIp.GetUuid())
+	if err != nil {
+		log.Errorf("Failed to retreive floating IP %s by uuid: %v", floatingIp.GetUuid(), err)
+		return nil, err
+	}
+	log.Infoln("Floating IP of", iface.GetName(), "is", savedIp.GetFloatingIpAddress())
+	return savedIp, nil
+}
+
+// DeleteFloatingIp removes floating IP of virtual machine interface from specified floating IP
+// pool. It is not an error if there is no such floating IP.
+func (c *Controller) DeleteFloatingIp(poolFQName string,
+	iface *types.VirtualMachineInterface) error {
+
+	pool, err := types.FloatingIpPoolByName(c.ApiClient, poolFQName)
+	if err != nil {
+		log.Errorf("Failed to get floating IP pool %s: %v", poolFQName, err)
+		return err
+	}
+
+	fqName := append(pool.GetFQName(), iface.GetName())
+	floatingIp, err := types.FloatingIpByName(c.ApiClient, strings.Join(fqName, ":"))
+	if err != nil || floatingIp == nil {
+		log.Infoln("Floating IP of", iface.GetName(), "doesn't exist, nothing to delete")
+		return nil
+	}
+	return c.DeleteElementRecursive(floatingIp)
+}
+
 func (c *Controller) DeleteElementRecursive(parent contrail.IObject) error {
 	log.Debugln("Deleting", parent.GetType(), parent.GetUuid())
 	for err := c.ApiClient.Delete(parent); err != nil; err = c.ApiClient.Delete(parent) {
@@ -18,6 +18,7 @@ package controller
 import (
 	"flag"
 	"fmt"
+	"strings"
 	"testing"
 
 	contrail "github.com/Juniper/contrail-go-api"
@@ -270,6 +271,102 @@ var _ = Describe("Controller", func() {
 						return testNetwork
 					}, "10.12.13.0/24"))
 			})
+			Context("user specified unspecified address", func() {
+				Specify("getting subnet returns error",
+					assertGettingSubnetFails(func() *types.VirtualNetwork {
+						return testNetwork
+					}, "0.0.0.0/32"))
+			})
+		})
+	})
+
+	Describe("getting Contrail subnet info of dual-stack network", func() {
+		var testNetwork *types.VirtualNetwork
+		const (
+			prefixV6 = "fd00:10::"
+			gwV6     = "fd00:10::1"
+			cidrV6   = "fd00:10::/64"
+		)
+		BeforeEach(func() {
+			testNetwork = CreateMockedNetwork(client.ApiClient, networkName, project)
+			AddSubnetWithDefaultGateway(client.ApiClient, subnetPrefix, defaultGW, subnetMask,
+				testNetwork)
+			AddSubnetWithDefaultGateway(client.ApiClient, prefixV6, gwV6, 64, testNetwork)
+		})
+		Specify("getting subnet by IPv6 CIDR works", func() {
+			ipam, err := client.GetIpamSubnet(testNetwork, cidrV6)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ipam.DefaultGateway).To(Equal(gwV6))
+			Expect(SubnetFamily(ipam)).To(Equal(IPv6Family))
+		})
+		Specify("getting subnet by non-normalized IPv6 CIDR works", func() {
+			ipam, err := client.GetIpamSubnet(testNetwork, "fd00:0010:0::/64")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ipam.DefaultGateway).To(Equal(gwV6))
+		})
+		Specify("unspecified address doesn't select any subnet", func() {
+			_, err := client.GetIpamSubnet(testNetwork, "0.0.0.0/32")
+			Expect(err).To(HaveOccurred())
+		})
+		Specify("getting the only subnet of IP family works", func() {
+			ipam, err := client.GetIpamSubnetOfFamily(testNetwork, IPv4Family)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ipam.DefaultGateway).To(Equal(defaultGW))
+
+			ipam, err = client.GetIpamSubnetOfFamily(testNetwork, IPv6Family)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ipam.DefaultGateway).To(Equal(gwV6))
+		})
+		Specify("getting subnet of IP family returns error if there are multiple", func() {
+			AddSubnetWithDefaultGateway(client.ApiClient, "fd00:20::", "fd00:20::1", 64,
+				testNetwork)
+			_, err := client.GetIpamSubnetOfFamily(testNetwork, IPv6Family)
+			Expect(err).To(HaveOccurred())
+		})
+		Specify("not specifying CIDR at all returns error", func() {
+			_, err := client.GetIpamSubnet(testNetwork, "")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("getting DNS config and host routes of Contrail subnet", func() {
+		var subnet *types.IpamSubnetType
+		BeforeEach(func() {
+			subnet = &types.IpamSubnetType{
+				Subnet:           &types.SubnetType{IpPrefix: subnetPrefix, IpPrefixLen: subnetMask},
+				DefaultGateway:   defaultGW,
+				DnsServerAddress: "10.10.10.2",
+			}
+		})
+		It("returns subnet's DNS server address by default", func() {
+			servers, domain := client.GetDnsConfig(subnet)
+			Expect(servers).To(Equal([]string{"10.10.10.2"}))
+			Expect(domain).To(Equal(""))
+		})
+		It("returns no DNS servers if DNS is disabled in subnet", func() {
+			subnet.DnsServerAddress = "0.0.0.0"
+			servers, _ := client.GetDnsConfig(subnet)
+			Expect(servers).To(BeEmpty())
+		})
+		It("prefers DNS servers and domain from DHCP options", func() {
+			subnet.DhcpOptionList = &types.DhcpOptionsListType{
+				DhcpOption: []types.DhcpOptionType{
+					{DhcpOptionName: "6", DhcpOptionValue: "8.8.8.8 8.8.4.4"},
+					{DhcpOptionName: "domain-name", DhcpOptionValue: "contrail.local"},
+				},
+			}
+			servers, domain := client.GetDnsConfig(subnet)
+			Expect(servers).To(Equal([]string{"8.8.8.8", "8.8.4.4"}))
+			Expect(domain).To(Equal("contrail.local"))
+		})
+		It("returns host routes", func() {
+			Expect(client.GetHostRoutes(subnet)).To(BeEmpty())
+			subnet.HostRoutes = &types.RouteTableType{
+				Route: []types.RouteType{
+					{Prefix: "10.30.0.0/16", NextHop: "10.10.10.254"},
+				},
+			}
+			Expect(client.GetHostRoutes(subnet)).To(HaveLen(1))
 		})
 	})
 
@@ -291,6 +388,12 @@ var _ = Describe("Controller", func() {
 				Expect(iface).ToNot(BeNil())
 				Expect(iface.GetUuid()).To(Equal(testInterface.GetUuid()))
 			})
+			It("reports that vif wasn't created", func() {
+				_, created, err := client.GetOrCreateInterfaceWithParams(testNetwork,
+					tenantName, containerID, InterfaceParams{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(created).To(BeFalse())
+			})
 			It("assigns correct FQName to vif", func() {
 				iface, err := client.GetOrCreateInterface(testNetwork, tenantName, containerID)
 				Expect(err).ToNot(HaveOccurred())
@@ -310,6 +413,110 @@ var _ = Describe("Controller", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(existingIface.GetUuid()).To(Equal(iface.GetUuid()))
 			})
+			It("creates a new vif with requested MAC", func() {
+				iface, created, err := client.GetOrCreateInterfaceWithParams(testNetwork,
+					tenantName, containerID, InterfaceParams{Mac: "02-11-22-AA-BB-CC"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(created).To(BeTrue())
+
+				mac, err := client.GetInterfaceMac(iface)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mac).To(Equal("02:11:22:aa:bb:cc"))
+			})
+			It("returns error on malformed MAC", func() {
+				_, _, err := client.GetOrCreateInterfaceWithParams(testNetwork, tenantName,
+					containerID, InterfaceParams{Mac: "not a MAC"})
+				Expect(err).To(HaveOccurred())
+			})
+		})
+		Context("when vif already exists in Contrail with another MAC", func() {
+			BeforeEach(func() {
+				testInterface := CreateMockedInterface(client.ApiClient, testNetwork,
+					tenantName, containerID)
+				AddMacToInterface(client.ApiClient, "02:11:22:aa:bb:cc", testInterface)
+			})
+			It("returns error when asked for a different MAC", func() {
+				_, _, err := client.GetOrCreateInterfaceWithParams(testNetwork, tenantName,
+					containerID, InterfaceParams{Mac: "02:11:22:aa:bb:dd"})
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("attaching Contrail security groups", func() {
+		var testNetwork *types.VirtualNetwork
+		var testGroup *types.SecurityGroup
+		BeforeEach(func() {
+			testNetwork = CreateMockedNetworkWithSubnet(client.ApiClient, networkName, subnetCIDR,
+				project)
+			testGroup = CreateMockedSecurityGroup(client.ApiClient, project, "test_sg")
+		})
+		DescribeTable("looking up security group",
+			func(getName func() string) {
+				groups, err := client.GetSecurityGroups(tenantName, []string{getName()})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(groups).To(HaveLen(1))
+				Expect(groups[0].GetUuid()).To(Equal(testGroup.GetUuid()))
+			},
+			Entry("by name", func() string { return "test_sg" }),
+			Entry("by FQName", func() string {
+				return strings.Join(testGroup.GetFQName(), ":")
+			}),
+			Entry("by UUID", func() string { return testGroup.GetUuid() }),
+		)
+		It("returns error on unknown security group", func() {
+			_, err := client.GetSecurityGroups(tenantName, []string{"nonexisting_sg"})
+			Expect(err).To(HaveOccurred())
+		})
+		It("creates vif with security groups attached", func() {
+			iface, _, err := client.GetOrCreateInterfaceWithParams(testNetwork, tenantName,
+				containerID, InterfaceParams{
+					SecurityGroups: []*types.SecurityGroup{testGroup},
+				})
+			Expect(err).ToNot(HaveOccurred())
+
+			refs, err := iface.GetSecurityGroupRefs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(refs).To(HaveLen(1))
+			Expect(refs[0].Uuid).To(Equal(testGroup.GetUuid()))
+		})
+		It("attaches security groups to existing vif only once", func() {
+			iface := CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
+				containerID)
+			groups := []*types.SecurityGroup{testGroup}
+			added, err := client.AddSecurityGroups(iface, groups)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(added).To(Equal(groups))
+			added, err = client.AddSecurityGroups(iface, groups)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(added).To(BeEmpty())
+
+			iface, err = types.VirtualMachineInterfaceByUuid(client.ApiClient, iface.GetUuid())
+			Expect(err).ToNot(HaveOccurred())
+			refs, err := iface.GetSecurityGroupRefs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(refs).To(HaveLen(1))
+		})
+		It("detaches only removed security groups", func() {
+			otherGroup := CreateMockedSecurityGroup(client.ApiClient, project, "other_sg")
+			iface, _, err := client.GetOrCreateInterfaceWithParams(testNetwork, tenantName,
+				containerID, InterfaceParams{
+					SecurityGroups: []*types.SecurityGroup{testGroup},
+				})
+			Expect(err).ToNot(HaveOccurred())
+			added, err := client.AddSecurityGroups(iface,
+				[]*types.SecurityGroup{testGroup, otherGroup})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(added).To(Equal([]*types.SecurityGroup{otherGroup}))
+
+			Expect(client.RemoveSecurityGroups(iface, added)).To(Succeed())
+
+			iface, err = types.VirtualMachineInterfaceByUuid(client.ApiClient, iface.GetUuid())
+			Expect(err).ToNot(HaveOccurred())
+			refs, err := iface.GetSecurityGroupRefs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(refs).To(HaveLen(1))
+			Expect(refs[0].Uuid).To(Equal(testGroup.GetUuid()))
 		})
 	})
 
@@ -358,16 +565,34 @@ var _ = Describe("Controller", func() {
 				testInstance = CreateMockedInstance(client.ApiClient, testInterface, containerID)
 			})
 			It("returns existing instance", func() {
-				instance, err := client.GetOrCreateInstance(testInterface, containerID)
+				instance, attached, err := client.GetOrCreateInstance(testInterface, containerID)
 				Expect(err).ToNot(HaveOccurred())
+				Expect(attached).To(BeFalse())
 				Expect(instance).ToNot(BeNil())
 				Expect(instance.GetUuid()).To(Equal(testInstance.GetUuid()))
 			})
+			It("attaches another vif to existing instance", func() {
+				testNetwork := CreateMockedNetworkWithSubnet(client.ApiClient, "other_net",
+					"10.20.20.0/24", project)
+				otherInterface := CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
+					InterfaceName("other_net", containerID))
+
+				instance, attached, err := client.GetOrCreateInstance(otherInterface, containerID)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(attached).To(BeTrue())
+				Expect(instance.GetUuid()).To(Equal(testInstance.GetUuid()))
+
+				vmRefs, err := otherInterface.GetVirtualMachineRefs()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(vmRefs).To(HaveLen(1))
+				Expect(vmRefs[0].Uuid).To(Equal(testInstance.GetUuid()))
+			})
 		})
 		Context("when instance doesn't exist in Contrail", func() {
 			It("creates a new instance", func() {
-				instance, err := client.GetOrCreateInstance(testInterface, containerID)
+				instance, attached, err := client.GetOrCreateInstance(testInterface, containerID)
 				Expect(err).ToNot(HaveOccurred())
+				Expect(attached).To(BeTrue())
 				Expect(instance).ToNot(BeNil())
 
 				existingInst, err := types.VirtualMachineByUuid(client.ApiClient,
@@ -378,6 +603,42 @@ var _ = Describe("Controller", func() {
 		})
 	})
 
+	Describe("deleting Contrail virtual interface", func() {
+		var testNetwork *types.VirtualNetwork
+		var testInstance *types.VirtualMachine
+		var firstInterface, secondInterface *types.VirtualMachineInterface
+		BeforeEach(func() {
+			testNetwork = CreateMockedNetworkWithSubnet(client.ApiClient, networkName, subnetCIDR,
+				project)
+			firstInterface = CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
+				InterfaceName(networkName, "endpoint1"))
+			secondInterface = CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
+				InterfaceName(networkName, "endpoint2"))
+			testInstance = CreateMockedInstance(client.ApiClient, firstInterface, containerID)
+			_, _, err := client.GetOrCreateInstance(secondInterface, containerID)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("removes the interface, but keeps instance that has other interfaces", func() {
+			err := client.DeleteInterface(firstInterface)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.ApiClient.FindByUuid(firstInterface.GetType(),
+				firstInterface.GetUuid())
+			Expect(err).To(HaveOccurred())
+			_, err = client.ApiClient.FindByUuid(testInstance.GetType(), testInstance.GetUuid())
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("removes the instance together with its last interface", func() {
+			err := client.DeleteInterface(firstInterface)
+			Expect(err).ToNot(HaveOccurred())
+			err = client.DeleteInterface(secondInterface)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.ApiClient.FindByUuid(testInstance.GetType(), testInstance.GetUuid())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	Describe("getting virtual interface MAC", func() {
 		var testInterface *types.VirtualMachineInterface
 		BeforeEach(func() {
@@ -433,8 +694,10 @@ var _ = Describe("Controller", func() {
 					testInterface, testNetwork)
 			})
 			It("returns existing instance IP", func() {
-				instanceIP, err := client.GetOrCreateInstanceIp(testNetwork, testInterface, "")
+				instanceIP, created, err := client.GetOrCreateInstanceIp(testNetwork,
+					testInterface, "")
 				Expect(err).ToNot(HaveOccurred())
+				Expect(created).To(BeFalse())
 				Expect(instanceIP).ToNot(BeNil())
 				Expect(instanceIP.GetUuid()).To(Equal(testInstanceIP.GetUuid()))
 				Expect(instanceIP.GetInstanceIpAddress()).To(Equal(
@@ -447,8 +710,10 @@ var _ = Describe("Controller", func() {
 		})
 		Context("when instance IP doesn't exist in Contrail", func() {
 			It("creates new instance IP", func() {
-				instanceIP, err := client.GetOrCreateInstanceIp(testNetwork, testInterface, "")
+				instanceIP, created, err := client.GetOrCreateInstanceIp(testNetwork,
+					testInterface, "")
 				Expect(err).ToNot(HaveOccurred())
+				Expect(created).To(BeTrue())
 				Expect(instanceIP).ToNot(BeNil())
 				Expect(instanceIP.GetInstanceIpAddress()).ToNot(Equal(""))
 
@@ -457,6 +722,126 @@ var _ = Describe("Controller", func() {
 				Expect(existingIP.GetUuid()).To(Equal(instanceIP.GetUuid()))
 			})
 		})
+		Context("when user requests a fixed address", func() {
+			var subnet *types.IpamSubnetType
+			BeforeEach(func() {
+				var err error
+				subnet, err = client.GetIpamSubnet(testNetwork, "")
+				Expect(err).ToNot(HaveOccurred())
+			})
+			It("reserves that address", func() {
+				instanceIP, _, err := client.GetOrCreateFixedInstanceIp(testNetwork, testInterface,
+					subnet, "10.10.10.42")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instanceIP.GetInstanceIpAddress()).To(Equal("10.10.10.42"))
+
+				existingIP, err := client.GetInstanceIpByAddress(testNetwork, "10.10.10.42")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(existingIP.GetUuid()).To(Equal(instanceIP.GetUuid()))
+			})
+			It("finds the address only in its own network", func() {
+				otherNetwork := CreateMockedNetworkWithSubnet(client.ApiClient, "other_net",
+					subnetCIDR, project)
+				otherInterface := CreateMockedInterface(client.ApiClient, otherNetwork,
+					tenantName, "other_container")
+				otherSubnet, err := client.GetIpamSubnet(otherNetwork, "")
+				Expect(err).ToNot(HaveOccurred())
+				otherIP, _, err := client.GetOrCreateFixedInstanceIp(otherNetwork, otherInterface,
+					otherSubnet, "10.10.10.42")
+				Expect(err).ToNot(HaveOccurred())
+
+				existingIP, err := client.GetInstanceIpByAddress(testNetwork, "10.10.10.42")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(existingIP).To(BeNil())
+
+				instanceIP, _, err := client.GetOrCreateFixedInstanceIp(testNetwork, testInterface,
+					subnet, "10.10.10.42")
+				Expect(err).ToNot(HaveOccurred())
+
+				existingIP, err = client.GetInstanceIpByAddress(testNetwork, "10.10.10.42")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(existingIP.GetUuid()).To(Equal(instanceIP.GetUuid()))
+				existingIP, err = client.GetInstanceIpByAddress(otherNetwork, "10.10.10.42")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(existingIP.GetUuid()).To(Equal(otherIP.GetUuid()))
+			})
+			It("returns error if address is outside of subnet", func() {
+				_, _, err := client.GetOrCreateFixedInstanceIp(testNetwork, testInterface,
+					subnet, "10.20.20.42")
+				Expect(err).To(HaveOccurred())
+			})
+			It("returns error if interface already has another address", func() {
+				_, _, err := client.GetOrCreateInstanceIp(testNetwork, testInterface, "")
+				Expect(err).ToNot(HaveOccurred())
+				_, _, err = client.GetOrCreateFixedInstanceIp(testNetwork, testInterface,
+					subnet, "10.10.10.254")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+		Context("when interface is in dual-stack network", func() {
+			var subnetV6 *types.IpamSubnetType
+			BeforeEach(func() {
+				AddSubnetWithDefaultGateway(client.ApiClient, "fd00:10::", "fd00:10::1", 64,
+					testNetwork)
+				var err error
+				subnetV6, err = client.GetIpamSubnetOfFamily(testNetwork, IPv6Family)
+				Expect(err).ToNot(HaveOccurred())
+			})
+			It("creates IPv6 instance IP next to IPv4 one", func() {
+				instanceIP, _, err := client.GetOrCreateInstanceIp(testNetwork, testInterface, "")
+				Expect(err).ToNot(HaveOccurred())
+				instanceIPv6, _, err := client.GetOrCreateInstanceIpv6(testNetwork, testInterface,
+					subnetV6.SubnetUuid)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instanceIPv6.GetUuid()).ToNot(Equal(instanceIP.GetUuid()))
+				Expect(instanceIPv6.GetInstanceIpFamily()).To(Equal(IPv6Family))
+			})
+		})
+	})
+
+	Describe("publishing Contrail virtual interface through floating IP", func() {
+		var testInterface *types.VirtualMachineInterface
+		var poolFQName string
+		BeforeEach(func() {
+			testNetwork := CreateMockedNetworkWithSubnet(client.ApiClient, networkName,
+				subnetCIDR, project)
+			pool := CreateMockedFloatingIpPool(client.ApiClient, testNetwork, "test_pool")
+			poolFQName = strings.Join(pool.GetFQName(), ":")
+			testInterface = CreateMockedInterface(client.ApiClient, testNetwork, tenantName,
+				containerID)
+		})
+		It("creates floating IP with port mappings", func() {
+			fip, err := client.GetOrCreateFloatingIp(poolFQName, tenantName, testInterface,
+				[]types.PortMap{{Protocol: "tcp", SrcPort: 80, DstPort: 8080}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fip.GetFloatingIpPortMappingsEnable()).To(BeTrue())
+
+			vifRefs, err := fip.GetVirtualMachineInterfaceRefs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vifRefs).To(HaveLen(1))
+			Expect(vifRefs[0].Uuid).To(Equal(testInterface.GetUuid()))
+		})
+		It("reuses existing floating IP", func() {
+			fip1, err := client.GetOrCreateFloatingIp(poolFQName, tenantName, testInterface, nil)
+			Expect(err).ToNot(HaveOccurred())
+			fip2, err := client.GetOrCreateFloatingIp(poolFQName, tenantName, testInterface,
+				[]types.PortMap{{Protocol: "udp", SrcPort: 53, DstPort: 53}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fip2.GetUuid()).To(Equal(fip1.GetUuid()))
+		})
+		It("removes floating IP", func() {
+			fip, err := client.GetOrCreateFloatingIp(poolFQName, tenantName, testInterface, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.DeleteFloatingIp(poolFQName, testInterface)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = types.FloatingIpByUuid(client.ApiClient, fip.GetUuid())
+			Expect(err).To(HaveOccurred())
+
+			By("removing it again is not an error")
+			err = client.DeleteFloatingIp(poolFQName, testInterface)
+			Expect(err).ToNot(HaveOccurred())
+		})
 	})
 })
 
@@ -468,7 +853,7 @@ var _ = Describe("Authenticating", func() {
 	}
 	DescribeTable("with different keystone env variables",
 		func(t TestCase) {
-			_, err := NewController(controllerAddr, controllerPort, &t.keys)
+			_, err := NewController(controllerAddr, controllerPort, &t.keys, nil)
 			if t.shouldErr {
 				Expect(err).To(HaveOccurred())
 			} else {
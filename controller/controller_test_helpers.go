@@ -60,7 +60,7 @@ func NewMockedClientAndProject(tenant string) (*Controller, *types.Project) {
 
 func NewClientAndProject(tenant, controllerAddr string, controllerPort int) (*Controller,
 	*types.Project) {
-	c, err := NewController(controllerAddr, controllerPort, TestKeystoneEnvs())
+	c, err := NewController(controllerAddr, controllerPort, TestKeystoneEnvs(), nil)
 	Expect(err).ToNot(HaveOccurred())
 
 	ForceDeleteProject(c, tenant)
@@ -172,6 +172,30 @@ func CreateMockedInstanceIP(c contrail.ApiClient, tenantName string,
 	return allocatedIP
 }
 
+func CreateMockedFloatingIpPool(c contrail.ApiClient, net *types.VirtualNetwork,
+	poolName string) *types.FloatingIpPool {
+	pool := new(types.FloatingIpPool)
+	pool.SetFQName("virtual-network", append(net.GetFQName(), poolName))
+	err := c.Create(pool)
+	Expect(err).ToNot(HaveOccurred())
+
+	createdPool, err := types.FloatingIpPoolByUuid(c, pool.GetUuid())
+	Expect(err).ToNot(HaveOccurred())
+	return createdPool
+}
+
+func CreateMockedSecurityGroup(c contrail.ApiClient, project *types.Project,
+	groupName string) *types.SecurityGroup {
+	group := new(types.SecurityGroup)
+	group.SetFQName("project", append(project.GetFQName(), groupName))
+	err := c.Create(group)
+	Expect(err).ToNot(HaveOccurred())
+
+	createdGroup, err := types.SecurityGroupByUuid(c, group.GetUuid())
+	Expect(err).ToNot(HaveOccurred())
+	return createdGroup
+}
+
 func ForceDeleteProject(c *Controller, tenant string) {
 	projToDelete, _ := c.ApiClient.FindByName("project", fmt.Sprintf("%s:%s", common.DomainName,
 		tenant))
@@ -0,0 +1,161 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Juniper/contrail-go-api"
+	"github.com/codilime/contrail-windows-docker/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// endpointRecheckInterval is how long an unhealthy endpoint of Contrail API is avoided before
+// requests are sent to it again.
+const endpointRecheckInterval = 30 * time.Second
+
+var (
+	endpointUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "controller_endpoint_up",
+		Help:      "Whether endpoint of Contrail API is healthy (1) or not (0).",
+	}, []string{"endpoint"})
+	endpointFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "controller_endpoint_failures_total",
+		Help: "Number of requests to endpoint of Contrail API that failed with connection " +
+			"error or 5xx response.",
+	}, []string{"endpoint"})
+)
+
+func init() {
+	metrics.DefaultRegistry.MustRegister(endpointUp, endpointFailures)
+}
+
+type apiEndpoint struct {
+	address  string
+	client   contrail.ApiClient
+	healthy  bool
+	failedAt time.Time
+}
+
+// failoverApiClient sends requests to Contrail API served by several config nodes. Requests go
+// to the first healthy endpoint, in configured order, and fail over to the next ones after
+// connection errors and 5xx responses. Writes may have been applied by endpoint that failed
+// them, so they only fail over if they couldn't be sent at all. Unhealthy endpoints are tried
+// again after recheckInterval, or when there's no other endpoint left.
+type failoverApiClient struct {
+	interceptedApiClient
+	mutex           sync.Mutex
+	endpoints       []*apiEndpoint
+	recheckInterval time.Duration
+}
+
+// newFailoverApiClient creates client of endpoints at addresses, which are host:port, each
+// with its client.
+func newFailoverApiClient(addresses []string, clients []contrail.ApiClient) *failoverApiClient {
+	c := &failoverApiClient{recheckInterval: endpointRecheckInterval}
+	c.interceptedApiClient = interceptedApiClient{intercept: c.do}
+	for i, address := range addresses {
+		c.endpoints = append(c.endpoints, &apiEndpoint{
+			address: address,
+			client:  clients[i],
+			healthy: true,
+		})
+		endpointUp.WithLabelValues(address).Set(1)
+	}
+	return c
+}
+
+// candidates returns endpoints in order in which request should be tried on them.
+func (c *failoverApiClient) candidates() []*apiEndpoint {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var preferred, avoided []*apiEndpoint
+	for _, e := range c.endpoints {
+		if e.healthy || time.Since(e.failedAt) >= c.recheckInterval {
+			preferred = append(preferred, e)
+		} else {
+			avoided = append(avoided, e)
+		}
+	}
+	return append(preferred, avoided...)
+}
+
+func (c *failoverApiClient) markHealthy(e *apiEndpoint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !e.healthy {
+		log.Infof("Contrail API endpoint %s is healthy again", e.address)
+		e.healthy = true
+		endpointUp.WithLabelValues(e.address).Set(1)
+	}
+}
+
+func (c *failoverApiClient) markUnhealthy(e *apiEndpoint, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	endpointFailures.WithLabelValues(e.address).Inc()
+	e.failedAt = time.Now()
+	if e.healthy {
+		log.Warnf("Contrail API endpoint %s is unhealthy: %v", e.address, err)
+		e.healthy = false
+		endpointUp.WithLabelValues(e.address).Set(0)
+	}
+}
+
+// isDialError tells if request failed before it reached Contrail API, because connection to it
+// couldn't be made, either by the client or by its relay.
+func isDialError(err error) bool {
+	if httpStatus(err) == http.StatusBadGateway && strings.Contains(err.Error(), errRelayDial) {
+		return true
+	}
+	if urlErr, isURLError := err.(*url.Error); isURLError {
+		err = urlErr.Err
+	}
+	opErr, isOpError := err.(*net.OpError)
+	return isOpError && opErr.Op == "dial"
+}
+
+// do runs request on endpoints until one of them handles it, successfully or not. Requests that
+// aren't idempotent only fail over if they didn't reach the endpoint.
+func (c *failoverApiClient) do(op, typename string, idempotent bool,
+	call func(client contrail.ApiClient) error) error {
+	var err error
+	candidates := c.candidates()
+	for i, e := range candidates {
+		err = call(e.client)
+		if err == nil || !isTransientError(err) {
+			c.markHealthy(e)
+			return err
+		}
+		c.markUnhealthy(e, err)
+		if !idempotent && !isDialError(err) {
+			return err
+		}
+		if i+1 < len(candidates) {
+			log.Warnf("%s of %s failed on %s, failing over to %s: %v", op, typename, e.address,
+				candidates[i+1].address, err)
+		}
+	}
+	return err
+}
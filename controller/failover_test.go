@@ -0,0 +1,121 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/Juniper/contrail-go-api"
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Failing over between Contrail API endpoints", func() {
+
+	unavailable := errors.New("503 Service Unavailable: ")
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	notFound := errors.New("404 Resource not found")
+
+	var first, second *failingApiClient
+	var client *failoverApiClient
+
+	BeforeEach(func() {
+		first = &failingApiClient{}
+		second = &failingApiClient{}
+		client = newFailoverApiClient([]string{"first:8082", "second:8082"},
+			[]contrail.ApiClient{first, second})
+	})
+
+	exported := func() string {
+		recorder := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		return recorder.Body.String()
+	}
+
+	It("sends requests to the first endpoint while it's healthy", func() {
+		Expect(client.Create(&types.VirtualNetwork{})).To(Succeed())
+		Expect(client.Create(&types.VirtualNetwork{})).To(Succeed())
+		Expect(first.requests).To(Equal(2))
+		Expect(second.requests).To(Equal(0))
+	})
+
+	It("fails over on connection errors and 5xx responses, and avoids unhealthy endpoint", func() {
+		first.errors = []error{refused}
+		Expect(client.Create(&types.VirtualNetwork{})).To(Succeed())
+		Expect(first.requests).To(Equal(1))
+		Expect(second.requests).To(Equal(1))
+		Expect(exported()).To(ContainSubstring(
+			`contrail_driver_controller_endpoint_up{endpoint="first:8082"} 0`))
+
+		_, err := client.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(first.requests).To(Equal(1))
+		Expect(second.requests).To(Equal(2))
+
+		second.errors = []error{unavailable}
+		_, err = client.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(first.requests).To(Equal(2))
+		Expect(second.requests).To(Equal(3))
+	})
+
+	It("fails over writes only if they didn't reach the endpoint", func() {
+		client.recheckInterval = 0
+		timeout := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("i/o timeout")}
+		first.errors = []error{unavailable, timeout}
+		Expect(client.Create(&types.VirtualNetwork{})).To(MatchError(unavailable))
+		Expect(client.Create(&types.VirtualNetwork{})).To(MatchError(timeout))
+		Expect(first.requests).To(Equal(2))
+		Expect(second.requests).To(Equal(0))
+
+		first.errors = []error{&url.Error{Op: "Post", URL: "http://first:8082",
+			Err: refused}}
+		Expect(client.Create(&types.VirtualNetwork{})).To(Succeed())
+		Expect(first.requests).To(Equal(3))
+		Expect(second.requests).To(Equal(1))
+	})
+
+	It("goes back to endpoint that recovered", func() {
+		client.recheckInterval = 0
+		first.errors = []error{unavailable}
+		_, err := client.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Create(&types.VirtualNetwork{})).To(Succeed())
+		Expect(first.requests).To(Equal(2))
+		Expect(second.requests).To(Equal(1))
+		Expect(exported()).To(ContainSubstring(
+			`contrail_driver_controller_endpoint_up{endpoint="first:8082"} 1`))
+	})
+
+	It("returns the last error when all endpoints fail", func() {
+		first.errors = []error{unavailable}
+		second.errors = []error{refused}
+		_, err := client.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).To(MatchError(refused))
+	})
+
+	It("doesn't fail over on other errors", func() {
+		first.errors = []error{notFound}
+		_, err := client.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).To(MatchError(notFound))
+		Expect(second.requests).To(Equal(0))
+	})
+})
@@ -0,0 +1,129 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/Juniper/contrail-go-api"
+)
+
+// interceptor runs a request to Contrail API: call sends it with specified client. Interceptor
+// picks the client and may call it more than once, or not at all. Op is name of ApiClient
+// method, and requests that are idempotent can be safely sent again.
+type interceptor func(op, typename string, idempotent bool,
+	call func(client contrail.ApiClient) error) error
+
+// interceptedApiClient implements contrail.ApiClient by passing every request through
+// intercept. Wrappers that add behaviour to all requests (like retries or metrics) are
+// interceptors, so that they don't have to delegate every method themselves.
+type interceptedApiClient struct {
+	intercept interceptor
+}
+
+func (c interceptedApiClient) Create(ptr contrail.IObject) error {
+	return c.intercept("Create", ptr.GetType(), false, func(client contrail.ApiClient) error {
+		return client.Create(ptr)
+	})
+}
+
+func (c interceptedApiClient) Update(ptr contrail.IObject) error {
+	return c.intercept("Update", ptr.GetType(), false, func(client contrail.ApiClient) error {
+		return client.Update(ptr)
+	})
+}
+
+func (c interceptedApiClient) DeleteByUuid(typename, uuid string) error {
+	return c.intercept("DeleteByUuid", typename, false, func(client contrail.ApiClient) error {
+		return client.DeleteByUuid(typename, uuid)
+	})
+}
+
+func (c interceptedApiClient) Delete(ptr contrail.IObject) error {
+	return c.intercept("Delete", ptr.GetType(), false, func(client contrail.ApiClient) error {
+		return client.Delete(ptr)
+	})
+}
+
+func (c interceptedApiClient) FindByUuid(typename string, uuid string) (obj contrail.IObject,
+	err error) {
+	err = c.intercept("FindByUuid", typename, true, func(client contrail.ApiClient) error {
+		obj, err = client.FindByUuid(typename, uuid)
+		return err
+	})
+	return
+}
+
+func (c interceptedApiClient) UuidByName(typename string, fqn string) (uuid string,
+	err error) {
+	err = c.intercept("UuidByName", typename, true, func(client contrail.ApiClient) error {
+		uuid, err = client.UuidByName(typename, fqn)
+		return err
+	})
+	return
+}
+
+func (c interceptedApiClient) FQNameByUuid(uuid string) (fqn []string, err error) {
+	err = c.intercept("FQNameByUuid", "", true, func(client contrail.ApiClient) error {
+		fqn, err = client.FQNameByUuid(uuid)
+		return err
+	})
+	return
+}
+
+func (c interceptedApiClient) FindByName(typename string, fqn string) (obj contrail.IObject,
+	err error) {
+	err = c.intercept("FindByName", typename, true, func(client contrail.ApiClient) error {
+		obj, err = client.FindByName(typename, fqn)
+		return err
+	})
+	return
+}
+
+func (c interceptedApiClient) List(typename string) (results []contrail.ListResult,
+	err error) {
+	err = c.intercept("List", typename, true, func(client contrail.ApiClient) error {
+		results, err = client.List(typename)
+		return err
+	})
+	return
+}
+
+func (c interceptedApiClient) ListByParent(typename string, parentId string) (
+	results []contrail.ListResult, err error) {
+	err = c.intercept("ListByParent", typename, true, func(client contrail.ApiClient) error {
+		results, err = client.ListByParent(typename, parentId)
+		return err
+	})
+	return
+}
+
+func (c interceptedApiClient) ListDetail(typename string, fields []string) (
+	objs []contrail.IObject, err error) {
+	err = c.intercept("ListDetail", typename, true, func(client contrail.ApiClient) error {
+		objs, err = client.ListDetail(typename, fields)
+		return err
+	})
+	return
+}
+
+func (c interceptedApiClient) ListDetailByParent(typename string, parentId string,
+	fields []string) (objs []contrail.IObject, err error) {
+	err = c.intercept("ListDetailByParent", typename, true,
+		func(client contrail.ApiClient) error {
+			objs, err = client.ListDetailByParent(typename, parentId, fields)
+			return err
+		})
+	return
+}
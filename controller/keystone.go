@@ -0,0 +1,374 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Juniper/contrail-go-api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Versions of Keystone identity API.
+const (
+	KeystoneV2 = "v2.0"
+	KeystoneV3 = "v3"
+)
+
+// tokenRefreshMargin is how long before expiry Keystone v3 token is renewed, so that requests
+// in flight don't fail.
+const tokenRefreshMargin = time.Minute
+
+// keystoneTimeout is time to wait for Keystone to issue a token.
+const keystoneTimeout = 30 * time.Second
+
+// keystoneClient authenticates requests to Contrail API.
+type keystoneClient interface {
+	contrail.Authenticator
+	Authenticate() error
+}
+
+// versionSegmentRegex matches version segment of Keystone auth URL, like v2.0 or v3.
+var versionSegmentRegex = regexp.MustCompile(`^v[0-9]+(\.[0-9]+)?$`)
+
+// KeystoneAPIVersion returns version of Keystone identity API from its auth URL, like
+// http://keystone:5000/v3. URLs that don't end with version, like http://keystone/identity,
+// are assumed to be v2.0, like before v3 was supported.
+func KeystoneAPIVersion(authURL string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	version := path.Base(strings.TrimRight(u.Path, "/"))
+	switch {
+	case version == KeystoneV3:
+		return KeystoneV3, nil
+	case version == KeystoneV2, !versionSegmentRegex.MatchString(version):
+		return KeystoneV2, nil
+	default:
+		return "", fmt.Errorf("Unsupported Keystone API version: %s", version)
+	}
+}
+
+// newKeystoneClient returns client of Keystone API version of auth URL. Its requests are sent
+// with transport, or http.DefaultTransport if it's nil.
+func newKeystoneClient(keys *KeystoneEnvs, transport http.RoundTripper) (keystoneClient, error) {
+	version, err := KeystoneAPIVersion(keys.Os_auth_url)
+	if err != nil {
+		return nil, err
+	}
+	log.Infoln("Using Keystone API", version)
+	httpClient := &http.Client{Transport: transport, Timeout: keystoneTimeout}
+	if version == KeystoneV3 {
+		return &keystoneV3Client{keystoneToken: keystoneToken{keys: *keys,
+			httpClient: httpClient}}, nil
+	}
+	return &keystoneV2Client{keystoneToken: keystoneToken{keys: *keys,
+		httpClient: httpClient}}, nil
+}
+
+// keystoneToken keeps token of Keystone client and gets a new one with authenticate when the
+// current one is about to expire, like contrail.KeepaliveKeystoneClient does.
+type keystoneToken struct {
+	keys       KeystoneEnvs
+	httpClient *http.Client
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// addToken adds token to request to Contrail API, getting a new one with authenticate first
+// if it's about to expire.
+func (k *keystoneToken) addToken(req *http.Request, authenticate func() error) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.token == "" || time.Now().Add(tokenRefreshMargin).After(k.expiresAt) {
+		if err := authenticate(); err != nil {
+			log.Errorln("Failed to renew Keystone token:", err)
+			return err
+		}
+	}
+	req.Header.Set("X-Auth-Token", k.token)
+	return nil
+}
+
+// post sends authentication request to Keystone and returns its response if it has expected
+// status.
+func (k *keystoneToken) post(url string, authReq interface{}, status int) (*http.Response,
+	error) {
+	body, err := json.Marshal(authReq)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != status {
+		defer resp.Body.Close()
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("Keystone refused to issue a token: %s: %s", resp.Status,
+			strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+func (k *keystoneToken) setToken(token, expiresAt string) error {
+	expiry, err := time.Parse(time.RFC3339Nano, expiresAt)
+	if err != nil {
+		return fmt.Errorf("Malformed expiry time of Keystone token: %v", err)
+	}
+	log.Debugln("Got Keystone token that expires at", expiry)
+	k.token = token
+	k.expiresAt = expiry
+	return nil
+}
+
+// keystoneV2Client authenticates requests with tokens of Keystone v2.0 identity API, scoped to
+// a tenant. It's used instead of contrail.KeepaliveKeystoneClient, whose transport can't be
+// set.
+type keystoneV2Client struct {
+	keystoneToken
+}
+
+type v2AuthRequest struct {
+	Auth v2Auth `json:"auth"`
+}
+
+type v2Auth struct {
+	TenantName          string                 `json:"tenantName,omitempty"`
+	PasswordCredentials *v2PasswordCredentials `json:"passwordCredentials,omitempty"`
+	Token               *v2Token               `json:"token,omitempty"`
+}
+
+type v2PasswordCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type v2Token struct {
+	ID string `json:"id"`
+}
+
+type v2AuthResponse struct {
+	Access struct {
+		Token struct {
+			ID      string `json:"id"`
+			Expires string `json:"expires"`
+		} `json:"token"`
+	} `json:"access"`
+}
+
+// authRequest builds request for a tenant scoped token. Token is used if it's set, and
+// password otherwise.
+func (k *keystoneV2Client) authRequest() *v2AuthRequest {
+	req := &v2AuthRequest{Auth: v2Auth{TenantName: k.keys.Os_tenant_name}}
+	if k.keys.Os_token != "" {
+		req.Auth.Token = &v2Token{ID: k.keys.Os_token}
+	} else {
+		req.Auth.PasswordCredentials = &v2PasswordCredentials{
+			Username: k.keys.Os_username,
+			Password: k.keys.Os_password,
+		}
+	}
+	return req
+}
+
+// Authenticate gets a new token.
+func (k *keystoneV2Client) Authenticate() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.authenticate()
+}
+
+func (k *keystoneV2Client) authenticate() error {
+	tokensURL := strings.TrimRight(k.keys.Os_auth_url, "/") + "/tokens"
+	resp, err := k.post(tokensURL, k.authRequest(), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var authResp v2AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		return fmt.Errorf("Malformed Keystone response: %v", err)
+	}
+	if authResp.Access.Token.ID == "" {
+		return errors.New("Keystone response has no token")
+	}
+	return k.setToken(authResp.Access.Token.ID, authResp.Access.Token.Expires)
+}
+
+// AddAuthentication adds token to request to Contrail API, getting a new one first if it's
+// about to expire.
+func (k *keystoneV2Client) AddAuthentication(req *http.Request) error {
+	return k.addToken(req, k.authenticate)
+}
+
+// keystoneV3Client authenticates requests with tokens of Keystone v3 identity API, scoped to
+// a project.
+type keystoneV3Client struct {
+	keystoneToken
+}
+
+type v3AuthRequest struct {
+	Auth v3Auth `json:"auth"`
+}
+
+type v3Auth struct {
+	Identity v3Identity `json:"identity"`
+	Scope    *v3Scope   `json:"scope,omitempty"`
+}
+
+type v3Identity struct {
+	Methods  []string    `json:"methods"`
+	Password *v3Password `json:"password,omitempty"`
+	Token    *v3Token    `json:"token,omitempty"`
+}
+
+type v3Password struct {
+	User v3User `json:"user"`
+}
+
+type v3User struct {
+	ID       string    `json:"id,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Domain   *v3Domain `json:"domain,omitempty"`
+	Password string    `json:"password"`
+}
+
+type v3Token struct {
+	ID string `json:"id"`
+}
+
+type v3Domain struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type v3Scope struct {
+	Project v3Project `json:"project"`
+}
+
+type v3Project struct {
+	ID     string    `json:"id,omitempty"`
+	Name   string    `json:"name,omitempty"`
+	Domain *v3Domain `json:"domain,omitempty"`
+}
+
+type v3AuthResponse struct {
+	Token struct {
+		ExpiresAt string `json:"expires_at"`
+	} `json:"token"`
+}
+
+func newDomain(id, name string) *v3Domain {
+	if id == "" && name == "" {
+		return nil
+	}
+	return &v3Domain{ID: id, Name: name}
+}
+
+// authRequest builds request for a project scoped token. Like with v2.0, token is used if it's
+// set, and password otherwise. Project name defaults to tenant name.
+func (k *keystoneV3Client) authRequest() (*v3AuthRequest, error) {
+	keys := &k.keys
+	req := &v3AuthRequest{}
+
+	identity := &req.Auth.Identity
+	if keys.Os_token != "" {
+		identity.Methods = []string{"token"}
+		identity.Token = &v3Token{ID: keys.Os_token}
+	} else if keys.Os_username != "" || keys.Os_user_id != "" {
+		identity.Methods = []string{"password"}
+		identity.Password = &v3Password{User: v3User{
+			ID:       keys.Os_user_id,
+			Name:     keys.Os_username,
+			Domain:   newDomain(keys.Os_user_domain_id, keys.Os_user_domain_name),
+			Password: keys.Os_password,
+		}}
+	} else {
+		return nil, errors.New("Neither Keystone token nor user is set")
+	}
+
+	projectName := keys.Os_project_name
+	if projectName == "" {
+		projectName = keys.Os_tenant_name
+	}
+	if keys.Os_project_id != "" || projectName != "" {
+		req.Auth.Scope = &v3Scope{Project: v3Project{
+			ID:     keys.Os_project_id,
+			Name:   projectName,
+			Domain: newDomain(keys.Os_project_domain_id, keys.Os_project_domain_name),
+		}}
+		if keys.Os_project_id != "" {
+			// project ID is unique on its own
+			req.Auth.Scope.Project.Name = ""
+			req.Auth.Scope.Project.Domain = nil
+		}
+	}
+	return req, nil
+}
+
+// Authenticate gets a new token.
+func (k *keystoneV3Client) Authenticate() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.authenticate()
+}
+
+func (k *keystoneV3Client) authenticate() error {
+	authReq, err := k.authRequest()
+	if err != nil {
+		return err
+	}
+
+	tokensURL := strings.TrimRight(k.keys.Os_auth_url, "/") + "/auth/tokens"
+	resp, err := k.post(tokensURL, authReq, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	token := resp.Header.Get("X-Subject-Token")
+	if token == "" {
+		return errors.New("Keystone response has no X-Subject-Token header")
+	}
+	var authResp v3AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		return fmt.Errorf("Malformed Keystone response: %v", err)
+	}
+	return k.setToken(token, authResp.Token.ExpiresAt)
+}
+
+// AddAuthentication adds token to request to Contrail API, getting a new one first if it's
+// about to expire.
+func (k *keystoneV3Client) AddAuthentication(req *http.Request) error {
+	return k.addToken(req, k.authenticate)
+}
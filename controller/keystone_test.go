@@ -0,0 +1,251 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("Detecting Keystone API version",
+	func(authURL, expectedVersion string) {
+		version, err := KeystoneAPIVersion(authURL)
+		if expectedVersion == "" {
+			Expect(err).To(HaveOccurred())
+		} else {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version).To(Equal(expectedVersion))
+		}
+	},
+	Entry("v2.0", "http://keystone:5000/v2.0", KeystoneV2),
+	Entry("v3", "http://keystone:5000/v3", KeystoneV3),
+	Entry("v3 with trailing slash", "http://keystone:5000/v3/", KeystoneV3),
+	Entry("no version", "http://keystone:5000/", KeystoneV2),
+	Entry("no version with path", "http://keystone:5000/identity", KeystoneV2),
+	Entry("v3 behind path", "http://keystone/identity/v3", KeystoneV3),
+	Entry("unknown version", "http://keystone:5000/v4", ""),
+)
+
+var _ = Describe("Keystone v3 client", func() {
+
+	var server *httptest.Server
+	var requests []v3AuthRequest
+	var tokenLifetime time.Duration
+	var keys *KeystoneEnvs
+
+	BeforeEach(func() {
+		requests = nil
+		tokenLifetime = time.Hour
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal("POST"))
+			Expect(r.URL.Path).To(Equal("/v3/auth/tokens"))
+
+			var req v3AuthRequest
+			Expect(json.NewDecoder(r.Body).Decode(&req)).To(Succeed())
+			requests = append(requests, req)
+
+			if req.Auth.Identity.Password != nil &&
+				req.Auth.Identity.Password.User.Password != "secret123" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error": {"code": 401}}`)
+				return
+			}
+			w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%d", len(requests)))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token": {"expires_at": "%s"}}`,
+				time.Now().Add(tokenLifetime).UTC().Format("2006-01-02T15:04:05.000000Z"))
+		}))
+		keys = &KeystoneEnvs{
+			Os_auth_url:            server.URL + "/v3",
+			Os_username:            "admin",
+			Os_password:            "secret123",
+			Os_user_domain_name:    "Default",
+			Os_project_name:        "admin",
+			Os_project_domain_name: "Default",
+		}
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	authenticate := func() (*keystoneV3Client, error) {
+		client, err := newKeystoneClient(keys, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client).To(BeAssignableToTypeOf(&keystoneV3Client{}))
+		return client.(*keystoneV3Client), client.Authenticate()
+	}
+
+	It("gets project scoped token with password", func() {
+		_, err := authenticate()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(requests).To(HaveLen(1))
+		auth := requests[0].Auth
+		Expect(auth.Identity.Methods).To(Equal([]string{"password"}))
+		Expect(auth.Identity.Password.User.Name).To(Equal("admin"))
+		Expect(auth.Identity.Password.User.Domain.Name).To(Equal("Default"))
+		Expect(auth.Scope.Project.Name).To(Equal("admin"))
+		Expect(auth.Scope.Project.Domain.Name).To(Equal("Default"))
+	})
+
+	It("uses tenant name and IDs if project name isn't set", func() {
+		keys.Os_project_name = ""
+		keys.Os_tenant_name = "agatka"
+		keys.Os_user_domain_name = ""
+		keys.Os_user_domain_id = "default"
+		_, err := authenticate()
+		Expect(err).ToNot(HaveOccurred())
+
+		auth := requests[0].Auth
+		Expect(auth.Identity.Password.User.Domain.ID).To(Equal("default"))
+		Expect(auth.Scope.Project.Name).To(Equal("agatka"))
+
+		keys.Os_project_id = "1234"
+		_, err = authenticate()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests[1].Auth.Scope.Project).To(Equal(v3Project{ID: "1234"}))
+	})
+
+	It("prefers token to password", func() {
+		keys.Os_token = "abcd"
+		keys.Os_password = "bad password"
+		_, err := authenticate()
+		Expect(err).ToNot(HaveOccurred())
+
+		auth := requests[0].Auth
+		Expect(auth.Identity.Methods).To(Equal([]string{"token"}))
+		Expect(auth.Identity.Token.ID).To(Equal("abcd"))
+		Expect(auth.Identity.Password).To(BeNil())
+	})
+
+	It("fails with bad password", func() {
+		keys.Os_password = "bad password"
+		_, err := authenticate()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("adds token to requests and renews it before it expires", func() {
+		client, err := authenticate()
+		Expect(err).ToNot(HaveOccurred())
+
+		req, _ := http.NewRequest("GET", "http://contrail:8082/projects", nil)
+		Expect(client.AddAuthentication(req)).To(Succeed())
+		Expect(req.Header.Get("X-Auth-Token")).To(Equal("token-1"))
+		Expect(requests).To(HaveLen(1))
+
+		client.expiresAt = time.Now().Add(tokenRefreshMargin / 2)
+		Expect(client.AddAuthentication(req)).To(Succeed())
+		Expect(req.Header.Get("X-Auth-Token")).To(Equal("token-2"))
+		Expect(requests).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Keystone v2.0 client", func() {
+
+	var server *httptest.Server
+	var requests []v2AuthRequest
+	var keys *KeystoneEnvs
+
+	BeforeEach(func() {
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal("POST"))
+			Expect(r.URL.Path).To(Equal("/v2.0/tokens"))
+
+			var req v2AuthRequest
+			Expect(json.NewDecoder(r.Body).Decode(&req)).To(Succeed())
+			requests = append(requests, req)
+
+			if req.Auth.PasswordCredentials != nil &&
+				req.Auth.PasswordCredentials.Password != "secret123" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error": {"code": 401}}`)
+				return
+			}
+			fmt.Fprintf(w, `{"access": {"token": {"id": "token-%d", "expires": "%s"}}}`,
+				len(requests), time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z"))
+		}))
+		keys = &KeystoneEnvs{
+			Os_auth_url:    server.URL + "/v2.0",
+			Os_username:    "admin",
+			Os_password:    "secret123",
+			Os_tenant_name: "admin",
+		}
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	authenticate := func() (*keystoneV2Client, error) {
+		client, err := newKeystoneClient(keys, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client).To(BeAssignableToTypeOf(&keystoneV2Client{}))
+		return client.(*keystoneV2Client), client.Authenticate()
+	}
+
+	It("gets tenant scoped token with password", func() {
+		_, err := authenticate()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(requests).To(HaveLen(1))
+		auth := requests[0].Auth
+		Expect(auth.TenantName).To(Equal("admin"))
+		Expect(auth.PasswordCredentials.Username).To(Equal("admin"))
+		Expect(auth.Token).To(BeNil())
+	})
+
+	It("prefers token to password", func() {
+		keys.Os_token = "abcd"
+		keys.Os_password = "bad password"
+		_, err := authenticate()
+		Expect(err).ToNot(HaveOccurred())
+
+		auth := requests[0].Auth
+		Expect(auth.Token.ID).To(Equal("abcd"))
+		Expect(auth.PasswordCredentials).To(BeNil())
+	})
+
+	It("fails with bad password", func() {
+		keys.Os_password = "bad password"
+		_, err := authenticate()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("adds token to requests and renews it before it expires", func() {
+		client, err := authenticate()
+		Expect(err).ToNot(HaveOccurred())
+
+		req, _ := http.NewRequest("GET", "http://contrail:8082/projects", nil)
+		Expect(client.AddAuthentication(req)).To(Succeed())
+		Expect(req.Header.Get("X-Auth-Token")).To(Equal("token-1"))
+
+		client.expiresAt = time.Now().Add(tokenRefreshMargin / 2)
+		Expect(client.AddAuthentication(req)).To(Succeed())
+		Expect(req.Header.Get("X-Auth-Token")).To(Equal("token-2"))
+		Expect(requests).To(HaveLen(2))
+	})
+})
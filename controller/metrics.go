@@ -0,0 +1,71 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"time"
+
+	"github.com/Juniper/contrail-go-api"
+	"github.com/codilime/contrail-windows-docker/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "controller_request_duration_seconds",
+		Help:      "Duration of requests to Contrail API, by operation and type of object.",
+		Buckets:   metrics.DefaultBuckets,
+	}, []string{"operation", "type"})
+	requestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "controller_request_errors_total",
+		Help: "Number of requests to Contrail API that failed, by operation and type of " +
+			"object.",
+	}, []string{"operation", "type"})
+	requestRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "controller_request_retries_total",
+		Help: "Number of requests to Contrail API that were retried, by operation, type of " +
+			"object and reason (auth or transient).",
+	}, []string{"operation", "type", "reason"})
+)
+
+func init() {
+	metrics.DefaultRegistry.MustRegister(requestDuration, requestErrors, requestRetries)
+}
+
+// observeRequest records request that started at start. It's deferred with pointer to named
+// error result, so that the final error is seen.
+func observeRequest(op, typename string, start time.Time, err *error) {
+	requestDuration.WithLabelValues(op, typename).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		requestErrors.WithLabelValues(op, typename).Inc()
+	}
+}
+
+// newInstrumentedApiClient returns client that records metrics of every request to Contrail
+// API that it sends with client. All requests of Controller go through its ApiClient, so this
+// covers them all.
+func newInstrumentedApiClient(client contrail.ApiClient) contrail.ApiClient {
+	return interceptedApiClient{
+		intercept: func(op, typename string, idempotent bool,
+			call func(client contrail.ApiClient) error) (err error) {
+			defer observeRequest(op, typename, time.Now(), &err)
+			return call(client)
+		},
+	}
+}
@@ -0,0 +1,149 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+
+	"github.com/Juniper/contrail-go-api"
+	log "github.com/sirupsen/logrus"
+)
+
+// relaySecretHeader carries secret of relays of this process. Relays refuse requests without it,
+// so that other local processes can't use them to reach Contrail API with our client
+// certificate.
+const relaySecretHeader = "X-Contrail-Relay-Secret"
+
+// errRelayDial starts body of responses of relay that couldn't connect to Contrail API
+// endpoint, so that requests relayed to it are known not to have reached it.
+const errRelayDial = "Relay couldn't connect to Contrail API endpoint"
+
+// apiRelay passes requests of contrail-go-api client to Contrail API endpoint with TLS
+// transport. contrail-go-api client can't be given an HTTP client and always builds http://
+// URLs, so when TLS is configured, the client is pointed at the relay, served on loopback, and
+// the relay sends requests on with scheme and transport of TLSConfig.
+type apiRelay struct {
+	listener net.Listener
+	proxy    *httputil.ReverseProxy
+	secret   string
+	// base and targetBases are URL prefixes of the relay and of Contrail API endpoint. Contrail
+	// API responds with hrefs of the endpoint, which are rewritten to go through the relay too.
+	base        []byte
+	targetBases [][]byte
+}
+
+// newRelaySecret returns random secret shared by relays of the process and their clients.
+func newRelaySecret() (string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// newAPIRelay starts relay of requests to Contrail API at address, which is host:port, served
+// over scheme with transport. It serves for the lifetime of the process.
+func newAPIRelay(scheme, address string, transport http.RoundTripper, secret string) (*apiRelay,
+	error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &apiRelay{
+		listener: listener,
+		secret:   secret,
+		base:     []byte("http://" + listener.Addr().String()),
+		targetBases: [][]byte{
+			[]byte("http://" + address),
+			[]byte("https://" + address),
+		},
+	}
+	r.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = scheme
+			req.URL.Host = address
+			req.Host = address
+			req.Header.Del(relaySecretHeader)
+			// responses are rewritten, so they mustn't be compressed
+			req.Header.Del("Accept-Encoding")
+		},
+		Transport:      transport,
+		ModifyResponse: r.rewriteHrefs,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Warnf("Relay of %s %s to Contrail API endpoint %s failed: %v", req.Method,
+				req.URL.Path, address, err)
+			if isDialError(err) {
+				http.Error(w, fmt.Sprintf("%s: %v", errRelayDial, err), http.StatusBadGateway)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		},
+	}
+	go http.Serve(listener, r)
+	return r, nil
+}
+
+// port returns port that the relay listens on, on loopback.
+func (r *apiRelay) port() int {
+	return r.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (r *apiRelay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	secret := req.Header.Get(relaySecretHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(r.secret)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	r.proxy.ServeHTTP(w, req)
+}
+
+func (r *apiRelay) rewriteHrefs(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	for _, targetBase := range r.targetBases {
+		body = bytes.Replace(body, targetBase, r.base, -1)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// relayAuthenticator adds relay secret to requests authenticated by Authenticator.
+type relayAuthenticator struct {
+	contrail.Authenticator
+	secret string
+}
+
+func (a *relayAuthenticator) AddAuthentication(req *http.Request) error {
+	if err := a.Authenticator.AddAuthentication(req); err != nil {
+		return err
+	}
+	req.Header.Set(relaySecretHeader, a.secret)
+	return nil
+}
@@ -0,0 +1,141 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/Juniper/contrail-go-api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type nopAuthenticator struct{}
+
+func (nopAuthenticator) AddAuthentication(req *http.Request) error {
+	return nil
+}
+
+var _ = Describe("Relay of Contrail API", func() {
+
+	const secret = "secret"
+
+	var server *httptest.Server
+	var address string
+	var requests []*http.Request
+	var transport *tlsTransport
+
+	BeforeEach(func() {
+		requests = nil
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			requests = append(requests, r)
+			fmt.Fprintf(w, `{"virtual-networks": [{"href": "https://%s/virtual-network/1"}]}`,
+				r.Host)
+		}))
+		address = server.Listener.Addr().String()
+
+		var err error
+		transport, err = newTLSTransport(&TLSConfig{Scheme: "https", InsecureSkipVerify: true})
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newClient := func(relay *apiRelay) *contrail.Client {
+		client := contrail.NewClient("127.0.0.1", relay.port())
+		client.SetAuthenticator(&relayAuthenticator{Authenticator: nopAuthenticator{},
+			secret: secret})
+		return client
+	}
+
+	get := func(relay *apiRelay, path string, withSecret bool) (int, string) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d%s", relay.port(),
+			path), nil)
+		Expect(err).ToNot(HaveOccurred())
+		if withSecret {
+			req.Header.Set(relaySecretHeader, secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		return resp.StatusCode, string(body)
+	}
+
+	It("passes requests of contrail-go-api client to endpoint over https", func() {
+		relay, err := newAPIRelay("https", address, transport, secret)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = newClient(relay).List("virtual-network")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].URL.Path).To(Equal("/virtual-networks"))
+		Expect(requests[0].Host).To(Equal(address))
+		Expect(requests[0].Header.Get(relaySecretHeader)).To(BeEmpty())
+	})
+
+	It("refuses requests without secret", func() {
+		relay, err := newAPIRelay("https", address, transport, secret)
+		Expect(err).ToNot(HaveOccurred())
+
+		status, _ := get(relay, "/virtual-networks", false)
+		Expect(status).To(Equal(http.StatusForbidden))
+		Expect(requests).To(BeEmpty())
+	})
+
+	It("rewrites hrefs of endpoint to go through relay", func() {
+		relay, err := newAPIRelay("https", address, transport, secret)
+		Expect(err).ToNot(HaveOccurred())
+
+		status, body := get(relay, "/virtual-networks", true)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(fmt.Sprintf(`"http://127.0.0.1:%d/virtual-network/1"`,
+			relay.port())))
+		Expect(body).ToNot(ContainSubstring(address))
+	})
+
+	It("reports that requests didn't reach endpoint it couldn't connect to", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		closedAddress := listener.Addr().String()
+		listener.Close()
+		relay, err := newAPIRelay("https", closedAddress, transport, secret)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = newClient(relay).DeleteByUuid("virtual-network", "1")
+		Expect(err).To(HaveOccurred())
+		Expect(isDialError(err)).To(BeTrue())
+		Expect(isTransientError(err)).To(BeTrue())
+	})
+
+	It("doesn't report other failures as connection errors", func() {
+		plain := httptest.NewServer(http.NotFoundHandler())
+		defer plain.Close()
+		relay, err := newAPIRelay("https", plain.Listener.Addr().String(), transport, secret)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = newClient(relay).DeleteByUuid("virtual-network", "1")
+		Expect(err).To(HaveOccurred())
+		Expect(isDialError(err)).To(BeFalse())
+	})
+})
@@ -0,0 +1,126 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Juniper/contrail-go-api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// readRetries is how many times reads are retried after transient errors.
+	readRetries = 3
+	// readRetryBackoff is delay before the first retry of read, doubled for every next one.
+	readRetryBackoff = 500 * time.Millisecond
+)
+
+// contrail-go-api reports unexpected responses as errors starting with HTTP status, like
+// "401 Unauthorized: ...".
+var httpStatusRegex = regexp.MustCompile(`^([1-5][0-9][0-9]) `)
+
+// httpStatus returns HTTP status of Contrail API response that err reports, or 0 if it's not
+// such an error.
+func httpStatus(err error) int {
+	match := httpStatusRegex.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	status, _ := strconv.Atoi(match[1])
+	return status
+}
+
+// IsNotFound tells if err reports that requested object doesn't exist in Contrail API.
+func IsNotFound(err error) bool {
+	return err != nil && httpStatus(err) == 404
+}
+
+func isAuthError(err error) bool {
+	status := httpStatus(err)
+	return status == 401 || status == 403
+}
+
+// isTransientError tells if request may succeed when retried: Contrail API failed internally,
+// is unavailable, or couldn't be connected to.
+func isTransientError(err error) bool {
+	if status := httpStatus(err); status >= 500 {
+		return true
+	}
+	_, isNetError := err.(net.Error)
+	return isNetError
+}
+
+// retryingApiClient retries requests to Contrail API. When a request is refused because
+// Keystone token expired or was revoked, it authenticates again and retries the request once.
+// Reads, which are idempotent, are also retried a few times after transient errors.
+type retryingApiClient struct {
+	interceptedApiClient
+	client   contrail.ApiClient
+	keystone keystoneClient
+	retries  int
+	backoff  time.Duration
+}
+
+func newRetryingApiClient(client contrail.ApiClient, keystone keystoneClient) *retryingApiClient {
+	c := &retryingApiClient{
+		client:   client,
+		keystone: keystone,
+		retries:  readRetries,
+		backoff:  readRetryBackoff,
+	}
+	c.interceptedApiClient = interceptedApiClient{intercept: c.do}
+	return c
+}
+
+// do runs request until it succeeds or fails for good. Only idempotent requests are retried
+// after transient errors.
+func (c *retryingApiClient) do(op, typename string, idempotent bool,
+	call func(client contrail.ApiClient) error) error {
+	reauthenticated := false
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := call(c.client)
+		if err == nil {
+			return nil
+		}
+
+		if isAuthError(err) && !reauthenticated {
+			log.Warnf("%s of %s refused by Contrail API, authenticating again: %v", op,
+				typename, err)
+			requestRetries.WithLabelValues(op, typename, "auth").Inc()
+			if authErr := c.keystone.Authenticate(); authErr != nil {
+				log.Errorln("Failed to authenticate in Keystone:", authErr)
+				return err
+			}
+			reauthenticated = true
+			continue
+		}
+
+		if idempotent && isTransientError(err) && attempt < c.retries {
+			log.Warnf("%s of %s failed (attempt %d of %d), retrying in %v: %v", op, typename,
+				attempt+1, c.retries+1, backoff, err)
+			requestRetries.WithLabelValues(op, typename, "transient").Inc()
+			time.Sleep(backoff)
+			backoff *= 2
+			continue
+		}
+		return err
+	}
+}
@@ -0,0 +1,139 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Juniper/contrail-go-api"
+	"github.com/Juniper/contrail-go-api/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// failingApiClient fails requests with errors, one per request, and then succeeds.
+type failingApiClient struct {
+	contrail.ApiClient
+	errors   []error
+	requests int
+}
+
+func (c *failingApiClient) next() error {
+	c.requests++
+	if len(c.errors) == 0 {
+		return nil
+	}
+	err := c.errors[0]
+	c.errors = c.errors[1:]
+	return err
+}
+
+func (c *failingApiClient) Create(ptr contrail.IObject) error {
+	return c.next()
+}
+
+func (c *failingApiClient) FindByName(typename string, fqn string) (contrail.IObject, error) {
+	if err := c.next(); err != nil {
+		return nil, err
+	}
+	return &types.VirtualNetwork{}, nil
+}
+
+type countingKeystone struct {
+	authentications int
+	err             error
+}
+
+func (k *countingKeystone) Authenticate() error {
+	k.authentications++
+	return k.err
+}
+
+func (k *countingKeystone) AddAuthentication(*http.Request) error {
+	return nil
+}
+
+var _ = Describe("Retrying Contrail API requests", func() {
+
+	unauthorized := errors.New("401 Unauthorized: token expired")
+	unavailable := errors.New("503 Service Unavailable: ")
+	notFound := errors.New("404 Resource not found")
+
+	var failing *failingApiClient
+	var keystone *countingKeystone
+	var client *retryingApiClient
+
+	BeforeEach(func() {
+		failing = &failingApiClient{}
+		keystone = &countingKeystone{}
+		client = newRetryingApiClient(failing, keystone)
+		client.backoff = 0
+	})
+
+	It("authenticates again and retries once when token is refused", func() {
+		failing.errors = []error{unauthorized}
+		Expect(client.Create(&types.VirtualNetwork{})).To(Succeed())
+		Expect(keystone.authentications).To(Equal(1))
+		Expect(failing.requests).To(Equal(2))
+
+		failing.errors = []error{unauthorized, unauthorized}
+		Expect(client.Create(&types.VirtualNetwork{})).To(MatchError(unauthorized))
+		Expect(keystone.authentications).To(Equal(2))
+	})
+
+	It("doesn't retry when authentication fails", func() {
+		failing.errors = []error{unauthorized}
+		keystone.err = errors.New("Keystone is down")
+		_, err := client.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).To(MatchError(unauthorized))
+		Expect(failing.requests).To(Equal(1))
+	})
+
+	It("retries reads after transient errors, up to a limit", func() {
+		failing.errors = []error{unavailable, unavailable}
+		obj, err := client.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(obj).ToNot(BeNil())
+		Expect(failing.requests).To(Equal(3))
+
+		failing.requests = 0
+		failing.errors = []error{unavailable, unavailable, unavailable, unavailable, unavailable}
+		_, err = client.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).To(MatchError(unavailable))
+		Expect(failing.requests).To(Equal(readRetries + 1))
+	})
+
+	It("doesn't retry writes after transient errors", func() {
+		failing.errors = []error{unavailable}
+		Expect(client.Create(&types.VirtualNetwork{})).To(MatchError(unavailable))
+		Expect(failing.requests).To(Equal(1))
+	})
+
+	It("doesn't retry other errors", func() {
+		failing.errors = []error{notFound}
+		_, err := client.FindByName("virtual-network", "default-domain:admin:net")
+		Expect(err).To(MatchError(notFound))
+		Expect(failing.requests).To(Equal(1))
+		Expect(keystone.authentications).To(Equal(0))
+	})
+
+	It("tells errors of missing objects apart", func() {
+		Expect(IsNotFound(notFound)).To(BeTrue())
+		Expect(IsNotFound(unavailable)).To(BeFalse())
+		Expect(IsNotFound(nil)).To(BeFalse())
+	})
+})
@@ -0,0 +1,118 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TLSConfig configures TLS of connections to Contrail API and Keystone.
+type TLSConfig struct {
+	// Scheme of Contrail API, http or https. Scheme of Keystone is in its auth URL.
+	Scheme string
+	// CAFile has certificates of CAs that servers are verified with. If empty, system CAs are
+	// used.
+	CAFile string
+	// CertFile and KeyFile are client certificate and its key, for servers that require one.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables verification of servers. Use it only for testing.
+	InsecureSkipVerify bool
+}
+
+func (t *TLSConfig) clientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		caCert, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("No certificates found in %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// tlsTransport sends requests to Contrail API and Keystone with TLS configuration of
+// TLSConfig. It's only used by HTTP clients of Keystone and relays of Contrail API, so other HTTP
+// clients of the process are not affected.
+type tlsTransport struct {
+	*http.Transport
+}
+
+func newTLSTransport(t *TLSConfig) (*tlsTransport, error) {
+	if t.Scheme != "http" && t.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid scheme of Contrail API: %s", t.Scheme)
+	}
+	tlsConfig, err := t.clientConfig()
+	if err != nil {
+		log.Errorln("Failed to configure TLS of Contrail API:", err)
+		return nil, err
+	}
+	if t.InsecureSkipVerify {
+		log.Warnln("Certificates of Contrail API and Keystone are not verified")
+	}
+	transport := &tlsTransport{
+		// the same as http.DefaultTransport, but with our TLS configuration
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			TLSClientConfig:       tlsConfig,
+		},
+	}
+	return transport, nil
+}
+
+func (t *tlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil && req.URL.Scheme == "https" && isTLSError(err) {
+		return nil, fmt.Errorf("TLS handshake with %s failed, check CA file and client "+
+			"certificate: %v", req.URL.Host, err)
+	}
+	return resp, err
+}
+
+func isTLSError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "x509:") || strings.Contains(msg, "tls:")
+}
@@ -0,0 +1,114 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS of Contrail API", func() {
+
+	var server *httptest.Server
+	var address string
+	var dir string
+	var caFile string
+
+	BeforeEach(func() {
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		address = server.Listener.Addr().String()
+
+		var err error
+		dir, err = ioutil.TempDir("", "controller")
+		Expect(err).ToNot(HaveOccurred())
+		caFile = filepath.Join(dir, "ca.pem")
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+			Bytes: server.TLS.Certificates[0].Certificate[0]})
+		Expect(ioutil.WriteFile(caFile, caPEM, 0644)).To(Succeed())
+	})
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	get := func(config *TLSConfig) (int, error) {
+		transport, err := newTLSTransport(config)
+		Expect(err).ToNot(HaveOccurred())
+		client := &http.Client{Transport: transport}
+		resp, err := client.Get(config.Scheme + "://" + address + "/projects")
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	It("verifies server with CA file", func() {
+		Expect(get(&TLSConfig{Scheme: "https", CAFile: caFile})).To(Equal(http.StatusOK))
+	})
+
+	It("reports failed handshake clearly", func() {
+		_, err := get(&TLSConfig{Scheme: "https"})
+		Expect(err).To(MatchError(ContainSubstring("TLS handshake with")))
+	})
+
+	It("skips verification if asked to", func() {
+		Expect(get(&TLSConfig{Scheme: "https", InsecureSkipVerify: true})).To(
+			Equal(http.StatusOK))
+	})
+
+	It("is used by Contrail API and Keystone clients only", func() {
+		defaultTransport := http.DefaultTransport
+		keys := &KeystoneEnvs{Os_auth_url: "https://" + address + "/v3"}
+		connector, err := NewConnector([]string{address}, keys,
+			&TLSConfig{Scheme: "https", CAFile: caFile})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(http.DefaultTransport).To(BeIdenticalTo(defaultTransport))
+
+		keystone := connector.keystone.(*keystoneV3Client)
+		Expect(keystone.httpClient.Transport).To(BeAssignableToTypeOf(&tlsTransport{}))
+	})
+
+	It("fails with invalid CA file or client certificate", func() {
+		_, err := newTLSTransport(&TLSConfig{Scheme: "https",
+			CAFile: filepath.Join(dir, "missing.pem")})
+		Expect(err).To(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "empty.pem"), nil, 0644)).To(Succeed())
+		_, err = newTLSTransport(&TLSConfig{Scheme: "https",
+			CAFile: filepath.Join(dir, "empty.pem")})
+		Expect(err).To(HaveOccurred())
+
+		_, err = newTLSTransport(&TLSConfig{Scheme: "https", CertFile: caFile,
+			KeyFile: caFile})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails with invalid scheme", func() {
+		_, err := newTLSTransport(&TLSConfig{Scheme: "ftp"})
+		Expect(err).To(HaveOccurred())
+	})
+})
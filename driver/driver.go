@@ -19,62 +19,127 @@
 package driver
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
-	"os"
+	"sort"
 	"strings"
-	"time"
 
 	"context"
 
 	"github.com/Juniper/contrail-go-api/types"
-	"github.com/Microsoft/go-winio"
-	"github.com/Microsoft/hcsshim"
 	"github.com/codilime/contrail-windows-docker/agent"
 	"github.com/codilime/contrail-windows-docker/common"
 	"github.com/codilime/contrail-windows-docker/controller"
 	"github.com/codilime/contrail-windows-docker/hns"
 	"github.com/codilime/contrail-windows-docker/hnsManager"
 	"github.com/codilime/contrail-windows-docker/hyperv"
+	"github.com/codilime/contrail-windows-docker/state"
+	"github.com/codilime/contrail-windows-docker/transport"
 	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
 	dockerClient "github.com/docker/docker/client"
-	"github.com/docker/go-connections/sockets"
+	"github.com/docker/go-plugins-helpers/ipam"
 	"github.com/docker/go-plugins-helpers/network"
 	"github.com/docker/libnetwork/netlabel"
 	log "github.com/sirupsen/logrus"
 )
 
+// securityGroupsOption is docker network and endpoint option with comma separated UUIDs or
+// FQNames of Contrail security groups to attach to container interfaces.
+const securityGroupsOption = "security_groups"
+
+// securityGroupsLabel is container label that works like securityGroupsOption.
+const securityGroupsLabel = "contrail.security_groups"
+
+// floatingIPPoolOption is docker network option with FQName of Contrail floating IP pool, like
+// "default-domain:admin:public:default". If set, published ports of endpoints are exposed through
+// floating IPs from this pool.
+const floatingIPPoolOption = "floating-ip-pool"
+
+// IP protocol numbers used by libnetwork's types.PortBinding.
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+// Options that AllocateNetwork passes to workers in global scope, besides tenant and network.
+const (
+	networkUuidOption = "contrail.network.uuid"
+	subnetCIDRsOption = "contrail.subnets"
+)
+
+// Route types of JoinResponse static routes, as defined in libnetwork/types.
+const (
+	routeTypeNextHop   = 0
+	routeTypeConnected = 1
+)
+
 type ContrailDriver struct {
 	controller         *controller.Controller
+	agentQueue         *agent.Queue
+	scope              string
+	hns                hns.Service
 	hnsMgr             *hnsManager.HNSManager
+	store              *state.Store
+	ipam               *ContrailIpam
 	networkAdapter     common.AdapterName
 	vswitchName        common.VSwitchName
-	listener           net.Listener
-	PipeAddr           string
+	listener           transport.Listener
+	ipamListener       transport.Listener
 	stopChan           chan interface{}
 	stoppedServingChan chan interface{}
 	IsServing          bool
+	// RequireAgentPort makes Join fail if container's interface can't be added to vRouter
+	// Agent. Otherwise, the failure is only recorded in agent queue's port status.
+	RequireAgentPort bool
+	// AgentPortFiles keep ports of containers for vRouter Agent to read when it starts. If nil,
+	// ports are only added through agent's API.
+	AgentPortFiles *agent.PortFiles
+	// Transport makes the driver reachable by docker. By default, named pipes are used.
+	Transport transport.Transport
 }
 
 type NetworkMeta struct {
-	tenant     string
-	network    string
-	subnetCIDR string
+	tenant         string
+	network        string
+	subnetCIDRs    []string
+	floatingIPPool string
+	securityGroups []string
+}
+
+// portBinding mirrors libnetwork's types.PortBinding, as received in endpoint options.
+type portBinding struct {
+	Proto       int
+	IP          string
+	Port        int
+	HostIP      string
+	HostPort    int
+	HostPortEnd int
 }
 
-func NewDriver(adapter, vswitchName string, c *controller.Controller) *ContrailDriver {
+// NewDriver creates a driver of specified scope. In global scope (swarm), docker managers
+// allocate networks with AllocateNetwork and pass Contrail network info to workers. Networks
+// and endpoints created by the driver are recorded in store. Container interfaces are added to
+// vRouter Agent through agentQueue. HNS networks and endpoints are managed through hnsService.
+func NewDriver(adapter, vswitchName, scope string, c *controller.Controller,
+	agentQueue *agent.Queue, store *state.Store, hnsService hns.Service) *ContrailDriver {
 
 	d := &ContrailDriver{
 		controller:         c,
-		hnsMgr:             &hnsManager.HNSManager{},
+		agentQueue:         agentQueue,
+		scope:              scope,
+		hns:                hnsService,
+		hnsMgr:             hnsManager.NewHNSManager(hnsService),
+		store:              store,
+		ipam:               NewIpam(c),
 		networkAdapter:     common.AdapterName(adapter),
 		vswitchName:        common.VSwitchName(vswitchName),
-		PipeAddr:           "//./pipe/" + common.DriverName,
 		stopChan:           make(chan interface{}, 1),
 		stoppedServingChan: make(chan interface{}, 1),
 		IsServing:          false,
+		Transport:          transport.NewPipeTransport(common.PluginSpecDir()),
 	}
 	return d
 }
@@ -129,38 +194,36 @@ func (d *ContrailDriver) StartServing() error {
 			d.stoppedServingChan <- true
 		}()
 
-		pipeConfig := winio.PipeConfig{
-			// This will set permissions for Service, System, Adminstrator group and account to
-			// have full access
-			SecurityDescriptor: "D:(A;ID;FA;;;SY)(A;ID;FA;;;BA)(A;ID;FA;;;LA)(A;ID;FA;;;LS)",
-			MessageMode:        true,
-			InputBufferSize:    4096,
-			OutputBufferSize:   4096,
-		}
-
 		var err error
-		d.listener, err = winio.ListenPipe(d.PipeAddr, &pipeConfig)
+		d.listener, err = d.Transport.Listen(common.DriverName)
 		if err != nil {
-			failedChan <- errors.New(fmt.Sprintln("When setting up listener:", err))
+			failedChan <- err
 			return
 		}
+		defer d.listener.Close()
 
-		h := network.NewHandler(d)
+		h := network.NewHandler(instrumentedDriver{d})
 		go h.Serve(d.listener)
 
-		if err := os.MkdirAll(common.PluginSpecDir(), 0755); err != nil {
-			failedChan <- errors.New(fmt.Sprintln("When setting up plugin spec directory:", err))
+		// IPAM driver is served alongside the network driver, under a separate plugin name.
+		d.ipamListener, err = d.Transport.Listen(common.IpamDriverName)
+		if err != nil {
+			failedChan <- err
 			return
 		}
+		defer d.ipamListener.Close()
+
+		ipamHandler := ipam.NewHandler(instrumentedIpam{d.ipam})
+		go ipamHandler.Serve(d.ipamListener)
 
-		url := "npipe://" + d.listener.Addr().String()
-		if err := ioutil.WriteFile(common.PluginSpecFilePath(), []byte(url), 0644); err != nil {
-			failedChan <- errors.New(fmt.Sprintln("When creating spec file:", err))
+		if err := d.listener.WaitUntilReady(); err != nil {
+			failedChan <- errors.New(fmt.Sprintln("When waiting for listener to start:", err))
 			return
 		}
 
-		if err := d.waitForPipeToStart(); err != nil {
-			failedChan <- errors.New(fmt.Sprintln("When waiting for pipe to start:", err))
+		if err := d.ipamListener.WaitUntilReady(); err != nil {
+			failedChan <- errors.New(fmt.Sprintln("When waiting for IPAM listener to start:",
+				err))
 			return
 		}
 
@@ -168,25 +231,11 @@ func (d *ContrailDriver) StartServing() error {
 		startedServingChan <- true
 
 		<-d.stopChan
-
-		log.Infoln("Closing npipe listener")
-		if err := d.listener.Close(); err != nil {
-			log.Warnln("When closing listener:", err)
-		}
-
-		log.Infoln("Removing spec file")
-		if err := os.Remove(common.PluginSpecFilePath()); err != nil {
-			log.Warnln("When removing spec file:", err)
-		}
-
-		if err := d.waitForPipeToStop(); err != nil {
-			log.Warnln("Failed to properly close named pipe, but will continue anyways:", err)
-		}
 	}()
 
 	select {
 	case <-startedServingChan:
-		log.Infoln("Started serving on ", d.PipeAddr)
+		log.Infoln("Started serving on", d.listener.URL(), "and", d.ipamListener.URL())
 		return nil
 	case err := <-failedChan:
 		log.Error(err)
@@ -207,7 +256,7 @@ func (d *ContrailDriver) StopServing() error {
 func (d *ContrailDriver) GetCapabilities() (*network.CapabilitiesResponse, error) {
 	log.Debugln("=== GetCapabilities")
 	r := &network.CapabilitiesResponse{}
-	r.Scope = network.LocalScope
+	r.Scope = d.scope
 	return r, nil
 }
 
@@ -248,11 +297,17 @@ func (d *ContrailDriver) CreateNetwork(req *network.CreateNetworkRequest) error
 		return errors.New("Network name not specified")
 	}
 
-	// this is subnet already in CIDR format
+	// these are subnets already in CIDR format
 	if len(req.IPv4Data) == 0 {
 		return errors.New("Docker subnet IPv4 data missing")
 	}
-	ipPool := req.IPv4Data[0].Pool
+	var ipPools []string
+	for _, ipamData := range req.IPv4Data {
+		ipPools = append(ipPools, ipamData.Pool)
+	}
+	for _, ipamData := range req.IPv6Data {
+		ipPools = append(ipPools, ipamData.Pool)
+	}
 
 	// Check if network is already created in Contrail.
 	contrailNetwork, err := d.controller.GetNetwork(tenant.(string), netName.(string))
@@ -265,35 +320,148 @@ func (d *ContrailDriver) CreateNetwork(req *network.CreateNetworkRequest) error
 
 	log.Infoln("Got Contrail network", contrailNetwork.GetDisplayName())
 
-	contrailIpam, err := d.controller.GetIpamSubnet(contrailNetwork, ipPool)
+	// In global scope, swarm manager has already resolved Contrail network in AllocateNetwork.
+	if netUuid, exists := genericOptions[networkUuidOption]; exists &&
+		netUuid != contrailNetwork.GetUuid() {
+		return fmt.Errorf("Contrail network %s was allocated as %v, but now it is %s",
+			netName, netUuid, contrailNetwork.GetUuid())
+	}
+	if subnetCIDRs, ok := genericOptions[subnetCIDRsOption].(string); ok && subnetCIDRs != "" {
+		ipPools = strings.Split(subnetCIDRs, ",")
+	}
+
+	contrailIpams, err := d.contrailSubnets(contrailNetwork, ipPools)
 	if err != nil {
 		return err
 	}
-	subnetCIDR := d.getContrailSubnetCIDR(contrailIpam)
 
-	contrailGateway := contrailIpam.DefaultGateway
-	if contrailGateway == "" {
-		return errors.New("Default GW is empty")
+	var subnets []hns.Subnet
+	for _, contrailIpam := range contrailIpams {
+		contrailGateway := contrailIpam.DefaultGateway
+		if contrailGateway == "" {
+			return errors.New("Default GW is empty")
+		}
+		subnets = append(subnets, hns.Subnet{
+			AddressPrefix:  contrailSubnetCIDR(contrailIpam),
+			GatewayAddress: contrailGateway,
+		})
 	}
 
-	_, err = d.hnsMgr.CreateNetwork(d.networkAdapter, tenant.(string), netName.(string),
-		subnetCIDR, contrailGateway)
+	var undo rollback
+	defer undo.run()
 
-	return err
+	hnsNet, err := d.hnsMgr.CreateNetwork(d.networkAdapter, tenant.(string), netName.(string),
+		subnets)
+	if err != nil {
+		return err
+	}
+	undo.add("HNS network "+hnsNet.Id, func() error {
+		return d.hnsMgr.DeleteNetwork(tenant.(string), netName.(string),
+			contrailSubnetCIDRs(contrailIpams))
+	})
+
+	storedNet := state.Network{
+		ID:           req.NetworkID,
+		Tenant:       tenant.(string),
+		Network:      netName.(string),
+		ContrailUuid: contrailNetwork.GetUuid(),
+		SubnetCIDRs:  contrailSubnetCIDRs(contrailIpams),
+		HNSID:        hnsNet.Id,
+	}
+	if pool, ok := genericOptions[floatingIPPoolOption].(string); ok {
+		storedNet.FloatingIPPool = pool
+	}
+	if groups, ok := genericOptions[securityGroupsOption].(string); ok {
+		storedNet.SecurityGroups = splitList(groups)
+	}
+	if err := d.store.SaveNetwork(storedNet); err != nil {
+		return err
+	}
+
+	undo.commit()
+	return nil
 }
 
 func (d *ContrailDriver) AllocateNetwork(req *network.AllocateNetworkRequest) (
 	*network.AllocateNetworkResponse, error) {
 	log.Debugln("=== AllocateNetwork")
 	log.Debugln(req)
-	// This method is used in swarm, in remote plugins. We don't implement it.
-	return nil, errors.New("AllocateNetwork is not implemented")
+	// This method is used in swarm, on manager nodes, in global scope only.
+	if d.scope != network.GlobalScope {
+		return nil, errors.New("AllocateNetwork is only supported in global scope")
+	}
+
+	tenant, exists := req.Options["tenant"]
+	if !exists {
+		return nil, errors.New("Tenant not specified")
+	}
+
+	netName, exists := req.Options["network"]
+	if !exists {
+		return nil, errors.New("Network name not specified")
+	}
+
+	contrailNetwork, err := d.controller.GetNetwork(tenant, netName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ipPools []string
+	for _, ipamData := range req.IPv4Data {
+		ipPools = append(ipPools, ipamData.Pool)
+	}
+	for _, ipamData := range req.IPv6Data {
+		ipPools = append(ipPools, ipamData.Pool)
+	}
+	if len(ipPools) == 0 {
+		return nil, errors.New("Docker subnet IPAM data missing")
+	}
+
+	contrailIpams, err := d.contrailSubnets(contrailNetwork, ipPools)
+	if err != nil {
+		return nil, err
+	}
+
+	// These options are stored by swarm and passed to CreateNetwork on every worker.
+	r := &network.AllocateNetworkResponse{
+		Options: map[string]string{
+			"tenant":          tenant,
+			"network":         netName,
+			networkUuidOption: contrailNetwork.GetUuid(),
+			subnetCIDRsOption: strings.Join(contrailSubnetCIDRs(contrailIpams), ","),
+		},
+	}
+	return r, nil
 }
 
 func (d *ContrailDriver) DeleteNetwork(req *network.DeleteNetworkRequest) error {
 	log.Debugln("=== DeleteNetwork")
 	log.Debugln(req)
 
+	if storedNet := d.store.GetNetwork(req.NetworkID); storedNet != nil {
+		hnsNets, err := d.hnsMgr.ListNetworks()
+		if err != nil {
+			return err
+		}
+		hnsNetExists := false
+		for _, hnsNet := range hnsNets {
+			if hnsNet.Id == storedNet.HNSID {
+				hnsNetExists = true
+				break
+			}
+		}
+		if !hnsNetExists {
+			// for example, HNS was hard-reset while the driver wasn't.
+			log.Warn("When handling DeleteNetwork, HNS network was already removed")
+		} else if err := d.hnsMgr.DeleteNetwork(storedNet.Tenant, storedNet.Network,
+			storedNet.SubnetCIDRs); err != nil {
+			return err
+		}
+		return d.store.RemoveNetwork(req.NetworkID)
+	}
+
+	// Network was created before the driver started to record networks in its store, so find
+	// out which one it was by comparing docker and HNS networks.
 	dockerNetsMeta, err := d.dockerNetworksMeta()
 	log.Debugln("Current docker-Contrail networks meta", dockerNetsMeta)
 	if err != nil {
@@ -312,7 +480,7 @@ func (d *ContrailDriver) DeleteNetwork(req *network.DeleteNetworkRequest) error
 		matchFound := false
 		for _, dockerMeta := range dockerNetsMeta {
 			if dockerMeta.tenant == hnsMeta.tenant && dockerMeta.network == hnsMeta.network &&
-				dockerMeta.subnetCIDR == hnsMeta.subnetCIDR {
+				sameSubnetCIDRs(d.hnsSubnetCIDRs(dockerMeta), hnsMeta.subnetCIDRs) {
 				matchFound = true
 				break
 			}
@@ -326,14 +494,19 @@ func (d *ContrailDriver) DeleteNetwork(req *network.DeleteNetworkRequest) error
 	if toRemove == nil {
 		return errors.New("During handling of DeleteNetwork, couldn't find net to remove")
 	}
-	return d.hnsMgr.DeleteNetwork(toRemove.tenant, toRemove.network, toRemove.subnetCIDR)
+	return d.hnsMgr.DeleteNetwork(toRemove.tenant, toRemove.network, toRemove.subnetCIDRs)
 }
 
 func (d *ContrailDriver) FreeNetwork(req *network.FreeNetworkRequest) error {
 	log.Debugln("=== FreeNetwork")
 	log.Debugln(req)
-	// This method is used in swarm, in remote plugins. We don't implement it.
-	return errors.New("FreeNetwork is not implemented")
+	// This method is used in swarm, on manager nodes, in global scope only.
+	if d.scope != network.GlobalScope {
+		return errors.New("FreeNetwork is only supported in global scope")
+	}
+	// Contrail network and its subnets are owned by Contrail, and addresses of endpoints are
+	// released in DeleteEndpoint on workers, so there is nothing left to free here.
+	return nil
 }
 
 func (d *ContrailDriver) CreateEndpoint(req *network.CreateEndpointRequest) (
@@ -358,40 +531,79 @@ func (d *ContrailDriver) CreateEndpoint(req *network.CreateEndpointRequest) (
 	}
 	log.Infoln("Retrieved Contrail network:", contrailNetwork.GetUuid())
 
-	// TODO JW-187.
-	// We need to retreive Container ID here and use it instead of EndpointID as
-	// argument to d.controller.GetOrCreateInstance().
-	// EndpointID is equiv to interface, but in Contrail, we have a "VirtualMachine" in
-	// data model.
-	// A single VM can be connected to two or more overlay networks, but when we use
-	// EndpointID, this won't work.
-	// We need something like:
-	// containerID := req.Options["vmname"]
-	containerID := req.EndpointID
-
-	contrailIpam, err := d.controller.GetIpamSubnet(contrailNetwork, meta.subnetCIDR)
+	contrailIpams, err := d.contrailSubnets(contrailNetwork, meta.subnetCIDRs)
 	if err != nil {
 		return nil, err
 	}
-	contrailSubnetCIDR := d.getContrailSubnetCIDR(contrailIpam)
+	contrailIpamsV4, contrailIpamsV6 := subnetsByFamily(contrailIpams)
+	if len(contrailIpamsV4) == 0 {
+		return nil, errors.New("Docker network has no IPv4 subnet")
+	}
+
+	// Addresses and MAC are known to docker if they were requested by user (docker run --ip,
+	// --mac-address) or allocated by Contrail IPAM driver.
+	var reqAddress, reqAddressIPv6, reqMac string
+	if req.Interface != nil {
+		reqAddress = req.Interface.Address
+		reqAddressIPv6 = req.Interface.AddressIPv6
+		reqMac = req.Interface.MacAddress
+	}
 
-	contrailVif, err := d.controller.GetOrCreateInterface(contrailNetwork, meta.tenant,
-		containerID)
+	// Security groups can be specified both for the whole network and for the endpoint
+	// (docker network connect --driver-opt).
+	securityGroupNames := meta.securityGroups
+	if endpointGroups, ok := req.Options[securityGroupsOption].(string); ok {
+		securityGroupNames = append(securityGroupNames, splitList(endpointGroups)...)
+	}
+	securityGroups, err := d.controller.GetSecurityGroups(meta.tenant, securityGroupNames)
 	if err != nil {
 		return nil, err
 	}
 
-	contrailVM, err := d.controller.GetOrCreateInstance(contrailVif, containerID)
+	// If any step fails, objects created by previous steps are removed.
+	var undo rollback
+	defer undo.run()
+
+	// Container ID is not known until Join, so virtual-machine is created there. Here, we
+	// only set up the interface that connects the endpoint to Contrail network.
+	contrailVif, created, err := d.controller.GetOrCreateInterfaceWithParams(contrailNetwork,
+		meta.tenant, controller.InterfaceName(meta.network, req.EndpointID),
+		controller.InterfaceParams{
+			Mac:            reqMac,
+			SecurityGroups: securityGroups,
+		})
 	if err != nil {
 		return nil, err
 	}
+	if created {
+		undo.add("Contrail interface "+contrailVif.GetUuid(), func() error {
+			return d.controller.DeleteInterface(contrailVif)
+		})
+	}
 
-	contrailIP, err := d.controller.GetOrCreateInstanceIp(contrailNetwork, contrailVif, contrailIpam.SubnetUuid)
+	contrailIP, contrailIpam, err := d.instanceIPForEndpoint(&undo, reqAddress,
+		contrailNetwork, contrailVif, contrailIpamsV4)
 	if err != nil {
 		return nil, err
 	}
 	instanceIP := contrailIP.GetInstanceIpAddress()
 	log.Infoln("Retrieved instance IP:", instanceIP)
+	instanceIPUuids := []string{contrailIP.GetUuid()}
+
+	// HNS endpoints of the hcsshim we use have no IPv6 settings, so IPv6 instance IP is
+	// only passed to vRouter Agent. Container itself gets IPv4 address only.
+	var instanceIPv6 string
+	if len(contrailIpamsV6) > 0 {
+		var contrailIPv6 *types.InstanceIp
+		contrailIPv6, _, err = d.instanceIPForEndpoint(&undo, reqAddressIPv6,
+			contrailNetwork, contrailVif, contrailIpamsV6)
+		if err != nil {
+			return nil, err
+		}
+		instanceIPv6 = contrailIPv6.GetInstanceIpAddress()
+		log.Infoln("Retrieved instance IPv6:", instanceIPv6)
+		instanceIPUuids = append(instanceIPUuids, contrailIPv6.GetUuid())
+	}
 
 	contrailGateway := contrailIpam.DefaultGateway
 	log.Infoln("Retrieved GW address:", contrailGateway)
@@ -408,50 +620,220 @@ func (d *ContrailDriver) CreateEndpoint(req *network.CreateEndpointRequest) (
 	// HNS needs MACs like 11-22-AA-BB-CC-DD
 	formattedMac := strings.Replace(strings.ToUpper(contrailMac), ":", "-", -1)
 
-	hnsNet, err := d.hnsMgr.GetNetwork(meta.tenant, meta.network, contrailSubnetCIDR)
+	hnsNet, err := d.hnsMgr.GetNetwork(meta.tenant, meta.network,
+		contrailSubnetCIDRs(contrailIpams))
 	if err != nil {
 		return nil, err
 	}
 
-	hnsEndpointConfig := &hcsshim.HNSEndpoint{
+	hnsEndpointConfig := &hns.HNSEndpoint{
 		VirtualNetworkName: hnsNet.Name,
 		Name:               req.EndpointID,
 		IPAddress:          net.ParseIP(instanceIP),
 		MacAddress:         formattedMac,
 		GatewayAddress:     contrailGateway,
 	}
+	dnsServers, dnsSuffix := d.controller.GetDnsConfig(contrailIpam)
+	hnsEndpointConfig.DNSServerList = strings.Join(dnsServers, ",")
+	hnsEndpointConfig.DNSSuffix = dnsSuffix
+	log.Infoln("Retrieved DNS config:", hnsEndpointConfig.DNSServerList, dnsSuffix)
 
-	hnsEndpointID, err := hns.CreateHNSEndpoint(hnsEndpointConfig)
+	hnsEndpointID, err := d.hns.CreateHNSEndpoint(hnsEndpointConfig)
 	if err != nil {
 		return nil, err
 	}
+	undo.add("HNS endpoint "+hnsEndpointID, func() error {
+		return d.hns.DeleteHNSEndpoint(hnsEndpointID)
+	})
 
-	// TODO: test this when Agent is ready
-	ifName := d.generateFriendlyName(hnsEndpointID)
-
-	go agent.AddPort(contrailVM.GetUuid(), contrailVif.GetUuid(), ifName, contrailMac, containerID,
-		contrailIP.GetInstanceIpAddress(), contrailNetwork.GetUuid())
+	storedEp := state.Endpoint{
+		ID:              req.EndpointID,
+		NetworkID:       req.NetworkID,
+		VMIUuid:         contrailVif.GetUuid(),
+		InstanceIPUuids: instanceIPUuids,
+		HNSEndpointID:   hnsEndpointID,
+		IPv6Address:     instanceIPv6,
+	}
+	if err := d.store.SaveEndpoint(storedEp); err != nil {
+		return nil, err
+	}
 
-	epAddressCIDR := fmt.Sprintf("%s/%v", instanceIP, contrailIpam.Subnet.IpPrefixLen)
+	respIface := &network.EndpointInterface{}
+	// docker refuses responses that modify an address it already knows about.
+	if reqMac == "" {
+		respIface.MacAddress = contrailMac
+	}
+	if reqAddress == "" {
+		respIface.Address = fmt.Sprintf("%s/%v", instanceIP, contrailIpam.Subnet.IpPrefixLen)
+	}
 	r := &network.CreateEndpointResponse{
-		Interface: &network.EndpointInterface{
-			Address:    epAddressCIDR,
-			MacAddress: contrailMac,
-		},
+		Interface: respIface,
 	}
+
+	undo.commit()
 	return r, nil
 }
 
+// addInstanceIPRollback registers removal of instance IP of endpoint. Instance IPs allocated by
+// Contrail IPAM driver are removed too, like in DeleteEndpoint; ReleaseAddress handles that.
+func (d *ContrailDriver) addInstanceIPRollback(undo *rollback, contrailIP *types.InstanceIp) {
+	undo.add(fmt.Sprintf("Contrail instance IP %s (%s)", contrailIP.GetUuid(),
+		contrailIP.GetInstanceIpAddress()), func() error {
+		return d.controller.DeleteElementRecursive(contrailIP)
+	})
+}
+
+// instanceIPForEndpoint returns instance IP of endpoint's interface and the subnet it belongs
+// to. Subnets must be of the same IP family. If docker already knows endpoint's address, it is
+// either bound to the interface (if it was allocated by Contrail IPAM driver) or reserved as
+// a fixed instance IP (if it was requested by user). Otherwise, a new instance IP is allocated
+// in the first subnet that has free addresses. Only instance IPs created or bound here are
+// rolled back; the ones allocated by Contrail IPAM driver are released by docker.
+func (d *ContrailDriver) instanceIPForEndpoint(undo *rollback, reqAddress string,
+	contrailNetwork *types.VirtualNetwork, contrailVif *types.VirtualMachineInterface,
+	contrailIpams []*types.IpamSubnetType) (*types.InstanceIp, *types.IpamSubnetType, error) {
+
+	if reqAddress == "" {
+		return d.allocateInstanceIPForEndpoint(undo, contrailNetwork, contrailVif,
+			contrailIpams)
+	}
+
+	address, _, err := net.ParseCIDR(reqAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	contrailIpam := subnetContaining(contrailIpams, address)
+	if contrailIpam == nil {
+		return nil, nil, fmt.Errorf("Address %s is outside of subnets of Contrail network %s",
+			address, contrailNetwork.GetName())
+	}
+
+	contrailIP, err := d.controller.GetInstanceIpByAddress(contrailNetwork, address.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	if contrailIP == nil {
+		var created bool
+		contrailIP, created, err = d.controller.GetOrCreateFixedInstanceIp(contrailNetwork,
+			contrailVif, contrailIpam, address.String())
+		if err != nil {
+			return nil, nil, err
+		}
+		if created {
+			d.addInstanceIPRollback(undo, contrailIP)
+		}
+		return contrailIP, contrailIpam, nil
+	}
+
+	vifRefs, err := contrailIP.GetVirtualMachineInterfaceRefs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, ref := range vifRefs {
+		if ref.Uuid == contrailVif.GetUuid() {
+			return contrailIP, contrailIpam, nil
+		}
+	}
+	if len(vifRefs) > 0 {
+		return nil, nil, fmt.Errorf("Address %s is already in use", address)
+	}
+
+	// address was allocated by Contrail IPAM driver and is not used yet
+	if err := d.controller.AssignInstanceIp(contrailIP, contrailVif); err != nil {
+		return nil, nil, err
+	}
+	undo.add(fmt.Sprintf("binding of Contrail instance IP %s (%s) to interface %s",
+		contrailIP.GetUuid(), contrailIP.GetInstanceIpAddress(), contrailVif.GetUuid()),
+		func() error {
+			return d.controller.UnassignInstanceIp(contrailIP, contrailVif)
+		})
+	return contrailIP, contrailIpam, nil
+}
+
+func (d *ContrailDriver) allocateInstanceIPForEndpoint(undo *rollback,
+	contrailNetwork *types.VirtualNetwork, contrailVif *types.VirtualMachineInterface,
+	contrailIpams []*types.IpamSubnetType) (*types.InstanceIp, *types.IpamSubnetType, error) {
+
+	getOrCreate := d.controller.GetOrCreateInstanceIp
+	if controller.SubnetFamily(contrailIpams[0]) == controller.IPv6Family {
+		getOrCreate = d.controller.GetOrCreateInstanceIpv6
+	}
+
+	var err error
+	for _, contrailIpam := range contrailIpams {
+		var contrailIP *types.InstanceIp
+		var created bool
+		contrailIP, created, err = getOrCreate(contrailNetwork, contrailVif,
+			contrailIpam.SubnetUuid)
+		if err != nil {
+			log.Warnf("Failed to allocate address in subnet %s, trying next one: %v",
+				contrailSubnetCIDR(contrailIpam), err)
+			continue
+		}
+		if created {
+			d.addInstanceIPRollback(undo, contrailIP)
+		}
+		// instance IP could have already existed, so find out where it really belongs
+		address := net.ParseIP(contrailIP.GetInstanceIpAddress())
+		if ipam := subnetContaining(contrailIpams, address); ipam != nil {
+			return contrailIP, ipam, nil
+		}
+		return nil, nil, fmt.Errorf("Instance IP %s doesn't belong to any subnet of the network",
+			address)
+	}
+	log.Errorf("Failed to allocate address in any of %v subnets", len(contrailIpams))
+	return nil, nil, err
+}
+
 func (d *ContrailDriver) DeleteEndpoint(req *network.DeleteEndpointRequest) error {
 	log.Debugln("=== DeleteEndpoint")
 	log.Debugln(req)
 
-	// TODO JW-187.
-	// We need something like:
-	// containerID := req.Options["vmname"]
-	containerID := req.EndpointID
+	// Endpoint is gone from docker either way, so HNS endpoint is removed even if its Contrail
+	// interface can't be. Then the stored endpoint is kept as a tombstone, so that the interface
+	// is removed later, by CompleteDegradedJoins or garbage collection.
+	storedEp := d.store.GetEndpoint(req.EndpointID)
+	var contrailErr error
+	if storedEp != nil && storedEp.VMIUuid != "" {
+		contrailErr = d.removeInterface(storedEp.VMIUuid)
+	} else if d.controller.IsConnected() {
+		contrailErr = d.deleteContrailInterface(req.NetworkID, req.EndpointID)
+	} else {
+		log.Warnf("When handling DeleteEndpoint, Contrail controller isn't connected, so "+
+			"Contrail interface of endpoint %s is left behind", req.EndpointID)
+	}
 
-	meta, err := d.networkMetaFromDockerNetwork(req.NetworkID)
+	hnsEpID, err := d.hnsEndpointID(req.EndpointID)
+	if err != nil {
+		return err
+	}
+	if hnsEpID == "" {
+		log.Warn("When handling DeleteEndpoint, couldn't find HNS endpoint to delete")
+	} else if err := d.hns.DeleteHNSEndpoint(hnsEpID); err != nil {
+		// Stored endpoint could have been removed from HNS behind driver's back.
+		hnsEp, getErr := d.hns.GetHNSEndpointByName(req.EndpointID)
+		if getErr != nil || hnsEp != nil {
+			return err
+		}
+		log.Warn("When handling DeleteEndpoint, HNS endpoint was already removed")
+	}
+
+	if contrailErr == nil {
+		return d.store.RemoveEndpoint(req.EndpointID)
+	}
+	if storedEp == nil || storedEp.VMIUuid == "" {
+		return contrailErr
+	}
+	log.Warnf("When handling DeleteEndpoint, Contrail interface %s of endpoint %s couldn't be "+
+		"removed, it will be removed later: %v", storedEp.VMIUuid, req.EndpointID, contrailErr)
+	return d.store.DeleteEndpoint(req.EndpointID)
+}
+
+// deleteContrailInterface removes endpoint's Contrail interface and its vRouter Agent port. It's
+// used for endpoints created before the driver started to record them in its store, whose
+// interfaces are looked up by name. Interface that doesn't exist is skipped.
+func (d *ContrailDriver) deleteContrailInterface(networkID, endpointID string) error {
+	meta, err := d.networkMetaFromDockerNetwork(networkID)
 	if err != nil {
 		return err
 	}
@@ -463,34 +845,56 @@ func (d *ContrailDriver) DeleteEndpoint(req *network.DeleteEndpointRequest) erro
 	log.Infoln("Retrieved Contrail network:", contrailNetwork.GetUuid())
 
 	contrailVif, err := d.controller.GetExistingInterface(contrailNetwork, meta.tenant,
-		containerID)
+		controller.InterfaceName(meta.network, endpointID))
 	if err != nil {
 		log.Warn("When handling DeleteEndpoint, interface wasn't found")
-	} else {
-		go agent.DeletePort(contrailVif.GetUuid())
+		return nil
 	}
+	d.removeAgentPort(contrailVif.GetUuid())
 
-	contrailInstance, err := types.VirtualMachineByName(d.controller.ApiClient, containerID)
-	if err != nil {
-		log.Warn("When handling DeleteEndpoint, Contrail vm instance wasn't found")
-	} else {
-		err = d.controller.DeleteElementRecursive(contrailInstance)
-		if err != nil {
-			log.Warn("When handling DeleteEndpoint, failed to remove Contrail vm instance")
-		}
-	}
+	// virtual-machine is removed together with its last interface
+	return d.controller.DeleteInterface(contrailVif)
+}
 
-	hnsEpName := req.EndpointID
-	epToDelete, err := hns.GetHNSEndpointByName(hnsEpName)
+// removeInterface removes Contrail interface with given UUID and its vRouter Agent port.
+// Interface that doesn't exist anymore is skipped.
+func (d *ContrailDriver) removeInterface(vifUuid string) error {
+	d.removeAgentPort(vifUuid)
+	contrailVif, err := d.controller.GetInterfaceByUuid(vifUuid)
+	if controller.IsNotFound(err) {
+		log.Infoln("Contrail interface", vifUuid, "was already removed")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	if epToDelete == nil {
-		log.Warn("When handling DeleteEndpoint, couldn't find HNS endpoint to delete")
-		return nil
+	// virtual-machine is removed together with its last interface
+	return d.controller.DeleteInterface(contrailVif)
+}
+
+// completeDeletion removes Contrail interface of endpoint deleted while it couldn't be removed,
+// and then endpoint's tombstone.
+func (d *ContrailDriver) completeDeletion(deletedEp state.Endpoint) error {
+	if err := d.removeInterface(deletedEp.VMIUuid); err != nil {
+		return err
 	}
+	return d.store.RemoveEndpoint(deletedEp.ID)
+}
 
-	return hns.DeleteHNSEndpoint(epToDelete.Id)
+// hnsEndpointID returns ID of HNS endpoint of docker endpoint, or empty string if there is none.
+// Endpoints created before the driver started to record them in its store are looked up by name.
+func (d *ContrailDriver) hnsEndpointID(endpointID string) (string, error) {
+	if storedEp := d.store.GetEndpoint(endpointID); storedEp != nil {
+		return storedEp.HNSEndpointID, nil
+	}
+	hnsEp, err := d.hns.GetHNSEndpointByName(endpointID)
+	if err != nil {
+		return "", err
+	}
+	if hnsEp == nil {
+		return "", nil
+	}
+	return hnsEp.Id, nil
 }
 
 func (d *ContrailDriver) EndpointInfo(req *network.InfoRequest) (*network.InfoResponse, error) {
@@ -498,7 +902,7 @@ func (d *ContrailDriver) EndpointInfo(req *network.InfoRequest) (*network.InfoRe
 	log.Debugln(req)
 
 	hnsEpName := req.EndpointID
-	hnsEp, err := hns.GetHNSEndpointByName(hnsEpName)
+	hnsEp, err := d.hns.GetHNSEndpointByName(hnsEpName)
 	if err != nil {
 		return nil, err
 	}
@@ -510,6 +914,14 @@ func (d *ContrailDriver) EndpointInfo(req *network.InfoRequest) (*network.InfoRe
 		"hnsid":             hnsEp.Id,
 		netlabel.MacAddress: hnsEp.MacAddress,
 	}
+	if storedEp := d.store.GetEndpoint(req.EndpointID); storedEp != nil {
+		if status, exists := d.agentQueue.Status(storedEp.VMIUuid); exists {
+			respData["vrouter_port"] = string(status.State)
+			if status.LastError != "" {
+				respData["vrouter_port_error"] = status.LastError
+			}
+		}
+	}
 
 	r := &network.InfoResponse{
 		Value: respData,
@@ -525,7 +937,7 @@ func (d *ContrailDriver) Join(req *network.JoinRequest) (*network.JoinResponse,
 		fmt.Printf("%v: %v\n", k, v)
 	}
 
-	hnsEp, err := hns.GetHNSEndpointByName(req.EndpointID)
+	hnsEp, err := d.hns.GetHNSEndpointByName(req.EndpointID)
 	if err != nil {
 		return nil, err
 	}
@@ -533,19 +945,196 @@ func (d *ContrailDriver) Join(req *network.JoinRequest) (*network.JoinResponse,
 		return nil, errors.New("Such HNS endpoint doesn't exist")
 	}
 
+	// On Windows, libnetwork uses container ID as sandbox key.
+	containerID := req.SandboxKey
+	if containerID == "" {
+		return nil, errors.New("Sandbox key (container ID) is empty")
+	}
+
+	if !d.controller.IsConnected() {
+		return d.joinWithoutContrail(req.EndpointID, containerID, hnsEp)
+	}
+
+	meta, err := d.networkMetaFromDockerNetwork(req.NetworkID)
+	if err != nil {
+		return nil, err
+	}
+
+	staticRoutes, err := d.joinContrail(meta, req.EndpointID, containerID, hnsEp)
+	if err != nil {
+		return nil, err
+	}
+
 	r := &network.JoinResponse{
 		DisableGatewayService: true,
 		Gateway:               hnsEp.GatewayAddress,
+		StaticRoutes:          staticRoutes,
 	}
+	return r, nil
+}
 
+// joinContrail attaches endpoint's Contrail interface to instance of container, and adds it to
+// vRouter Agent. It returns static routes of endpoint's subnets.
+func (d *ContrailDriver) joinContrail(meta *NetworkMeta, endpointID, containerID string,
+	hnsEp *hns.HNSEndpoint) ([]*network.StaticRoute, error) {
+	contrailNetwork, err := d.controller.GetNetwork(meta.tenant, meta.network)
+	if err != nil {
+		return nil, err
+	}
+
+	contrailVif, err := d.controller.GetExistingInterface(contrailNetwork, meta.tenant,
+		controller.InterfaceName(meta.network, endpointID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Container labels are not passed to the driver, so they have to be asked for.
+	labelGroupNames, err := d.containerSecurityGroups(containerID)
+	if err != nil {
+		return nil, err
+	}
+	labelGroups, err := d.controller.GetSecurityGroups(meta.tenant, labelGroupNames)
+	if err != nil {
+		return nil, err
+	}
+	// If any step fails, security groups added here are removed, and endpoint's interface is
+	// detached from the instance again.
+	var undo rollback
+	defer undo.run()
+
+	addedGroups, err := d.controller.AddSecurityGroups(contrailVif, labelGroups)
+	if err != nil {
+		return nil, err
+	}
+	if len(addedGroups) > 0 {
+		undo.add(fmt.Sprintf("security groups of container %s on interface %s", containerID,
+			contrailVif.GetUuid()), func() error {
+			return d.controller.RemoveSecurityGroups(contrailVif, addedGroups)
+		})
+	}
+
+	contrailVM, attached, err := d.controller.GetOrCreateInstance(contrailVif, containerID)
+	if err != nil {
+		return nil, err
+	}
+	log.Infoln("Endpoint", endpointID, "joined Contrail instance", contrailVM.GetUuid())
+	if attached {
+		undo.add(fmt.Sprintf("Contrail instance %s of interface %s", contrailVM.GetUuid(),
+			contrailVif.GetUuid()), func() error {
+			return d.controller.DetachInstance(contrailVif, contrailVM)
+		})
+	}
+
+	if storedEp := d.store.GetEndpoint(endpointID); storedEp != nil {
+		storedEp.VMUuid = contrailVM.GetUuid()
+		storedEp.ContainerID = containerID
+		if err := d.store.SaveEndpoint(*storedEp); err != nil {
+			return nil, err
+		}
+	}
+
+	contrailMac, err := d.controller.GetInterfaceMac(contrailVif)
+	if err != nil {
+		return nil, err
+	}
+
+	contrailIpams, err := d.contrailSubnets(contrailNetwork, meta.subnetCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	var staticRoutes []*network.StaticRoute
+	if contrailIpam := subnetContaining(contrailIpams, hnsEp.IPAddress); contrailIpam != nil {
+		staticRoutes = joinStaticRoutes(d.controller.GetHostRoutes(contrailIpam))
+	}
+
+	// Adding the port to vRouter agent is the last step, so that nothing has to be undone in
+	// the agent if Join fails.
+	// TODO: test this when Agent is ready
+	port := d.agentPort(contrailNetwork, contrailVif, contrailMac, contrailVM.GetUuid(),
+		containerID, hnsEp)
+	if d.AgentPortFiles != nil {
+		if err := d.AgentPortFiles.Write(port); err != nil {
+			return nil, err
+		}
+		undo.add(fmt.Sprintf("vRouter Agent port file %s", port.VifUuid), func() error {
+			return d.AgentPortFiles.Remove(port.VifUuid)
+		})
+	}
+	portResult := d.agentQueue.AddPort(port)
+	if d.RequireAgentPort {
+		if err := <-portResult; err != nil {
+			return nil, err
+		}
+	}
+
+	undo.commit()
+	return staticRoutes, nil
+}
+
+// joinWithoutContrail lets container join endpoint while Contrail controller is unavailable,
+// from HNS state alone. Container is recorded in state file, so that Contrail part of the join
+// is completed by CompleteDegradedJoins when the controller is connected. Static routes of
+// Contrail subnets can't be passed to docker later, though.
+func (d *ContrailDriver) joinWithoutContrail(endpointID, containerID string,
+	hnsEp *hns.HNSEndpoint) (*network.JoinResponse, error) {
+	storedEp := d.store.GetEndpoint(endpointID)
+	if storedEp == nil {
+		log.Warnf("Endpoint %s joins container %s without Contrail, and can't be completed "+
+			"later, because it's not recorded in state file", endpointID, containerID)
+	} else {
+		log.Warnf("Endpoint %s joins container %s without Contrail, until Contrail "+
+			"controller is connected", endpointID, containerID)
+		storedEp.VMUuid = ""
+		storedEp.ContainerID = containerID
+		if err := d.store.SaveEndpoint(*storedEp); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &network.JoinResponse{
+		DisableGatewayService: true,
+		Gateway:               hnsEp.GatewayAddress,
+	}
 	return r, nil
 }
 
+// agentPort describes container's interface for vRouter Agent.
+func (d *ContrailDriver) agentPort(contrailNetwork *types.VirtualNetwork,
+	contrailVif *types.VirtualMachineInterface, contrailMac, vmUuid, containerID string,
+	hnsEp *hns.HNSEndpoint) agent.Port {
+	port := agent.Port{
+		VmUuid:        vmUuid,
+		VifUuid:       contrailVif.GetUuid(),
+		VnUuid:        contrailNetwork.GetUuid(),
+		VmProjectUuid: contrailNetwork.GetParentUuid(),
+		IfName:        d.generateFriendlyName(hnsEp.Id),
+		Mac:           contrailMac,
+		IPAddress:     hnsEp.IPAddress.String(),
+		DisplayName:   containerID,
+		Type:          agent.NovaVMPort,
+	}
+	if storedEp := d.store.GetEndpoint(hnsEp.Name); storedEp != nil {
+		port.IPv6Address = storedEp.IPv6Address
+	}
+	return port
+}
+
+// removeAgentPort removes the port from vRouter Agent. Port file is removed first, so that agent
+// doesn't add the port again if it restarts in the meantime.
+func (d *ContrailDriver) removeAgentPort(vifUuid string) <-chan error {
+	if d.AgentPortFiles != nil {
+		if err := d.AgentPortFiles.Remove(vifUuid); err != nil {
+			log.Warnf("Failed to remove vRouter Agent port file %s: %v", vifUuid, err)
+		}
+	}
+	return d.agentQueue.DeletePort(vifUuid)
+}
+
 func (d *ContrailDriver) Leave(req *network.LeaveRequest) error {
 	log.Debugln("=== Leave")
 	log.Debugln(req)
 
-	hnsEp, err := hns.GetHNSEndpointByName(req.EndpointID)
+	hnsEp, err := d.hns.GetHNSEndpointByName(req.EndpointID)
 	if err != nil {
 		return err
 	}
@@ -553,6 +1142,15 @@ func (d *ContrailDriver) Leave(req *network.LeaveRequest) error {
 		return errors.New("Such HNS endpoint doesn't exist")
 	}
 
+	// Container that joined without Contrail is gone, so there's nothing to complete.
+	if storedEp := d.store.GetEndpoint(req.EndpointID); storedEp != nil &&
+		storedEp.VMUuid == "" && storedEp.ContainerID != "" {
+		storedEp.ContainerID = ""
+		if err := d.store.SaveEndpoint(*storedEp); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -574,38 +1172,143 @@ func (d *ContrailDriver) ProgramExternalConnectivity(
 	req *network.ProgramExternalConnectivityRequest) error {
 	log.Debugln("=== ProgramExternalConnectivity")
 	log.Debugln(req)
-	return nil
+
+	meta, err := d.networkMetaFromDockerNetwork(req.NetworkID)
+	if err != nil {
+		return err
+	}
+
+	portMappings, err := portMappingsFromOptions(req.Options)
+	if err != nil {
+		return err
+	}
+
+	if meta.floatingIPPool == "" {
+		if len(portMappings) > 0 {
+			log.Warnf("Ports can't be published, because network has no %s option",
+				floatingIPPoolOption)
+		}
+		return nil
+	}
+	if len(portMappings) == 0 {
+		// nothing is published, so the endpoint doesn't need a floating IP
+		return nil
+	}
+
+	contrailNetwork, err := d.controller.GetNetwork(meta.tenant, meta.network)
+	if err != nil {
+		return err
+	}
+
+	contrailVif, err := d.controller.GetExistingInterface(contrailNetwork, meta.tenant,
+		controller.InterfaceName(meta.network, req.EndpointID))
+	if err != nil {
+		return err
+	}
+
+	_, err = d.controller.GetOrCreateFloatingIp(meta.floatingIPPool, meta.tenant, contrailVif,
+		portMappings)
+	return err
 }
 
 func (d *ContrailDriver) RevokeExternalConnectivity(
 	req *network.RevokeExternalConnectivityRequest) error {
 	log.Debugln("=== RevokeExternalConnectivity")
 	log.Debugln(req)
-	return nil
+
+	meta, err := d.networkMetaFromDockerNetwork(req.NetworkID)
+	if err != nil {
+		return err
+	}
+	if meta.floatingIPPool == "" {
+		return nil
+	}
+
+	contrailNetwork, err := d.controller.GetNetwork(meta.tenant, meta.network)
+	if err != nil {
+		return err
+	}
+
+	contrailVif, err := d.controller.GetExistingInterface(contrailNetwork, meta.tenant,
+		controller.InterfaceName(meta.network, req.EndpointID))
+	if err != nil {
+		log.Warn("When handling RevokeExternalConnectivity, interface wasn't found")
+		return nil
+	}
+
+	return d.controller.DeleteFloatingIp(meta.floatingIPPool, contrailVif)
+}
+
+// portMappingsFromOptions translates docker port bindings (docker run -p) into port mappings of
+// Contrail floating IP. If host port is not specified, container port is used.
+func portMappingsFromOptions(options map[string]interface{}) ([]types.PortMap, error) {
+	rawBindings, exists := options[netlabel.PortMap]
+	if !exists || rawBindings == nil {
+		return nil, nil
+	}
+
+	// port bindings arrive as generic JSON, so decode them once more into a typed struct
+	rawJSON, err := json.Marshal(rawBindings)
+	if err != nil {
+		return nil, err
+	}
+	var bindings []portBinding
+	if err := json.Unmarshal(rawJSON, &bindings); err != nil {
+		log.Errorf("Malformed port bindings: %v", err)
+		return nil, err
+	}
+
+	var portMappings []types.PortMap
+	for _, b := range bindings {
+		var protocol string
+		switch b.Proto {
+		case protocolTCP:
+			protocol = "tcp"
+		case protocolUDP:
+			protocol = "udp"
+		default:
+			return nil, fmt.Errorf("Unsupported protocol of port binding: %v", b.Proto)
+		}
+
+		hostPort := b.HostPort
+		if hostPort == 0 {
+			hostPort = b.Port
+		}
+		if b.HostPortEnd != 0 && b.HostPortEnd != hostPort {
+			log.Warnf("Port ranges are not supported, publishing port %v only", hostPort)
+		}
+
+		portMappings = append(portMappings, types.PortMap{
+			Protocol: protocol,
+			SrcPort:  b.Port,
+			DstPort:  hostPort,
+		})
+	}
+	return portMappings, nil
 }
 
 func (d *ContrailDriver) createRootNetwork() error {
 	// HNS automatically creates a new vswitch if the first HNS network is created. We want to
 	// control this behaviour. That's why we create a dummy root HNS network.
 
-	rootNetwork, err := hns.GetHNSNetworkByName(common.RootNetworkName)
+	rootNetwork, err := d.hns.GetHNSNetworkByName(common.RootNetworkName)
 	if err != nil {
 		return err
 	}
 	if rootNetwork == nil {
 
-		subnets := []hcsshim.Subnet{
+		subnets := []hns.Subnet{
 			{
 				AddressPrefix: "0.0.0.0/24",
 			},
 		}
-		configuration := &hcsshim.HNSNetwork{
+		configuration := &hns.HNSNetwork{
 			Name:               common.RootNetworkName,
 			Type:               "transparent",
 			NetworkAdapterName: string(d.networkAdapter),
 			Subnets:            subnets,
 		}
-		rootNetID, err := hns.CreateHNSNetwork(configuration)
+		rootNetID, err := d.hns.CreateHNSNetwork(configuration)
 		if err != nil {
 			return err
 		}
@@ -617,65 +1320,18 @@ func (d *ContrailDriver) createRootNetwork() error {
 	return nil
 }
 
-func (d *ContrailDriver) waitForPipeToStart() error {
-	return d.waitForPipe(true)
-}
-
-func (d *ContrailDriver) waitForPipeToStop() error {
-	return d.waitForPipe(false)
-}
-
-func (d *ContrailDriver) waitForPipe(waitUntilExists bool) error {
-	timeStarted := time.Now()
-	for {
-		if time.Since(timeStarted) > time.Millisecond*common.PipePollingTimeout {
-			return errors.New("Waited for pipe file for too long.")
-		}
-
-		_, err := os.Stat(d.PipeAddr)
-
-		// if waitUntilExists is true, we wait for the file to appear in filesystem.
-		// else, we wait for the file to disappear from the filesystem.
-		if fileExists := !os.IsNotExist(err); fileExists == waitUntilExists {
-			break
-		} else {
-			log.Errorf("Waiting for pipe file, but: %s", err)
-		}
-
-		time.Sleep(time.Millisecond * common.PipePollingRate)
-	}
-
-	time.Sleep(time.Second * 1)
-
-	if waitUntilExists {
-		return d.waitUntilPipeDialable()
-	}
-
-	return nil
-}
-
-func (d *ContrailDriver) waitUntilPipeDialable() error {
-	timeStarted := time.Now()
-	for {
-		if time.Since(timeStarted) > time.Millisecond*common.PipePollingTimeout {
-			return errors.New("Waited for pipe to be dialable for too long.")
-		}
-
-		timeout := time.Millisecond * 10
-		conn, err := sockets.DialPipe(d.PipeAddr, timeout)
-		if err == nil {
-			conn.Close()
-			return nil
-		}
-
-		log.Errorf("Waiting until dialable, but: %s", err)
-
-		time.Sleep(time.Millisecond * common.PipePollingRate)
-	}
-}
-
 func (d *ContrailDriver) networkMetaFromDockerNetwork(dockerNetID string) (*NetworkMeta,
 	error) {
+	if storedNet := d.store.GetNetwork(dockerNetID); storedNet != nil {
+		return &NetworkMeta{
+			tenant:         storedNet.Tenant,
+			network:        storedNet.Network,
+			subnetCIDRs:    storedNet.SubnetCIDRs,
+			floatingIPPool: storedNet.FloatingIPPool,
+			securityGroups: storedNet.SecurityGroups,
+		}, nil
+	}
+
 	docker, err := dockerClient.NewEnvClient()
 	if err != nil {
 		return nil, err
@@ -707,11 +1363,54 @@ func (d *ContrailDriver) networkMetaFromDockerNetwork(dockerNetID string) (*Netw
 	if len(ipamCfg) == 0 {
 		return nil, errors.New("No configured subnets in docker network")
 	}
-	meta.subnetCIDR = ipamCfg[0].Subnet
+	for _, cfg := range ipamCfg {
+		meta.subnetCIDRs = append(meta.subnetCIDRs, cfg.Subnet)
+	}
+
+	meta.floatingIPPool = dockerNetwork.Options[floatingIPPoolOption]
+	meta.securityGroups = splitList(dockerNetwork.Options[securityGroupsOption])
 
 	return &meta, nil
 }
 
+// containerSecurityGroups returns security groups listed in container's labels.
+func (d *ContrailDriver) containerSecurityGroups(containerID string) ([]string, error) {
+	docker, err := dockerClient.NewEnvClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// Container is being started, so it can't be inspected (inspect would wait for the start to
+	// finish). Listing containers doesn't have this problem.
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("id", containerID)
+	containers, err := docker.ContainerList(context.Background(), dockerTypes.ContainerListOptions{
+		All:     true,
+		Filters: filterArgs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		if c.ID == containerID {
+			return splitList(c.Labels[securityGroupsLabel]), nil
+		}
+	}
+	log.Warnln("Container", containerID, "not found, ignoring its labels")
+	return nil, nil
+}
+
+// splitList splits comma separated list, skipping empty elements.
+func splitList(list string) []string {
+	var elems []string
+	for _, elem := range strings.Split(list, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
 func (d *ContrailDriver) dockerNetworksMeta() ([]NetworkMeta, error) {
 	var meta []NetworkMeta
 
@@ -729,10 +1428,14 @@ func (d *ContrailDriver) dockerNetworksMeta() ([]NetworkMeta, error) {
 		tenantContrail, tenantExists := net.Options["tenant"]
 		networkContrail, networkExists := net.Options["network"]
 		if tenantExists && networkExists {
+			var subnetCIDRs []string
+			for _, cfg := range net.IPAM.Config {
+				subnetCIDRs = append(subnetCIDRs, cfg.Subnet)
+			}
 			meta = append(meta, NetworkMeta{
-				tenant:     tenantContrail,
-				network:    networkContrail,
-				subnetCIDR: net.IPAM.Config[0].Subnet,
+				tenant:      tenantContrail,
+				network:     networkContrail,
+				subnetCIDRs: subnetCIDRs,
 			})
 		}
 	}
@@ -747,15 +1450,18 @@ func (d *ContrailDriver) hnsNetworksMeta() ([]NetworkMeta, error) {
 
 	var meta []NetworkMeta
 	for _, net := range hnsNetworks {
-		splitName := strings.Split(net.Name, ":")
 		// hnsManager.ListNetworks() already sanitizes network name
+		splitName := strings.SplitN(net.Name, ":", 4)
 		tenantName := splitName[1]
 		networkName := splitName[2]
-		subnetCIDR := splitName[3]
+		subnetCIDRs, err := hnsManager.SubnetCIDRsFromNetName(net.Name)
+		if err != nil {
+			return nil, err
+		}
 		meta = append(meta, NetworkMeta{
-			tenant:     tenantName,
-			network:    networkName,
-			subnetCIDR: subnetCIDR,
+			tenant:      tenantName,
+			network:     networkName,
+			subnetCIDRs: subnetCIDRs,
 		})
 	}
 	return meta, nil
@@ -777,6 +1483,110 @@ func (d *ContrailDriver) generateFriendlyName(hnsEndpointID string) string {
 	return fmt.Sprintf("Container NIC %s", containerNicID)
 }
 
-func (d *ContrailDriver) getContrailSubnetCIDR(ipam *types.IpamSubnetType) string {
+func contrailSubnetCIDR(ipam *types.IpamSubnetType) string {
 	return fmt.Sprintf("%s/%v", ipam.Subnet.IpPrefix, ipam.Subnet.IpPrefixLen)
 }
+
+// joinStaticRoutes translates Contrail host routes into routes that docker sets up in container.
+// Routes without next hop are treated as directly connected.
+func joinStaticRoutes(routes []types.RouteType) []*network.StaticRoute {
+	var staticRoutes []*network.StaticRoute
+	for _, route := range routes {
+		staticRoute := &network.StaticRoute{
+			Destination: route.Prefix,
+			RouteType:   routeTypeConnected,
+		}
+		if route.NextHop != "" {
+			staticRoute.RouteType = routeTypeNextHop
+			staticRoute.NextHop = route.NextHop
+		}
+		staticRoutes = append(staticRoutes, staticRoute)
+	}
+	return staticRoutes
+}
+
+func contrailSubnetCIDRs(ipams []*types.IpamSubnetType) []string {
+	var cidrs []string
+	for _, ipam := range ipams {
+		cidrs = append(cidrs, contrailSubnetCIDR(ipam))
+	}
+	return cidrs
+}
+
+// contrailSubnets returns Contrail IPAM subnets that back specified docker subnets.
+func (d *ContrailDriver) contrailSubnets(contrailNetwork *types.VirtualNetwork,
+	subnetCIDRs []string) ([]*types.IpamSubnetType, error) {
+
+	var ipams []*types.IpamSubnetType
+	for _, cidr := range subnetCIDRs {
+		ipam, err := d.controller.GetIpamSubnet(contrailNetwork, cidr)
+		if err != nil {
+			return nil, err
+		}
+		ipams = append(ipams, ipam)
+	}
+	return ipams, nil
+}
+
+// hnsSubnetCIDRs returns CIDRs of subnets that HNS network of specified docker network was
+// created with. If Contrail can't be asked, CIDRs known to docker are returned.
+func (d *ContrailDriver) hnsSubnetCIDRs(meta NetworkMeta) []string {
+	contrailNetwork, err := d.controller.GetNetwork(meta.tenant, meta.network)
+	if err != nil {
+		return meta.subnetCIDRs
+	}
+	contrailIpams, err := d.contrailSubnets(contrailNetwork, meta.subnetCIDRs)
+	if err != nil {
+		return meta.subnetCIDRs
+	}
+	return contrailSubnetCIDRs(contrailIpams)
+}
+
+// subnetsByFamily splits subnets into IPv4 and IPv6 ones, preserving their order.
+func subnetsByFamily(ipams []*types.IpamSubnetType) (v4, v6 []*types.IpamSubnetType) {
+	for _, ipam := range ipams {
+		if controller.SubnetFamily(ipam) == controller.IPv6Family {
+			v6 = append(v6, ipam)
+		} else {
+			v4 = append(v4, ipam)
+		}
+	}
+	return v4, v6
+}
+
+func subnetContaining(ipams []*types.IpamSubnetType, ip net.IP) *types.IpamSubnetType {
+	for _, ipam := range ipams {
+		_, subnet, err := net.ParseCIDR(contrailSubnetCIDR(ipam))
+		if err == nil && subnet.Contains(ip) {
+			return ipam
+		}
+	}
+	return nil
+}
+
+// sameSubnetCIDRs tells whether both lists contain the same subnets, regardless of their order
+// and notation.
+func sameSubnetCIDRs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	normalize := func(cidrs []string) []string {
+		var normalized []string
+		for _, cidr := range cidrs {
+			if _, subnet, err := net.ParseCIDR(cidr); err == nil {
+				cidr = subnet.String()
+			}
+			normalized = append(normalized, cidr)
+		}
+		sort.Strings(normalized)
+		return normalized
+	}
+	normalizedA := normalize(a)
+	normalizedB := normalize(b)
+	for i := range normalizedA {
+		if normalizedA[i] != normalizedB[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -13,34 +13,43 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build windows
+// +build windows
+
 package driver
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
-	"testing"
 	"time"
 
 	"github.com/Juniper/contrail-go-api/types"
-	"github.com/Microsoft/hcsshim"
 	log "github.com/sirupsen/logrus"
+	"github.com/codilime/contrail-windows-docker/agent"
 	"github.com/codilime/contrail-windows-docker/common"
 	"github.com/codilime/contrail-windows-docker/controller"
 	"github.com/codilime/contrail-windows-docker/hns"
 	"github.com/codilime/contrail-windows-docker/hyperv"
+	"github.com/codilime/contrail-windows-docker/metrics"
+	"github.com/codilime/contrail-windows-docker/state"
+	"github.com/codilime/contrail-windows-docker/transport"
 	dockerTypes "github.com/docker/docker/api/types"
 	dockerTypesContainer "github.com/docker/docker/api/types/container"
 	dockerTypesNetwork "github.com/docker/docker/api/types/network"
 	dockerClient "github.com/docker/docker/client"
 	"github.com/docker/go-connections/sockets"
 	"github.com/docker/go-plugins-helpers/network"
+	"github.com/docker/libnetwork/netlabel"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
-	"github.com/onsi/ginkgo/reporters"
 	. "github.com/onsi/gomega"
 )
 
@@ -69,20 +78,20 @@ func init() {
 	log.SetLevel(log.DebugLevel)
 }
 
-func TestDriver(t *testing.T) {
-	RegisterFailHandler(Fail)
-	junitReporter := reporters.NewJUnitReporter("driver_junit.xml")
-	RunSpecsWithDefaultAndCustomReporters(t, "Contrail Network Driver test suite",
-		[]Reporter{junitReporter})
-}
+var stateDir string
 
 var _ = BeforeSuite(func() {
 	vswitchName = strings.Replace(vswitchNameWildcard, "<adapter>", netAdapter, -1)
 	cleanupAll()
+
+	var err error
+	stateDir, err = ioutil.TempDir("", "driver_state")
+	Expect(err).ToNot(HaveOccurred())
 })
 
 var _ = AfterSuite(func() {
 	cleanupAll()
+	os.RemoveAll(stateDir)
 })
 
 func cleanupAll() {
@@ -109,6 +118,11 @@ var contrailController *controller.Controller
 var contrailDriver *ContrailDriver
 var project *types.Project
 
+const (
+	mockAgentAddr = "127.0.0.1:9090"
+	mockAgentURL  = "http://" + mockAgentAddr
+)
+
 const (
 	tenantName  = "agatka"
 	networkName = "test_net"
@@ -140,7 +154,7 @@ var _ = Describe("Contrail Network Driver", func() {
 		err := contrailDriver.StartServing()
 		Expect(err).ToNot(HaveOccurred())
 
-		conn, err := sockets.DialPipe(contrailDriver.PipeAddr, timeout)
+		conn, err := sockets.DialPipe(transport.PipeAddr(common.DriverName), timeout)
 		Expect(err).ToNot(HaveOccurred())
 		if conn != nil {
 			conn.Close()
@@ -149,13 +163,35 @@ var _ = Describe("Contrail Network Driver", func() {
 		err = contrailDriver.StopServing()
 		Expect(err).ToNot(HaveOccurred())
 
-		conn, err = sockets.DialPipe(contrailDriver.PipeAddr, timeout)
+		conn, err = sockets.DialPipe(transport.PipeAddr(common.DriverName), timeout)
 		Expect(err).To(HaveOccurred())
 		if conn != nil {
 			conn.Close()
 		}
 	})
 
+	It("can start and stop listening on TCP", func() {
+		specDir, err := ioutil.TempDir(stateDir, "plugins")
+		Expect(err).ToNot(HaveOccurred())
+		contrailDriver.Transport = transport.NewTCPTransport("127.0.0.1", nil, specDir)
+
+		err = contrailDriver.StartServing()
+		Expect(err).ToNot(HaveOccurred())
+
+		url, err := ioutil.ReadFile(transport.SpecFilePath(specDir, common.DriverName))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(url)).To(HavePrefix("tcp://"))
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(string(url), "tcp://"), timeout)
+		Expect(err).ToNot(HaveOccurred())
+		conn.Close()
+
+		err = contrailDriver.StopServing()
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = os.Stat(transport.SpecFilePath(specDir, common.DriverName))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
 	It("creates a spec file for duration of listening", func() {
 		err := contrailDriver.StartServing()
 		Expect(err).ToNot(HaveOccurred())
@@ -340,6 +376,13 @@ var _ = Describe("On requests from docker daemon", func() {
 			Expect(resp).To(Equal(&network.CapabilitiesResponse{Scope: "local"}))
 			Expect(err).ToNot(HaveOccurred())
 		})
+		It("returns global scope if driver runs in global scope", func() {
+			globalDriver := NewDriver(netAdapter, vswitchName, network.GlobalScope,
+				contrailController, newTestAgentQueue(), newTestStore(), hns.WindowsService{})
+			resp, err := globalDriver.GetCapabilities()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.Scope).To(Equal("global"))
+		})
 	})
 
 	Context("on CreateNetwork request", func() {
@@ -415,15 +458,159 @@ var _ = Describe("On requests from docker daemon", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(netsBefore).To(HaveLen(len(netsAfter) - 1))
 			})
+			It("responds with err if pool isn't Contrail subnet", func() {
+				// null IPAM driver gives unspecified address pool; it doesn't select any subnet
+				req.IPv4Data = []*network.IPAMData{
+					{
+						Pool: "0.0.0.0/32",
+					},
+				}
+				err := contrailDriver.CreateNetwork(req)
+				Expect(err).To(HaveOccurred())
+			})
+			It("records the network in state store", func() {
+				err := contrailDriver.CreateNetwork(req)
+				Expect(err).ToNot(HaveOccurred())
+
+				hnsNet, err := contrailDriver.hnsMgr.GetNetwork(tenantName, networkName,
+					[]string{subnetCIDR})
+				Expect(err).ToNot(HaveOccurred())
+
+				storedNet := contrailDriver.store.GetNetwork(req.NetworkID)
+				Expect(storedNet).ToNot(BeNil())
+				Expect(storedNet.Tenant).To(Equal(tenantName))
+				Expect(storedNet.Network).To(Equal(networkName))
+				Expect(storedNet.SubnetCIDRs).To(Equal([]string{subnetCIDR}))
+				Expect(storedNet.HNSID).To(Equal(hnsNet.Id))
+			})
+		})
+		Context("Contrail network is dual-stack", func() {
+			const (
+				subnetCIDRv6 = "fd00:10::/64"
+				defaultGWv6  = "fd00:10::1"
+			)
+			BeforeEach(func() {
+				contrailNet := createContrailNetwork(contrailController)
+				controller.AddSubnetWithDefaultGateway(contrailController.ApiClient,
+					"fd00:10::", defaultGWv6, 64, contrailNet)
+
+				genericOptions["network"] = networkName
+				genericOptions["tenant"] = tenantName
+				req.Options["com.docker.network.generic"] = genericOptions
+				req.IPv6Data = []*network.IPAMData{
+					{
+						Pool: subnetCIDRv6,
+					},
+				}
+			})
+			It("creates a HNS network with both subnets", func() {
+				err := contrailDriver.CreateNetwork(req)
+				Expect(err).ToNot(HaveOccurred())
+
+				hnsNet, err := contrailDriver.hnsMgr.GetNetwork(tenantName, networkName,
+					[]string{subnetCIDR, subnetCIDRv6})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hnsNet.Subnets).To(HaveLen(2))
+			})
+		})
+		Context("Contrail network has multiple subnets", func() {
+			const secondSubnetCIDR = "10.20.20.0/24"
+			BeforeEach(func() {
+				contrailNet := createContrailNetwork(contrailController)
+				controller.AddSubnetWithDefaultGateway(contrailController.ApiClient,
+					"10.20.20.0", "10.20.20.1", 24, contrailNet)
+
+				genericOptions["network"] = networkName
+				genericOptions["tenant"] = tenantName
+				req.Options["com.docker.network.generic"] = genericOptions
+			})
+			It("creates a HNS network spanning all requested subnets", func() {
+				req.IPv4Data = append(req.IPv4Data, &network.IPAMData{
+					Pool: secondSubnetCIDR,
+				})
+				err := contrailDriver.CreateNetwork(req)
+				Expect(err).ToNot(HaveOccurred())
+
+				hnsNet, err := contrailDriver.hnsMgr.GetNetwork(tenantName, networkName,
+					[]string{subnetCIDR, secondSubnetCIDR})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hnsNet.Subnets).To(HaveLen(2))
+			})
+			It("responds with err if pool isn't one of Contrail subnets", func() {
+				// null IPAM driver gives unspecified address pool; it doesn't select any subnet
+				req.IPv4Data = []*network.IPAMData{
+					{
+						Pool: "0.0.0.0/32",
+					},
+				}
+				err := contrailDriver.CreateNetwork(req)
+				Expect(err).To(HaveOccurred())
+			})
 		})
 	})
 
 	Context("on AllocateNetwork request", func() {
-		It("responds with not implemented error", func() {
+		It("responds with error in local scope", func() {
 			req := network.AllocateNetworkRequest{}
 			_, err := contrailDriver.AllocateNetwork(&req)
 			Expect(err).To(HaveOccurred())
 		})
+
+		Context("in global scope", func() {
+			var globalDriver *ContrailDriver
+			var req *network.AllocateNetworkRequest
+
+			BeforeEach(func() {
+				globalDriver = NewDriver(netAdapter, vswitchName, network.GlobalScope,
+					contrailController, newTestAgentQueue(), newTestStore(), hns.WindowsService{})
+				req = &network.AllocateNetworkRequest{
+					NetworkID: "MyAwesomeNet",
+					Options: map[string]string{
+						"tenant":  tenantName,
+						"network": networkName,
+					},
+					IPv4Data: []network.IPAMData{
+						{
+							Pool: subnetCIDR,
+						},
+					},
+				}
+			})
+			It("responds with err if Contrail network doesn't exist", func() {
+				_, err := globalDriver.AllocateNetwork(req)
+				Expect(err).To(HaveOccurred())
+			})
+			It("passes Contrail network info to workers", func() {
+				contrailNet := createContrailNetwork(contrailController)
+				resp, err := globalDriver.AllocateNetwork(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.Options).To(HaveKeyWithValue("tenant", tenantName))
+				Expect(resp.Options).To(HaveKeyWithValue("network", networkName))
+				Expect(resp.Options).To(HaveKeyWithValue(networkUuidOption,
+					contrailNet.GetUuid()))
+				Expect(resp.Options).To(HaveKeyWithValue(subnetCIDRsOption, subnetCIDR))
+			})
+			Specify("worker refuses to create network if Contrail network was recreated",
+				func() {
+					_ = createContrailNetwork(contrailController)
+					err := contrailDriver.CreateNetwork(&network.CreateNetworkRequest{
+						NetworkID: "MyAwesomeNet",
+						Options: map[string]interface{}{
+							"com.docker.network.generic": map[string]interface{}{
+								"tenant":          tenantName,
+								"network":         networkName,
+								networkUuidOption: "some-other-uuid",
+							},
+						},
+						IPv4Data: []*network.IPAMData{
+							{
+								Pool: subnetCIDR,
+							},
+						},
+					})
+					Expect(err).To(HaveOccurred())
+				})
+		})
 	})
 
 	Context("on DeleteNetwork request", func() {
@@ -433,7 +620,7 @@ var _ = Describe("On requests from docker daemon", func() {
 
 		assertRemovesHNSNet := func() {
 			resp, err := contrailDriver.hnsMgr.GetNetwork(tenantName, networkName,
-				subnetCIDR)
+				[]string{subnetCIDR})
 			Expect(err).To(HaveOccurred())
 			Expect(resp).To(BeNil())
 		}
@@ -464,10 +651,66 @@ var _ = Describe("On requests from docker daemon", func() {
 			It("does not remove Contrail net", assertDoesNotRemoveContrailNet)
 		})
 
+		Context("Contrail network has multiple subnets", func() {
+			BeforeEach(func() {
+				controller.AddSubnetWithDefaultGateway(contrailController.ApiClient,
+					"10.20.20.0", "10.20.20.1", 24, contrailNet)
+				err := removeDockerNetwork(docker, dockerNetID)
+				Expect(err).ToNot(HaveOccurred())
+				dockerNetID = createValidDockerNetwork(docker)
+
+				_, err = contrailDriver.hnsMgr.GetNetwork(tenantName, networkName,
+					[]string{subnetCIDR, "10.20.20.0/24"})
+				Expect(err).ToNot(HaveOccurred())
+
+				err = removeDockerNetwork(docker, dockerNetID)
+				Expect(err).ToNot(HaveOccurred())
+			})
+			It("removes HNS net with all its subnets", func() {
+				nets, err := contrailDriver.hnsMgr.ListNetworks()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(nets).To(BeEmpty())
+			})
+			It("removes docker net", assertRemovesDockerNet)
+		})
+
+		Context("driver was restarted", func() {
+			BeforeEach(func() {
+				err := contrailDriver.StopServing()
+				Expect(err).ToNot(HaveOccurred())
+
+				store, err := state.NewStore(contrailDriver.store.Path())
+				Expect(err).ToNot(HaveOccurred())
+				contrailDriver = NewDriver(netAdapter, vswitchName, network.LocalScope,
+					contrailController, newTestAgentQueue(), store, hns.WindowsService{})
+				err = contrailDriver.StartServing()
+				Expect(err).ToNot(HaveOccurred())
+
+				err = removeDockerNetwork(docker, dockerNetID)
+				Expect(err).ToNot(HaveOccurred())
+			})
+			It("removes HNS net", assertRemovesHNSNet)
+			It("removes network from state store", func() {
+				Expect(contrailDriver.store.GetNetwork(dockerNetID)).To(BeNil())
+			})
+		})
+
+		Context("network is not recorded in state store", func() {
+			// for example, it was created by a version of the driver that had no store.
+			BeforeEach(func() {
+				err := contrailDriver.store.RemoveNetwork(dockerNetID)
+				Expect(err).ToNot(HaveOccurred())
+				err = removeDockerNetwork(docker, dockerNetID)
+				Expect(err).ToNot(HaveOccurred())
+			})
+			It("removes HNS net", assertRemovesHNSNet)
+			It("removes docker net", assertRemovesDockerNet)
+		})
+
 		Context("HNS network doesn't exist", func() {
 			// for example, HNS was hard-reset while docker wasn't.
 			BeforeEach(func() {
-				contrailDriver.hnsMgr.DeleteNetwork(tenantName, networkName, subnetCIDR)
+				contrailDriver.hnsMgr.DeleteNetwork(tenantName, networkName, []string{subnetCIDR})
 				err := removeDockerNetwork(docker, dockerNetID)
 				Expect(err).ToNot(HaveOccurred())
 			})
@@ -489,11 +732,18 @@ var _ = Describe("On requests from docker daemon", func() {
 	})
 
 	Context("on FreeNetwork request", func() {
-		It("responds with not implemented error", func() {
+		It("responds with error in local scope", func() {
 			req := network.FreeNetworkRequest{}
 			err := contrailDriver.FreeNetwork(&req)
 			Expect(err).To(HaveOccurred())
 		})
+		It("responds with nil in global scope", func() {
+			globalDriver := NewDriver(netAdapter, vswitchName, network.GlobalScope,
+				contrailController, newTestAgentQueue(), newTestStore(), hns.WindowsService{})
+			req := network.FreeNetworkRequest{}
+			err := globalDriver.FreeNetwork(&req)
+			Expect(err).ToNot(HaveOccurred())
+		})
 	})
 
 	Context("on CreateEndpoint request", func() {
@@ -526,17 +776,16 @@ var _ = Describe("On requests from docker daemon", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(net).ToNot(BeNil())
 
-				// TODO JW-187. For now, VM name is the same as Endpoint ID, not
-				// Container ID
 				dockerNet, err := getDockerNetwork(docker, dockerNetID)
 				Expect(err).ToNot(HaveOccurred())
-				vmName := dockerNet.Containers[containerID].EndpointID
+				endpointID := dockerNet.Containers[containerID].EndpointID
 
-				inst, err := types.VirtualMachineByName(contrailController.ApiClient, vmName)
+				inst, err := types.VirtualMachineByName(contrailController.ApiClient, containerID)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(inst).ToNot(BeNil())
 
-				vifFQName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vmName)
+				vifFQName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName,
+					controller.InterfaceName(networkName, endpointID))
 				vif, err := types.VirtualMachineInterfaceByName(contrailController.ApiClient,
 					vifFQName)
 				Expect(err).ToNot(HaveOccurred())
@@ -569,6 +818,225 @@ var _ = Describe("On requests from docker daemon", func() {
 				Expect(ep.MacAddress).To(Equal(formattedMac))
 				Expect(ep.GatewayAddress).To(Equal(gw))
 			})
+			It("records the endpoint in state store", func() {
+				dockerNet, err := getDockerNetwork(docker, dockerNetID)
+				Expect(err).ToNot(HaveOccurred())
+				endpointID := dockerNet.Containers[containerID].EndpointID
+
+				inst, err := types.VirtualMachineByName(contrailController.ApiClient, containerID)
+				Expect(err).ToNot(HaveOccurred())
+				_, hnsEndpointID := getTheOnlyHNSEndpoint(contrailDriver)
+
+				storedEp := contrailDriver.store.GetEndpoint(endpointID)
+				Expect(storedEp).ToNot(BeNil())
+				Expect(storedEp.NetworkID).To(Equal(dockerNetID))
+				Expect(storedEp.HNSEndpointID).To(Equal(hnsEndpointID))
+				Expect(storedEp.InstanceIPUuids).To(HaveLen(1))
+				Expect(storedEp.VMUuid).To(Equal(inst.GetUuid()))
+				Expect(storedEp.ContainerID).To(Equal(containerID))
+			})
+		})
+
+		Context("container is connected to two Contrail networks", func() {
+			const (
+				secondNetworkName = "test_net2"
+				secondSubnetCIDR  = "10.20.20.0/24"
+			)
+
+			containerID := ""
+			var mockAgentListener *OneTimeListener
+
+			BeforeEach(func() {
+				mockAgentListener = startMockAgentListener()
+				_ = createContrailNetwork(contrailController)
+				_ = controller.CreateMockedNetworkWithSubnet(contrailController.ApiClient,
+					secondNetworkName, secondSubnetCIDR, project)
+				_ = createValidDockerNetwork(docker)
+				secondDockerNetID := createDockerNetwork(tenantName, secondNetworkName, docker)
+
+				resp, err := docker.ContainerCreate(context.Background(),
+					&dockerTypesContainer.Config{
+						Image: "microsoft/nanoserver",
+					},
+					&dockerTypesContainer.HostConfig{
+						NetworkMode: networkName,
+					},
+					nil, "test_container_name")
+				Expect(err).ToNot(HaveOccurred())
+				containerID = resp.ID
+
+				err = docker.NetworkConnect(context.Background(), secondDockerNetID, containerID,
+					nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = docker.ContainerStart(context.Background(), containerID,
+					dockerTypes.ContainerStartOptions{})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			AfterEach(func(done Done) {
+				<-mockAgentListener.Received
+				mockAgentListener.Close()
+				mockAgentListener = nil
+				close(done)
+			})
+			It("creates a single Contrail virtual-machine with an interface per network", func() {
+				inst, err := types.VirtualMachineByName(contrailController.ApiClient, containerID)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(inst).ToNot(BeNil())
+
+				vifRefs, err := inst.GetVirtualMachineInterfaceBackRefs()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(vifRefs).To(HaveLen(2))
+			})
+			It("removes the virtual-machine only with its last interface", func() {
+				err := docker.NetworkDisconnect(context.Background(), secondNetworkName,
+					containerID, true)
+				Expect(err).ToNot(HaveOccurred())
+
+				inst, err := types.VirtualMachineByName(contrailController.ApiClient, containerID)
+				Expect(err).ToNot(HaveOccurred())
+				vifRefs, err := inst.GetVirtualMachineInterfaceBackRefs()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(vifRefs).To(HaveLen(1))
+
+				stopAndRemoveDockerContainer(docker, containerID)
+
+				_, err = types.VirtualMachineByName(contrailController.ApiClient, containerID)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("container is run with static IP and MAC", func() {
+			const (
+				staticIP  = "10.10.10.42"
+				staticMac = "02:11:22:aa:bb:cc"
+			)
+
+			containerID := ""
+			var mockAgentListener *OneTimeListener
+
+			BeforeEach(func() {
+				mockAgentListener = startMockAgentListener()
+				_ = createContrailNetwork(contrailController)
+				_ = createValidDockerNetwork(docker)
+
+				resp, err := docker.ContainerCreate(context.Background(),
+					&dockerTypesContainer.Config{
+						Image:      "microsoft/nanoserver",
+						MacAddress: staticMac,
+					},
+					&dockerTypesContainer.HostConfig{
+						NetworkMode: networkName,
+					},
+					&dockerTypesNetwork.NetworkingConfig{
+						EndpointsConfig: map[string]*dockerTypesNetwork.EndpointSettings{
+							networkName: {
+								IPAMConfig: &dockerTypesNetwork.EndpointIPAMConfig{
+									IPv4Address: staticIP,
+								},
+							},
+						},
+					}, "test_container_name")
+				Expect(err).ToNot(HaveOccurred())
+				containerID = resp.ID
+
+				err = docker.ContainerStart(context.Background(), containerID,
+					dockerTypes.ContainerStartOptions{})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			AfterEach(func(done Done) {
+				<-mockAgentListener.Received
+				mockAgentListener.Close()
+				mockAgentListener = nil
+				close(done)
+			})
+			It("reserves requested IP and MAC in Contrail", func() {
+				contrailNet, err := contrailController.GetNetwork(tenantName, networkName)
+				Expect(err).ToNot(HaveOccurred())
+				instIP, err := contrailController.GetInstanceIpByAddress(contrailNet, staticIP)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instIP).ToNot(BeNil())
+
+				ep, _ := getTheOnlyHNSEndpoint(contrailDriver)
+				Expect(ep.IPAddress.String()).To(Equal(staticIP))
+				Expect(ep.MacAddress).To(Equal("02-11-22-AA-BB-CC"))
+			})
+		})
+
+		Context("a step of CreateEndpoint fails", func() {
+			const endpointID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+			var dockerNetID string
+
+			BeforeEach(func() {
+				_ = createContrailNetwork(contrailController)
+				dockerNetID = createValidDockerNetwork(docker)
+			})
+			It("removes objects created by previous steps", func() {
+				// interface is created before the address is found to be outside of subnet
+				req := &network.CreateEndpointRequest{
+					NetworkID:  dockerNetID,
+					EndpointID: endpointID,
+					Interface: &network.EndpointInterface{
+						Address: "10.99.99.99/24",
+					},
+				}
+				_, err := contrailDriver.CreateEndpoint(req)
+				Expect(err).To(HaveOccurred())
+
+				_, err = types.VirtualMachineInterfaceByName(contrailController.ApiClient,
+					fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName,
+						controller.InterfaceName(networkName, endpointID)))
+				Expect(err).To(HaveOccurred())
+
+				ep, err := hns.GetHNSEndpointByName(endpointID)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ep).To(BeNil())
+				Expect(contrailDriver.store.GetEndpoint(endpointID)).To(BeNil())
+			})
+		})
+
+		Context("docker network specifies Contrail security groups", func() {
+			var testGroup *types.SecurityGroup
+			var mockAgentListener *OneTimeListener
+
+			BeforeEach(func() {
+				_ = createContrailNetwork(contrailController)
+				testGroup = controller.CreateMockedSecurityGroup(contrailController.ApiClient,
+					project, "test_sg")
+			})
+			AfterEach(func() {
+				if mockAgentListener != nil {
+					mockAgentListener.Close()
+					mockAgentListener = nil
+				}
+			})
+			It("attaches them to container's interface", func() {
+				mockAgentListener = startMockAgentListener()
+				dockerNetID := createDockerNetworkWithOptions(tenantName, networkName,
+					map[string]string{securityGroupsOption: "test_sg"}, docker)
+				containerID, err := runDockerContainer(docker)
+				Expect(err).ToNot(HaveOccurred())
+				<-mockAgentListener.Received
+
+				dockerNet, err := getDockerNetwork(docker, dockerNetID)
+				Expect(err).ToNot(HaveOccurred())
+				endpointID := dockerNet.Containers[containerID].EndpointID
+				vif, err := types.VirtualMachineInterfaceByName(contrailController.ApiClient,
+					fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName,
+						controller.InterfaceName(networkName, endpointID)))
+				Expect(err).ToNot(HaveOccurred())
+
+				refs, err := vif.GetSecurityGroupRefs()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(refs).To(HaveLen(1))
+				Expect(refs[0].Uuid).To(Equal(testGroup.GetUuid()))
+			})
+			It("refuses to run container if security group doesn't exist", func() {
+				_ = createDockerNetworkWithOptions(tenantName, networkName,
+					map[string]string{securityGroupsOption: "nonexisting_sg"}, docker)
+				_, err := runDockerContainer(docker)
+				Expect(err).To(HaveOccurred())
+			})
 		})
 
 		Context("Contrail and docker networks exists, HNS network doesn't", func() {
@@ -579,7 +1047,7 @@ var _ = Describe("On requests from docker daemon", func() {
 				_ = createContrailNetwork(contrailController)
 				_ = createValidDockerNetwork(docker)
 
-				contrailDriver.hnsMgr.DeleteNetwork(tenantName, networkName, subnetCIDR)
+				contrailDriver.hnsMgr.DeleteNetwork(tenantName, networkName, []string{subnetCIDR})
 			})
 			It("responds with err", func() {
 				var err error
@@ -607,7 +1075,7 @@ var _ = Describe("On requests from docker daemon", func() {
 		dockerNetID := ""
 		containerID := ""
 		hnsEndpointID := ""
-		vmName := ""
+		vifName := ""
 		var contrailInst *types.VirtualMachine
 		var contrailVif *types.VirtualMachineInterface
 		var contrailIP *types.InstanceIp
@@ -618,23 +1086,23 @@ var _ = Describe("On requests from docker daemon", func() {
 			_, dockerNetID, containerID = setupNetworksAndEndpoints(contrailController, docker)
 			_, hnsEndpointID = getTheOnlyHNSEndpoint(contrailDriver)
 
-			// TODO JW-187. For now, VM name is the same as Endpoint ID, not
-			// Container ID
 			dockerNet, err := getDockerNetwork(docker, dockerNetID)
 			Expect(err).ToNot(HaveOccurred())
-			vmName = dockerNet.Containers[containerID].EndpointID
+			vifName = controller.InterfaceName(networkName,
+				dockerNet.Containers[containerID].EndpointID)
 
-			contrailInst, err = types.VirtualMachineByName(contrailController.ApiClient, vmName)
+			contrailInst, err = types.VirtualMachineByName(contrailController.ApiClient,
+				containerID)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(contrailInst).ToNot(BeNil())
 
-			vifFQName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vmName)
+			vifFQName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vifName)
 			contrailVif, err = types.VirtualMachineInterfaceByName(contrailController.ApiClient,
 				vifFQName)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(contrailVif).ToNot(BeNil())
 
-			contrailIP, err = types.InstanceIpByName(contrailController.ApiClient, vmName)
+			contrailIP, err = types.InstanceIpByName(contrailController.ApiClient, vifName)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(contrailIP).ToNot(BeNil())
 		})
@@ -663,11 +1131,11 @@ var _ = Describe("On requests from docker daemon", func() {
 
 		assertRemovesContrailVM := func() {
 			_, err := types.VirtualMachineByName(contrailController.ApiClient,
-				vmName)
+				containerID)
 			Expect(err).To(HaveOccurred())
 
 			_, err = types.VirtualMachineInterfaceByName(contrailController.ApiClient,
-				fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vmName))
+				fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vifName))
 			Expect(err).To(HaveOccurred())
 
 			_, err = types.InstanceIpByName(contrailController.ApiClient,
@@ -682,6 +1150,14 @@ var _ = Describe("On requests from docker daemon", func() {
 			It("removes docker endpoint", assertRemovesDockerEndpoint)
 			It("removes HNS endpoint", assertRemovesHNSEndpoint)
 			It("removes virtual-machine and its children in Contrail", assertRemovesContrailVM)
+			It("removes endpoint from state store", func() {
+				Expect(contrailDriver.store.ListEndpoints()).To(BeEmpty())
+			})
+			It("removes vRouter Agent port file", func() {
+				_, err := os.Stat(filepath.Join(contrailDriver.AgentPortFiles.Dir(),
+					contrailVif.GetUuid()))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
 		})
 
 		Context("HNS endpoint doesn't exist", func() {
@@ -731,6 +1207,27 @@ var _ = Describe("On requests from docker daemon", func() {
 				Expect(resp.Value).To(HaveKeyWithValue(
 					"com.docker.network.endpoint.macaddress", hnsEndpoint.MacAddress))
 			})
+			It("records metrics of requests from docker", func() {
+				recorder := httptest.NewRecorder()
+				metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+				exported := recorder.Body.String()
+				Expect(exported).To(MatchRegexp(
+					`contrail_driver_handler_requests_total{handler="CreateEndpoint"} [1-9]`))
+				Expect(exported).To(MatchRegexp(
+					`contrail_driver_handler_duration_seconds_count{handler="Join"} [1-9]`))
+			})
+			It("reports status of the port in vRouter Agent", func() {
+				// agent stand-in isn't running, so adding the port fails
+				Eventually(func() map[string]string {
+					resp, err := contrailDriver.EndpointInfo(req)
+					Expect(err).ToNot(HaveOccurred())
+					return resp.Value
+				}).Should(HaveKeyWithValue("vrouter_port", "failed"))
+
+				resp, err := contrailDriver.EndpointInfo(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.Value).To(HaveKey("vrouter_port_error"))
+			})
 		})
 
 		Context("queried endpoint doesn't exist", func() {
@@ -757,6 +1254,7 @@ var _ = Describe("On requests from docker daemon", func() {
 			req = &network.JoinRequest{
 				NetworkID:  dockerNetID,
 				EndpointID: dockerNet.Containers[containerID].EndpointID,
+				SandboxKey: containerID,
 			}
 		})
 
@@ -775,6 +1273,49 @@ var _ = Describe("On requests from docker daemon", func() {
 
 				Expect(resp.Gateway).To(Equal(contrailGW))
 			})
+			It("writes vRouter Agent port file", func() {
+				_, err := contrailDriver.Join(req)
+				Expect(err).ToNot(HaveOccurred())
+
+				storedEp := contrailDriver.store.GetEndpoint(req.EndpointID)
+				Expect(storedEp).ToNot(BeNil())
+				_, err = os.Stat(filepath.Join(contrailDriver.AgentPortFiles.Dir(),
+					storedEp.VMIUuid))
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("endpoint is dual-stack", func() {
+			const instanceIPv6 = "fd00:10::3"
+
+			BeforeEach(func() {
+				// CreateEndpoint keeps IPv6 address in state file only, because HNS endpoint
+				// has no IPv6 settings. Store is reopened, as if the service was restarted.
+				storedEp := contrailDriver.store.GetEndpoint(req.EndpointID)
+				Expect(storedEp).ToNot(BeNil())
+				storedEp.IPv6Address = instanceIPv6
+				Expect(contrailDriver.store.SaveEndpoint(*storedEp)).To(Succeed())
+
+				store, err := state.NewStore(contrailDriver.store.Path())
+				Expect(err).ToNot(HaveOccurred())
+				contrailDriver.store = store
+			})
+			It("passes IPv6 address to vRouter Agent port, but not to docker", func() {
+				resp, err := contrailDriver.Join(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.GatewayIPv6).To(BeEmpty())
+
+				storedEp := contrailDriver.store.GetEndpoint(req.EndpointID)
+				Expect(storedEp).ToNot(BeNil())
+				Expect(storedEp.IPv6Address).To(Equal(instanceIPv6))
+
+				data, err := ioutil.ReadFile(filepath.Join(contrailDriver.AgentPortFiles.Dir(),
+					storedEp.VMIUuid))
+				Expect(err).ToNot(HaveOccurred())
+				var portFile map[string]interface{}
+				Expect(json.Unmarshal(data, &portFile)).To(Succeed())
+				Expect(portFile).To(HaveKeyWithValue("ip6-address", instanceIPv6))
+			})
 		})
 
 		Context("queried endpoint doesn't exist", func() {
@@ -786,6 +1327,89 @@ var _ = Describe("On requests from docker daemon", func() {
 				Expect(err).To(HaveOccurred())
 			})
 		})
+
+		Context("Contrail controller is unavailable", func() {
+			var disconnected *controller.Controller
+
+			BeforeEach(func() {
+				disconnected = controller.NewDisconnectedController()
+				contrailDriver.controller = disconnected
+			})
+			AfterEach(func() {
+				contrailDriver.controller = contrailController
+			})
+
+			It("joins from HNS state, and completes join when controller is connected", func() {
+				resp, err := contrailDriver.Join(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.DisableGatewayService).To(BeTrue())
+				Expect(resp.Gateway).To(Equal(defaultGW))
+
+				storedEp := contrailDriver.store.GetEndpoint(req.EndpointID)
+				Expect(storedEp).ToNot(BeNil())
+				Expect(storedEp.VMUuid).To(BeEmpty())
+				Expect(storedEp.ContainerID).To(Equal(containerID))
+
+				connected := disconnected.KeepConnecting(func() (*controller.Controller,
+					error) {
+					return contrailController, nil
+				}, nil)
+				Expect(connected).To(BeTrue())
+
+				completed, err := contrailDriver.CompleteDegradedJoins()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(completed).To(Equal(1))
+				storedEp = contrailDriver.store.GetEndpoint(req.EndpointID)
+				Expect(storedEp.VMUuid).ToNot(BeEmpty())
+			})
+		})
+	})
+
+	Context("on vRouter Agent ports resync", func() {
+
+		var endpointID string
+
+		BeforeEach(func() {
+			// agent stand-in isn't running yet, so adding the port on Join fails
+			_, dockerNetID, containerID := setupNetworksAndEndpoints(contrailController, docker)
+			dockerNet, err := getDockerNetwork(docker, dockerNetID)
+			Expect(err).ToNot(HaveOccurred())
+			endpointID = dockerNet.Containers[containerID].EndpointID
+		})
+
+		It("adds ports of joined endpoints to agent again", func(done Done) {
+			storedEp := contrailDriver.store.GetEndpoint(endpointID)
+			Expect(storedEp).ToNot(BeNil())
+			Eventually(func() agent.PortState {
+				status, _ := contrailDriver.agentQueue.Status(storedEp.VMIUuid)
+				return status.State
+			}).Should(Equal(agent.PortFailed))
+
+			mockAgentListener := startMockAgentListener()
+			defer mockAgentListener.Close()
+
+			enqueued, err := contrailDriver.ResyncAgentPorts()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(enqueued).To(Equal(1))
+
+			<-mockAgentListener.Received
+			Eventually(func() agent.PortState {
+				status, _ := contrailDriver.agentQueue.Status(storedEp.VMIUuid)
+				return status.State
+			}).Should(Equal(agent.PortOK))
+			close(done)
+		})
+	})
+
+	Specify("Contrail host routes are translated into docker static routes", func() {
+		routes := joinStaticRoutes([]types.RouteType{
+			{Prefix: "10.30.0.0/16", NextHop: "10.10.10.254"},
+			{Prefix: "10.40.0.0/16"},
+		})
+		Expect(routes).To(Equal([]*network.StaticRoute{
+			{Destination: "10.30.0.0/16", RouteType: routeTypeNextHop, NextHop: "10.10.10.254"},
+			{Destination: "10.40.0.0/16", RouteType: routeTypeConnected},
+		}))
 	})
 
 	Context("on Leave request", func() {
@@ -838,19 +1462,101 @@ var _ = Describe("On requests from docker daemon", func() {
 		})
 	})
 
-	Context("on ProgramExternalConnectivity request", func() {
-		It("responds with nil", func() {
-			req := network.ProgramExternalConnectivityRequest{}
-			err := contrailDriver.ProgramExternalConnectivity(&req)
+	Context("on ProgramExternalConnectivity and RevokeExternalConnectivity requests", func() {
+		const endpointID = "someendpointID"
+
+		var contrailNet *types.VirtualNetwork
+		var contrailVif *types.VirtualMachineInterface
+		var portMapOptions map[string]interface{}
+
+		BeforeEach(func() {
+			contrailNet = createContrailNetwork(contrailController)
+			var err error
+			contrailVif, err = contrailController.GetOrCreateInterface(contrailNet, tenantName,
+				controller.InterfaceName(networkName, endpointID))
 			Expect(err).ToNot(HaveOccurred())
+
+			// this is how port bindings look like after decoding JSON request
+			portMapOptions = map[string]interface{}{
+				netlabel.PortMap: []interface{}{
+					map[string]interface{}{
+						"Proto":    float64(6),
+						"Port":     float64(80),
+						"HostPort": float64(8080),
+					},
+				},
+			}
 		})
-	})
 
-	Context("on RevokeExternalConnectivity request", func() {
-		It("responds with nil", func() {
-			req := network.RevokeExternalConnectivityRequest{}
-			err := contrailDriver.RevokeExternalConnectivity(&req)
-			Expect(err).ToNot(HaveOccurred())
+		Context("network has no floating IP pool", func() {
+			var dockerNetID string
+			BeforeEach(func() {
+				dockerNetID = createValidDockerNetwork(docker)
+			})
+			It("responds with nil", func() {
+				err := contrailDriver.ProgramExternalConnectivity(
+					&network.ProgramExternalConnectivityRequest{
+						NetworkID:  dockerNetID,
+						EndpointID: endpointID,
+						Options:    portMapOptions,
+					})
+				Expect(err).ToNot(HaveOccurred())
+				err = contrailDriver.RevokeExternalConnectivity(
+					&network.RevokeExternalConnectivityRequest{
+						NetworkID:  dockerNetID,
+						EndpointID: endpointID,
+					})
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("network has floating IP pool", func() {
+			var dockerNetID string
+			var fipFQName string
+			BeforeEach(func() {
+				pool := controller.CreateMockedFloatingIpPool(contrailController.ApiClient,
+					contrailNet, "test_pool")
+				poolFQName := strings.Join(pool.GetFQName(), ":")
+				fipFQName = poolFQName + ":" + contrailVif.GetName()
+				dockerNetID = createDockerNetworkWithOptions(tenantName, networkName,
+					map[string]string{floatingIPPoolOption: poolFQName}, docker)
+			})
+			It("publishes ports through floating IP until revoked", func() {
+				err := contrailDriver.ProgramExternalConnectivity(
+					&network.ProgramExternalConnectivityRequest{
+						NetworkID:  dockerNetID,
+						EndpointID: endpointID,
+						Options:    portMapOptions,
+					})
+				Expect(err).ToNot(HaveOccurred())
+
+				fip, err := types.FloatingIpByName(contrailController.ApiClient, fipFQName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fip.GetFloatingIpPortMappings().PortMappings).To(Equal([]types.PortMap{
+					{Protocol: "tcp", SrcPort: 80, DstPort: 8080},
+				}))
+
+				err = contrailDriver.RevokeExternalConnectivity(
+					&network.RevokeExternalConnectivityRequest{
+						NetworkID:  dockerNetID,
+						EndpointID: endpointID,
+					})
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = types.FloatingIpByName(contrailController.ApiClient, fipFQName)
+				Expect(err).To(HaveOccurred())
+			})
+			It("doesn't allocate floating IP if no ports are published", func() {
+				err := contrailDriver.ProgramExternalConnectivity(
+					&network.ProgramExternalConnectivityRequest{
+						NetworkID:  dockerNetID,
+						EndpointID: endpointID,
+					})
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = types.FloatingIpByName(contrailController.ApiClient, fipFQName)
+				Expect(err).To(HaveOccurred())
+			})
 		})
 	})
 })
@@ -864,11 +1570,36 @@ func startDriver() (*ContrailDriver, *controller.Controller, *types.Project) {
 	} else {
 		c, p = controller.NewMockedClientAndProject(tenantName)
 	}
-	d := NewDriver(netAdapter, vswitchName, c)
+	d := NewDriver(netAdapter, vswitchName, network.LocalScope, c, newTestAgentQueue(),
+		newTestStore(), hns.WindowsService{})
+	d.AgentPortFiles = newTestPortFiles()
 
 	return d, c, p
 }
 
+// newTestAgentQueue returns queue of vRouter Agent stand-in started by startMockAgentListener.
+// Stand-in accepts only one request, so failed requests aren't retried.
+func newTestAgentQueue() *agent.Queue {
+	return agent.NewQueue(agent.NewClient(mockAgentURL, agent.DefaultTimeout), 0,
+		agent.DefaultBackoff)
+}
+
+// newTestStore returns an empty state store backed by a file in suite's temporary directory.
+func newTestStore() *state.Store {
+	dir, err := ioutil.TempDir(stateDir, "")
+	Expect(err).ToNot(HaveOccurred())
+	store, err := state.NewStore(filepath.Join(dir, common.StateFileName))
+	Expect(err).ToNot(HaveOccurred())
+	return store
+}
+
+// newTestPortFiles returns vRouter Agent port files kept in suite's temporary directory.
+func newTestPortFiles() *agent.PortFiles {
+	dir, err := ioutil.TempDir(stateDir, "ports")
+	Expect(err).ToNot(HaveOccurred())
+	return agent.NewPortFiles(dir)
+}
+
 func getDockerClient() *dockerClient.Client {
 	docker, err := dockerClient.NewEnvClient()
 	Expect(err).ToNot(HaveOccurred())
@@ -909,29 +1640,27 @@ func createValidDockerNetwork(docker *dockerClient.Client) string {
 }
 
 func createDockerNetwork(tenant, network string, docker *dockerClient.Client) string {
+	return createDockerNetworkWithOptions(tenant, network, map[string]string{}, docker)
+}
+
+func createDockerNetworkWithOptions(tenant, network string, options map[string]string,
+	docker *dockerClient.Client) string {
+	options["tenant"] = tenant
+	options["network"] = network
 	params := &dockerTypes.NetworkCreate{
 		Driver: common.DriverName,
 		IPAM: &dockerTypesNetwork.IPAM{
-			// libnetwork/ipams/windowsipam ("windows") driver is a null ipam driver.
-			// We use 0/32 subnet because no preferred address is specified (as documented in
-			// source code of windowsipam driver). We do this because our driver has to handle
-			// IP assignment.
-			// If container has IP before CreateEndpoint request is handled and CreateEndpoint
-			// returns a new IP (assigned by Contrail), docker daemon will complain that we cannot
-			// reassign IPs. Hence, we tell the IPAM driver to not assign any IPs.
-			Driver: "windows",
-			Config: []dockerTypesNetwork.IPAMConfig{
-				{
-					Subnet: "0.0.0.0/32",
-				},
+			// Contrail IPAM driver, served alongside the network driver, picks the subnet of
+			// Contrail network and allocates addresses in Contrail.
+			Driver: common.IpamDriverName,
+			Options: map[string]string{
+				"tenant":  tenant,
+				"network": network,
 			},
 		},
-		Options: map[string]string{
-			"tenant":  tenant,
-			"network": network,
-		},
+		Options: options,
 	}
-	resp, err := docker.NetworkCreate(context.Background(), networkName, *params)
+	resp, err := docker.NetworkCreate(context.Background(), network, *params)
 	Expect(err).ToNot(HaveOccurred())
 	return resp.ID
 }
@@ -972,7 +1701,7 @@ func deleteTheOnlyHNSEndpoint(d *ContrailDriver) {
 	Expect(err).ToNot(HaveOccurred())
 }
 
-func getTheOnlyHNSEndpoint(d *ContrailDriver) (*hcsshim.HNSEndpoint, string) {
+func getTheOnlyHNSEndpoint(d *ContrailDriver) (*hns.HNSEndpoint, string) {
 	hnsNets, err := contrailDriver.hnsMgr.ListNetworks()
 	Expect(err).ToNot(HaveOccurred())
 	Expect(hnsNets).To(HaveLen(1))
@@ -998,7 +1727,7 @@ func setupNetworksAndEndpoints(c *controller.Controller, docker *dockerClient.Cl
 func startMockAgentListener() *OneTimeListener {
 	listener := OneTimeListener{}
 	var err error
-	listener.Listener, err = net.Listen("tcp", ":9090") // agent api port
+	listener.Listener, err = net.Listen("tcp", mockAgentAddr)
 	Expect(err).ToNot(HaveOccurred())
 	Expect(listener.Listener).ToNot(BeNil())
 
@@ -1012,6 +1741,8 @@ func startMockAgentListener() *OneTimeListener {
 			log.Errorln("Failed to read request", err)
 		}
 		log.Debugln("Received message:", string(buf[:bytesRead]))
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+		conn.Close()
 		listener.Received <- 1
 		log.Debugln("Sent info about receiveing the request")
 		Expect(err).ToNot(HaveOccurred())
@@ -0,0 +1,405 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/controller"
+	dockerTypes "github.com/docker/docker/api/types"
+	dockerClient "github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of objects that garbage collection can find orphaned.
+const (
+	OrphanHNSEndpoint     = "HNS endpoint"
+	OrphanHNSNetwork      = "HNS network"
+	OrphanInterface       = "Contrail interface"
+	OrphanInstanceIP      = "Contrail instance IP"
+	OrphanInstance        = "Contrail instance"
+	OrphanDeletedEndpoint = "deleted endpoint"
+	OrphanStoredEndpoint  = "stored endpoint"
+	OrphanStoredNetwork   = "stored network"
+)
+
+// Orphan is an object created by the driver that no longer has an owner in docker.
+type Orphan struct {
+	Kind string
+	Name string
+	ID   string
+}
+
+func (o Orphan) String() string {
+	if o.Name == "" || o.Name == o.ID {
+		return fmt.Sprintf("%s %s", o.Kind, o.ID)
+	}
+	return fmt.Sprintf("%s %s (%s)", o.Kind, o.Name, o.ID)
+}
+
+// unboundInstanceIPGracePeriod is how old an instance IP that isn't bound to any interface must
+// be to be considered orphaned. Contrail networks can be shared by many hosts, whose IPAM
+// allocates instance IPs some time before their endpoints bind them.
+var unboundInstanceIPGracePeriod = 10 * time.Minute
+
+// dockerOwners are docker networks, endpoints and containers that currently exist. Networks
+// and endpoints are only those of the driver.
+type dockerOwners struct {
+	networks   map[string]NetworkMeta
+	endpoints  map[string]bool
+	containers []string
+}
+
+// CollectGarbage finds objects that the driver created, but whose docker networks or endpoints
+// are gone (for example because the service crashed in the middle of CreateEndpoint, or docker
+// never called DeleteEndpoint), and removes them. In dry run mode, orphans are only reported.
+// It must run before StartServing, so that docker can't create new objects in the meantime.
+func (d *ContrailDriver) CollectGarbage(dryRun bool) ([]Orphan, error) {
+	owners, err := d.dockerOwners()
+	if err != nil {
+		return nil, err
+	}
+
+	orphans, err := d.findOrphans(owners)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		for _, o := range orphans {
+			log.Infoln("Found orphaned", o)
+		}
+		return orphans, nil
+	}
+
+	var lastErr error
+	for _, o := range orphans {
+		log.Infoln("Removing orphaned", o)
+		if err := d.removeOrphan(o); err != nil {
+			log.Errorf("Failed to remove orphaned %s: %v", o, err)
+			lastErr = err
+		}
+	}
+	return orphans, lastErr
+}
+
+func (d *ContrailDriver) dockerOwners() (*dockerOwners, error) {
+	docker, err := dockerClient.NewEnvClient()
+	if err != nil {
+		return nil, err
+	}
+
+	netList, err := docker.NetworkList(context.Background(), dockerTypes.NetworkListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	owners := &dockerOwners{
+		networks:  make(map[string]NetworkMeta),
+		endpoints: make(map[string]bool),
+	}
+	for _, n := range netList {
+		if n.Driver != common.DriverName {
+			continue
+		}
+		// Network list doesn't contain endpoints, so every network has to be inspected.
+		dockerNetwork, err := docker.NetworkInspect(context.Background(), n.ID,
+			dockerTypes.NetworkInspectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range dockerNetwork.Containers {
+			owners.endpoints[c.EndpointID] = true
+		}
+		meta := NetworkMeta{
+			tenant:  dockerNetwork.Options["tenant"],
+			network: dockerNetwork.Options["network"],
+		}
+		for _, cfg := range dockerNetwork.IPAM.Config {
+			meta.subnetCIDRs = append(meta.subnetCIDRs, cfg.Subnet)
+		}
+		owners.networks[n.ID] = meta
+	}
+
+	containers, err := docker.ContainerList(context.Background(),
+		dockerTypes.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		owners.containers = append(owners.containers, c.ID)
+	}
+	return owners, nil
+}
+
+// findOrphans returns orphans in order in which they can be removed: endpoints before networks.
+func (d *ContrailDriver) findOrphans(owners *dockerOwners) ([]Orphan, error) {
+	var hnsEndpoints, interfaces, hnsNetworks, storedEndpoints, storedNetworks []Orphan
+	orphanedInterfaces := make(map[string]bool)
+	// Contrail networks of this host, by tenant and network name.
+	contrailNetworks := make(map[string]NetworkMeta)
+
+	// Contrail networks can be shared by many hosts, so only interfaces known to be created on
+	// this one (recorded in the store or backing a local HNS endpoint) are considered.
+	for _, ep := range d.store.ListEndpoints() {
+		if owners.endpoints[ep.ID] {
+			continue
+		}
+		storedEndpoints = append(storedEndpoints, Orphan{
+			Kind: OrphanStoredEndpoint,
+			ID:   ep.ID,
+		})
+		if ep.VMIUuid != "" && !orphanedInterfaces[ep.VMIUuid] {
+			orphanedInterfaces[ep.VMIUuid] = true
+			interfaces = append(interfaces, Orphan{
+				Kind: OrphanInterface,
+				ID:   ep.VMIUuid,
+			})
+		}
+	}
+	// Tombstones of endpoints deleted while their interfaces couldn't be removed.
+	for _, ep := range d.store.ListDeletedEndpoints() {
+		orphanedInterfaces[ep.VMIUuid] = true
+		interfaces = append(interfaces, Orphan{
+			Kind: OrphanDeletedEndpoint,
+			Name: ep.VMIUuid,
+			ID:   ep.ID,
+		})
+	}
+
+	hnsNets, err := d.hnsMgr.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	for _, hnsNet := range hnsNets {
+		// hnsManager.ListNetworks() already sanitizes network name
+		splitName := strings.SplitN(hnsNet.Name, ":", 4)
+		tenantName := splitName[1]
+		networkName := splitName[2]
+		contrailNetworks[tenantName+":"+networkName] = NetworkMeta{tenant: tenantName,
+			network: networkName}
+
+		hnsEps, err := d.hns.ListHNSEndpointsOfNetwork(hnsNet.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, ep := range hnsEps {
+			if owners.endpoints[ep.Name] {
+				continue
+			}
+			hnsEndpoints = append(hnsEndpoints, Orphan{
+				Kind: OrphanHNSEndpoint,
+				Name: ep.Name,
+				ID:   ep.Id,
+			})
+
+			vifFQName := fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName,
+				controller.InterfaceName(networkName, ep.Name))
+			vifUuid, err := d.controller.ApiClient.UuidByName("virtual-machine-interface",
+				vifFQName)
+			if err != nil {
+				log.Debugf("Orphaned HNS endpoint %s has no Contrail interface: %v", ep.Name,
+					err)
+				continue
+			}
+			if !orphanedInterfaces[vifUuid] {
+				orphanedInterfaces[vifUuid] = true
+				interfaces = append(interfaces, Orphan{
+					Kind: OrphanInterface,
+					Name: vifFQName,
+					ID:   vifUuid,
+				})
+			}
+		}
+
+		if !d.hnsNetworkOwned(hnsNet.Id, tenantName, networkName, owners) {
+			hnsNetworks = append(hnsNetworks, Orphan{
+				Kind: OrphanHNSNetwork,
+				Name: hnsNet.Name,
+				ID:   hnsNet.Id,
+			})
+		}
+	}
+
+	for _, n := range d.store.ListNetworks() {
+		contrailNetworks[n.Tenant+":"+n.Network] = NetworkMeta{tenant: n.Tenant,
+			network: n.Network}
+		if _, exists := owners.networks[n.ID]; !exists {
+			storedNetworks = append(storedNetworks, Orphan{
+				Kind: OrphanStoredNetwork,
+				Name: fmt.Sprintf("%s:%s", n.Tenant, n.Network),
+				ID:   n.ID,
+			})
+		}
+	}
+
+	instanceIPs, err := d.findUnboundInstanceIPs(contrailNetworks)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := d.findInstancesWithoutInterfaces(owners)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+	orphans = append(orphans, hnsEndpoints...)
+	orphans = append(orphans, interfaces...)
+	orphans = append(orphans, instanceIPs...)
+	orphans = append(orphans, instances...)
+	orphans = append(orphans, hnsNetworks...)
+	orphans = append(orphans, storedEndpoints...)
+	orphans = append(orphans, storedNetworks...)
+	return orphans, nil
+}
+
+// findUnboundInstanceIPs finds instance IPs that IPAM allocated in Contrail networks of this host,
+// but that were never bound to interfaces, for example because the service crashed between
+// RequestAddress and CreateEndpoint.
+func (d *ContrailDriver) findUnboundInstanceIPs(contrailNetworks map[string]NetworkMeta) (
+	[]Orphan, error) {
+	var orphans []Orphan
+	createdBefore := time.Now().Add(-unboundInstanceIPGracePeriod)
+	for _, meta := range contrailNetworks {
+		contrailNetwork, err := d.controller.GetNetwork(meta.tenant, meta.network)
+		if err != nil {
+			log.Debugf("Skipping instance IPs of Contrail network %s:%s: %v", meta.tenant,
+				meta.network, err)
+			continue
+		}
+		instIps, err := d.controller.ListUnboundInstanceIps(contrailNetwork, createdBefore)
+		if err != nil {
+			return nil, err
+		}
+		for _, instIp := range instIps {
+			orphans = append(orphans, Orphan{
+				Kind: OrphanInstanceIP,
+				Name: instIp.GetInstanceIpAddress(),
+				ID:   instIp.GetUuid(),
+			})
+		}
+	}
+	return orphans, nil
+}
+
+// findInstancesWithoutInterfaces finds Contrail instances of this host's containers that have no
+// interfaces left, for example because the service crashed before it attached an interface to
+// a newly created instance. Instances are named by container IDs; instances of containers that
+// are gone are only known from the store.
+func (d *ContrailDriver) findInstancesWithoutInterfaces(owners *dockerOwners) ([]Orphan, error) {
+	candidates := make(map[string]bool)
+	for _, ep := range append(d.store.ListEndpoints(), d.store.ListDeletedEndpoints()...) {
+		if ep.VMUuid != "" {
+			candidates[ep.VMUuid] = true
+		}
+	}
+	for _, containerID := range owners.containers {
+		vmUuid, err := d.controller.ApiClient.UuidByName("virtual-machine", containerID)
+		if err != nil {
+			continue
+		}
+		candidates[vmUuid] = true
+	}
+
+	var orphans []Orphan
+	for vmUuid := range candidates {
+		instance, err := types.VirtualMachineByUuid(d.controller.ApiClient, vmUuid)
+		if err != nil {
+			log.Debugf("Instance %s wasn't found: %v", vmUuid, err)
+			continue
+		}
+		ifaceRefs, err := instance.GetVirtualMachineInterfaceBackRefs()
+		if err != nil {
+			return nil, err
+		}
+		if len(ifaceRefs) == 0 {
+			orphans = append(orphans, Orphan{
+				Kind: OrphanInstance,
+				Name: instance.GetName(),
+				ID:   vmUuid,
+			})
+		}
+	}
+	return orphans, nil
+}
+
+// hnsNetworkOwned checks if HNS network belongs to any of docker networks. Networks that aren't
+// recorded in the store are matched by Contrail tenant and network only, because their subnets
+// can't be resolved reliably if Contrail is unreachable, and it's better to leave a stale HNS
+// network than to remove one that is in use.
+func (d *ContrailDriver) hnsNetworkOwned(hnsNetID, tenantName, networkName string,
+	owners *dockerOwners) bool {
+	for dockerNetID, meta := range owners.networks {
+		if storedNet := d.store.GetNetwork(dockerNetID); storedNet != nil {
+			if storedNet.HNSID == hnsNetID {
+				return true
+			}
+			continue
+		}
+		if meta.tenant == tenantName && meta.network == networkName {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *ContrailDriver) removeOrphan(o Orphan) error {
+	switch o.Kind {
+	case OrphanHNSEndpoint:
+		return d.hns.DeleteHNSEndpoint(o.ID)
+	case OrphanInterface:
+		if err := <-d.removeAgentPort(o.ID); err != nil {
+			log.Warnf("Failed to remove port %s from vRouter agent: %v", o.ID, err)
+		}
+		contrailVif, err := d.controller.GetInterfaceByUuid(o.ID)
+		if err != nil {
+			return err
+		}
+		// virtual-machine is removed together with its last interface
+		return d.controller.DeleteInterface(contrailVif)
+	case OrphanInstanceIP:
+		instIp, err := types.InstanceIpByUuid(d.controller.ApiClient, o.ID)
+		if err != nil {
+			return err
+		}
+		return d.controller.DeleteElementRecursive(instIp)
+	case OrphanInstance:
+		instance, err := types.VirtualMachineByUuid(d.controller.ApiClient, o.ID)
+		if err != nil {
+			return err
+		}
+		return d.controller.DeleteElementRecursive(instance)
+	case OrphanDeletedEndpoint:
+		for _, deletedEp := range d.store.ListDeletedEndpoints() {
+			if deletedEp.ID == o.ID {
+				return d.completeDeletion(deletedEp)
+			}
+		}
+		return nil
+	case OrphanHNSNetwork:
+		return d.hns.DeleteHNSNetwork(o.ID)
+	case OrphanStoredEndpoint:
+		return d.store.RemoveEndpoint(o.ID)
+	case OrphanStoredNetwork:
+		return d.store.RemoveNetwork(o.ID)
+	}
+	return fmt.Errorf("Unknown kind of orphan: %s", o.Kind)
+}
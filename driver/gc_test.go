@@ -0,0 +1,289 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/codilime/contrail-windows-docker/hns"
+	"github.com/codilime/contrail-windows-docker/state"
+	dockerClient "github.com/docker/docker/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Garbage collection", func() {
+
+	var docker *dockerClient.Client
+	var contrailNet *types.VirtualNetwork
+	var dockerNetID string
+	var hnsNet *hns.HNSNetwork
+
+	BeforeEach(func() {
+		contrailDriver, contrailController, project = startDriver()
+
+		err := contrailDriver.StartServing()
+		Expect(err).ToNot(HaveOccurred())
+
+		docker = getDockerClient()
+		contrailNet = createContrailNetwork(contrailController)
+		dockerNetID = createValidDockerNetwork(docker)
+
+		hnsNet, err = contrailDriver.hnsMgr.GetNetwork(tenantName, networkName,
+			[]string{subnetCIDR})
+		Expect(err).ToNot(HaveOccurred())
+
+		// Like on service startup, docker can't call the driver while it collects garbage.
+		err = contrailDriver.StopServing()
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		if contrailDriver.IsServing {
+			err := contrailDriver.StopServing()
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		cleanupAll()
+	})
+
+	orphanKinds := func(orphans []Orphan) []string {
+		var kinds []string
+		for _, o := range orphans {
+			kinds = append(kinds, o.Kind)
+		}
+		return kinds
+	}
+
+	It("finds nothing when docker, HNS and Contrail are in sync", func() {
+		orphans, err := contrailDriver.CollectGarbage(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(BeEmpty())
+
+		_, err = hns.GetHNSNetwork(hnsNet.Id)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("HNS endpoint has no docker endpoint", func() {
+		var hnsEndpointID string
+
+		BeforeEach(func() {
+			hnsEndpointID = hns.MockHNSEndpoint(hnsNet.Id)
+		})
+
+		It("only reports it in dry run mode", func() {
+			orphans, err := contrailDriver.CollectGarbage(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphans).To(ConsistOf(Orphan{
+				Kind: OrphanHNSEndpoint,
+				ID:   hnsEndpointID,
+			}))
+
+			_, err = hns.GetHNSEndpoint(hnsEndpointID)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("removes it, but keeps HNS network of docker network", func() {
+			_, err := contrailDriver.CollectGarbage(false)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = hns.GetHNSEndpoint(hnsEndpointID)
+			Expect(err).To(HaveOccurred())
+			_, err = hns.GetHNSNetwork(hnsNet.Id)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("stored endpoint has no docker endpoint", func() {
+		const endpointID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		var vifName string
+
+		BeforeEach(func() {
+			vifName = controller.InterfaceName(networkName, endpointID)
+			contrailVif, err := contrailController.GetOrCreateInterface(contrailNet, tenantName,
+				vifName)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = contrailDriver.store.SaveEndpoint(state.Endpoint{
+				ID:        endpointID,
+				NetworkID: dockerNetID,
+				VMIUuid:   contrailVif.GetUuid(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("removes its Contrail interface and the record", func() {
+			orphans, err := contrailDriver.CollectGarbage(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphanKinds(orphans)).To(ConsistOf(OrphanInterface, OrphanStoredEndpoint))
+
+			_, err = types.VirtualMachineInterfaceByName(contrailController.ApiClient,
+				fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vifName))
+			Expect(err).To(HaveOccurred())
+			Expect(contrailDriver.store.GetEndpoint(endpointID)).To(BeNil())
+		})
+	})
+
+	Context("instance IP allocated by IPAM was never bound to an interface", func() {
+		var savedGracePeriod time.Duration
+		var instIpUuid string
+
+		BeforeEach(func() {
+			savedGracePeriod = unboundInstanceIPGracePeriod
+			unboundInstanceIPGracePeriod = 0
+
+			subnet, err := contrailController.GetIpamSubnet(contrailNet, subnetCIDR)
+			Expect(err).ToNot(HaveOccurred())
+			instIp, err := contrailController.AllocateInstanceIp(contrailNet, subnet, "")
+			Expect(err).ToNot(HaveOccurred())
+			instIpUuid = instIp.GetUuid()
+		})
+		AfterEach(func() {
+			unboundInstanceIPGracePeriod = savedGracePeriod
+		})
+
+		It("removes it", func() {
+			orphans, err := contrailDriver.CollectGarbage(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphanKinds(orphans)).To(ConsistOf(OrphanInstanceIP))
+
+			_, err = types.InstanceIpByUuid(contrailController.ApiClient, instIpUuid)
+			Expect(err).To(HaveOccurred())
+		})
+		It("keeps it if it's younger than grace period", func() {
+			if !useActualController {
+				Skip("Mocked Contrail API doesn't record creation time")
+			}
+			unboundInstanceIPGracePeriod = time.Hour
+			orphans, err := contrailDriver.CollectGarbage(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphans).To(BeEmpty())
+		})
+	})
+
+	Context("stored endpoint has no docker endpoint and its instance has no interfaces", func() {
+		const endpointID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		var instance *types.VirtualMachine
+
+		BeforeEach(func() {
+			instance = &types.VirtualMachine{}
+			instance.SetName("gc_container")
+			Expect(contrailController.ApiClient.Create(instance)).To(Succeed())
+
+			err := contrailDriver.store.SaveEndpoint(state.Endpoint{
+				ID:        endpointID,
+				NetworkID: dockerNetID,
+				VMUuid:    instance.GetUuid(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("removes the instance and the record", func() {
+			orphans, err := contrailDriver.CollectGarbage(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphanKinds(orphans)).To(ConsistOf(OrphanInstance, OrphanStoredEndpoint))
+
+			_, err = types.VirtualMachineByUuid(contrailController.ApiClient, instance.GetUuid())
+			Expect(err).To(HaveOccurred())
+			Expect(contrailDriver.store.GetEndpoint(endpointID)).To(BeNil())
+		})
+	})
+
+	Context("endpoint was deleted while its Contrail interface couldn't be removed", func() {
+		const endpointID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		var vifName string
+
+		BeforeEach(func() {
+			vifName = controller.InterfaceName(networkName, endpointID)
+			contrailVif, err := contrailController.GetOrCreateInterface(contrailNet, tenantName,
+				vifName)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = contrailDriver.store.SaveEndpoint(state.Endpoint{
+				ID:        endpointID,
+				NetworkID: dockerNetID,
+				VMIUuid:   contrailVif.GetUuid(),
+				Deleted:   true,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("removes its Contrail interface and the tombstone", func() {
+			orphans, err := contrailDriver.CollectGarbage(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphanKinds(orphans)).To(ConsistOf(OrphanDeletedEndpoint))
+
+			_, err = types.VirtualMachineInterfaceByName(contrailController.ApiClient,
+				fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, vifName))
+			Expect(err).To(HaveOccurred())
+			Expect(contrailDriver.store.ListDeletedEndpoints()).To(BeEmpty())
+		})
+	})
+
+	Context("docker network is gone", func() {
+		BeforeEach(func() {
+			err := contrailDriver.StartServing()
+			Expect(err).ToNot(HaveOccurred())
+			err = removeDockerNetwork(docker, dockerNetID)
+			Expect(err).ToNot(HaveOccurred())
+			err = contrailDriver.StopServing()
+			Expect(err).ToNot(HaveOccurred())
+
+			// Leak HNS network and its record, as if the service crashed during DeleteNetwork.
+			hnsNet, err = contrailDriver.hnsMgr.CreateNetwork(contrailDriver.networkAdapter,
+				tenantName, networkName, []hns.Subnet{
+					{
+						AddressPrefix:  subnetCIDR,
+						GatewayAddress: defaultGW,
+					},
+				})
+			Expect(err).ToNot(HaveOccurred())
+			err = contrailDriver.store.SaveNetwork(state.Network{
+				ID:          dockerNetID,
+				Tenant:      tenantName,
+				Network:     networkName,
+				SubnetCIDRs: []string{subnetCIDR},
+				HNSID:       hnsNet.Id,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("removes its HNS network and the record", func() {
+			orphans, err := contrailDriver.CollectGarbage(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(orphanKinds(orphans)).To(ConsistOf(OrphanHNSNetwork, OrphanStoredNetwork))
+
+			nets, err := contrailDriver.hnsMgr.ListNetworks()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nets).To(BeEmpty())
+			Expect(contrailDriver.store.GetNetwork(dockerNetID)).To(BeNil())
+		})
+		It("doesn't remove Contrail network", func() {
+			_, err := contrailDriver.CollectGarbage(false)
+			Expect(err).ToNot(HaveOccurred())
+
+			net, err := types.VirtualNetworkByName(contrailController.ApiClient,
+				fmt.Sprintf("%s:%s:%s", common.DomainName, tenantName, networkName))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(net).ToNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,412 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/agent"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/codilime/contrail-windows-docker/hns"
+	"github.com/codilime/contrail-windows-docker/state"
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/go-plugins-helpers/network"
+	"github.com/docker/libnetwork/netlabel"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// These tests run on any OS: HNS is faked, Contrail is mocked, and docker and vRouter Agent
+// are stood in for by HTTP servers.
+var _ = Describe("Contrail Network Driver handlers", func() {
+
+	const (
+		tenant      = "handlers_tenant"
+		netName     = "handlers_net"
+		cidr        = "10.20.30.0/24"
+		gateway     = "10.20.30.1"
+		dockerNetID = "handlers_docker_net"
+		endpointID  = "handlers_endpoint"
+		containerID = "handlers_container"
+	)
+
+	var tempDir string
+	var fakeDocker, fakeAgent *httptest.Server
+	var oldDockerHost string
+	var hadDockerHost bool
+	var containers []dockerTypes.Container
+	var agentMutex sync.Mutex
+	var agentRequests []string
+	var agentStatus int
+	var hnsService *hns.FakeService
+	var store *state.Store
+	var c *controller.Controller
+	var project *types.Project
+	var d *ContrailDriver
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "driver_handlers")
+		Expect(err).ToNot(HaveOccurred())
+
+		// Containers are listed in Join, to read their labels.
+		containers = []dockerTypes.Container{}
+		fakeDocker = httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/containers/json") {
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(containers)
+					return
+				}
+				http.NotFound(w, r)
+			}))
+		oldDockerHost, hadDockerHost = os.LookupEnv("DOCKER_HOST")
+		os.Setenv("DOCKER_HOST", "tcp://"+fakeDocker.Listener.Addr().String())
+
+		agentRequests = nil
+		agentStatus = http.StatusOK
+		fakeAgent = httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				agentMutex.Lock()
+				defer agentMutex.Unlock()
+				agentRequests = append(agentRequests, r.Method+" "+r.URL.Path)
+				w.WriteHeader(agentStatus)
+			}))
+
+		c, project = controller.NewMockedClientAndProject(tenant)
+		controller.CreateMockedNetworkWithSubnet(c.ApiClient, netName, cidr, project)
+
+		store, err = state.NewStore(filepath.Join(tempDir, common.StateFileName))
+		Expect(err).ToNot(HaveOccurred())
+		hnsService = hns.NewFakeService()
+		queue := agent.NewQueue(agent.NewClient(fakeAgent.URL, agent.DefaultTimeout), 0,
+			agent.DefaultBackoff)
+		d = NewDriver("Ethernet0", "Layered Ethernet0", network.LocalScope, c, queue, store,
+			hnsService)
+		d.AgentPortFiles = agent.NewPortFiles(filepath.Join(tempDir, "ports"))
+		d.RequireAgentPort = true
+	})
+
+	AfterEach(func() {
+		if hadDockerHost {
+			os.Setenv("DOCKER_HOST", oldDockerHost)
+		} else {
+			os.Unsetenv("DOCKER_HOST")
+		}
+		fakeDocker.Close()
+		fakeAgent.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	receivedAgentRequests := func() []string {
+		agentMutex.Lock()
+		defer agentMutex.Unlock()
+		return append([]string(nil), agentRequests...)
+	}
+
+	createNetwork := func() {
+		err := d.CreateNetwork(&network.CreateNetworkRequest{
+			NetworkID: dockerNetID,
+			Options: map[string]interface{}{
+				netlabel.GenericData: map[string]interface{}{
+					"tenant":  tenant,
+					"network": netName,
+				},
+			},
+			IPv4Data: []*network.IPAMData{
+				{
+					Pool: cidr,
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	createEndpoint := func() *network.CreateEndpointResponse {
+		resp, err := d.CreateEndpoint(&network.CreateEndpointRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+			Interface:  &network.EndpointInterface{},
+			Options:    map[string]interface{}{},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	join := func() *network.JoinResponse {
+		resp, err := d.Join(&network.JoinRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+			SandboxKey: containerID,
+			Options:    map[string]interface{}{},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	It("creates HNS network with subnets of Contrail network", func() {
+		createNetwork()
+
+		hnsNets, err := hnsService.ListHNSNetworks()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hnsNets).To(HaveLen(1))
+		Expect(hnsNets[0].Subnets).To(ConsistOf(hns.Subnet{
+			AddressPrefix:  cidr,
+			GatewayAddress: gateway,
+		}))
+
+		storedNet := store.GetNetwork(dockerNetID)
+		Expect(storedNet).ToNot(BeNil())
+		Expect(storedNet.HNSID).To(Equal(hnsNets[0].Id))
+		Expect(storedNet.SubnetCIDRs).To(Equal([]string{cidr}))
+	})
+
+	It("creates HNS endpoint with address and MAC allocated in Contrail", func() {
+		createNetwork()
+		resp := createEndpoint()
+
+		address, subnet, err := net.ParseCIDR(resp.Interface.Address)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(subnet.String()).To(Equal(cidr))
+		Expect(resp.Interface.MacAddress).ToNot(BeEmpty())
+
+		hnsEp, err := hnsService.GetHNSEndpointByName(endpointID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hnsEp).ToNot(BeNil())
+		Expect(hnsEp.IPAddress.Equal(address)).To(BeTrue())
+		Expect(hnsEp.GatewayAddress).To(Equal(gateway))
+		Expect(hnsEp.MacAddress).To(Equal(
+			strings.Replace(strings.ToUpper(resp.Interface.MacAddress), ":", "-", -1)))
+		Expect(hnsEp.VirtualNetwork).To(Equal(store.GetNetwork(dockerNetID).HNSID))
+
+		storedEp := store.GetEndpoint(endpointID)
+		Expect(storedEp).ToNot(BeNil())
+		Expect(storedEp.HNSEndpointID).To(Equal(hnsEp.Id))
+		Expect(storedEp.VMIUuid).ToNot(BeEmpty())
+	})
+
+	It("joins container to Contrail instance and adds its port to vRouter Agent", func() {
+		createNetwork()
+		createEndpoint()
+		resp := join()
+
+		Expect(resp.Gateway).To(Equal(gateway))
+		Expect(resp.DisableGatewayService).To(BeTrue())
+
+		storedEp := store.GetEndpoint(endpointID)
+		Expect(storedEp.ContainerID).To(Equal(containerID))
+		Expect(storedEp.VMUuid).ToNot(BeEmpty())
+
+		Expect(receivedAgentRequests()).To(Equal([]string{"POST /port"}))
+		_, err := os.Stat(filepath.Join(d.AgentPortFiles.Dir(), storedEp.VMIUuid))
+		Expect(err).ToNot(HaveOccurred())
+
+		info, err := d.EndpointInfo(&network.InfoRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Value["hnsid"]).To(Equal(storedEp.HNSEndpointID))
+		Expect(info.Value["vrouter_port"]).To(Equal(string(agent.PortOK)))
+
+		err = d.Leave(&network.LeaveRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("removes security groups of container's labels when join fails", func() {
+		labelGroup := controller.CreateMockedSecurityGroup(c.ApiClient, project, "label_sg")
+		containers = []dockerTypes.Container{
+			{
+				ID:     containerID,
+				Labels: map[string]string{securityGroupsLabel: "label_sg"},
+			},
+		}
+		createNetwork()
+		createEndpoint()
+		vifUuid := store.GetEndpoint(endpointID).VMIUuid
+		securityGroupUuids := func() []string {
+			vif, err := types.VirtualMachineInterfaceByUuid(c.ApiClient, vifUuid)
+			Expect(err).ToNot(HaveOccurred())
+			refs, err := vif.GetSecurityGroupRefs()
+			Expect(err).ToNot(HaveOccurred())
+			var uuids []string
+			for _, ref := range refs {
+				uuids = append(uuids, ref.Uuid)
+			}
+			return uuids
+		}
+
+		agentMutex.Lock()
+		agentStatus = http.StatusInternalServerError
+		agentMutex.Unlock()
+		_, err := d.Join(&network.JoinRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+			SandboxKey: containerID,
+			Options:    map[string]interface{}{},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(securityGroupUuids()).To(BeEmpty())
+
+		By("security groups are added again when join is retried")
+		agentMutex.Lock()
+		agentStatus = http.StatusOK
+		agentMutex.Unlock()
+		join()
+		Expect(securityGroupUuids()).To(Equal([]string{labelGroup.GetUuid()}))
+	})
+
+	It("keeps instance of already joined endpoint when repeated join fails", func() {
+		createNetwork()
+		createEndpoint()
+		join()
+		storedEp := store.GetEndpoint(endpointID)
+
+		agentMutex.Lock()
+		agentStatus = http.StatusInternalServerError
+		agentMutex.Unlock()
+		_, err := d.Join(&network.JoinRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+			SandboxKey: containerID,
+			Options:    map[string]interface{}{},
+		})
+		Expect(err).To(HaveOccurred())
+
+		vif, err := types.VirtualMachineInterfaceByUuid(c.ApiClient, storedEp.VMIUuid)
+		Expect(err).ToNot(HaveOccurred())
+		vmRefs, err := vif.GetVirtualMachineRefs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmRefs).To(HaveLen(1))
+		Expect(vmRefs[0].Uuid).To(Equal(storedEp.VMUuid))
+	})
+
+	It("removes everything it created when endpoint and network are deleted", func() {
+		createNetwork()
+		createEndpoint()
+		join()
+		vifUuid := store.GetEndpoint(endpointID).VMIUuid
+
+		err := d.DeleteEndpoint(&network.DeleteEndpointRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		hnsEps, err := hnsService.ListHNSEndpoints()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hnsEps).To(BeEmpty())
+		Expect(store.GetEndpoint(endpointID)).To(BeNil())
+		_, err = os.Stat(filepath.Join(d.AgentPortFiles.Dir(), vifUuid))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Eventually(receivedAgentRequests).Should(ContainElement("DELETE /port/" + vifUuid))
+
+		err = d.DeleteNetwork(&network.DeleteNetworkRequest{
+			NetworkID: dockerNetID,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		hnsNets, err := hnsService.ListHNSNetworks()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hnsNets).To(BeEmpty())
+		Expect(store.GetNetwork(dockerNetID)).To(BeNil())
+	})
+
+	It("removes HNS endpoint when Contrail controller isn't connected", func() {
+		createNetwork()
+		createEndpoint()
+		join()
+		storedEp := store.GetEndpoint(endpointID)
+
+		d.controller = controller.NewDisconnectedController()
+		err := d.DeleteEndpoint(&network.DeleteEndpointRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		hnsEps, err := hnsService.ListHNSEndpoints()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hnsEps).To(BeEmpty())
+		Expect(store.GetEndpoint(endpointID)).To(BeNil())
+		_, err = os.Stat(filepath.Join(d.AgentPortFiles.Dir(), storedEp.VMIUuid))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Eventually(receivedAgentRequests).Should(ContainElement("DELETE /port/" +
+			storedEp.VMIUuid))
+
+		By("Contrail interface is left behind with endpoint's tombstone")
+		_, err = types.VirtualMachineInterfaceByUuid(c.ApiClient, storedEp.VMIUuid)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.ListDeletedEndpoints()).To(HaveLen(1))
+
+		By("Contrail interface and its instance are removed once connected")
+		d.controller = c
+		completed, err := d.CompleteDegradedJoins()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(completed).To(Equal(1))
+		Expect(store.ListDeletedEndpoints()).To(BeEmpty())
+		_, err = types.VirtualMachineInterfaceByUuid(c.ApiClient, storedEp.VMIUuid)
+		Expect(err).To(HaveOccurred())
+		_, err = types.VirtualMachineByUuid(c.ApiClient, storedEp.VMUuid)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("removes HNS endpoint even if Contrail network of endpoint can't be looked up", func() {
+		createNetwork()
+		createEndpoint()
+		// Endpoint and network that the driver doesn't remember are looked up in docker,
+		// which doesn't know them.
+		Expect(store.RemoveEndpoint(endpointID)).To(Succeed())
+		Expect(store.RemoveNetwork(dockerNetID)).To(Succeed())
+
+		err := d.DeleteEndpoint(&network.DeleteEndpointRequest{
+			NetworkID:  dockerNetID,
+			EndpointID: endpointID,
+		})
+		Expect(err).To(HaveOccurred())
+
+		hnsEps, err := hnsService.ListHNSEndpoints()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hnsEps).To(BeEmpty())
+	})
+
+	It("refuses to delete HNS network that still has endpoints", func() {
+		createNetwork()
+		createEndpoint()
+
+		err := d.DeleteNetwork(&network.DeleteNetworkRequest{
+			NetworkID: dockerNetID,
+		})
+		Expect(err).To(HaveOccurred())
+
+		hnsNets, err := hnsService.ListHNSNetworks()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hnsNets).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,399 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/agent"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/codilime/contrail-windows-docker/hnsManager"
+	dockerTypes "github.com/docker/docker/api/types"
+	dockerClient "github.com/docker/docker/client"
+)
+
+// States of container's port in vRouter Agent, as reported in EndpointView.
+const (
+	AgentPortAdded   = "added"
+	AgentPortMissing = "missing"
+)
+
+// NetworkView joins what docker, the state store, HNS and Contrail know about a network of the
+// driver. Problems describe where they disagree.
+type NetworkView struct {
+	DockerID     string   `json:"docker_id,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Tenant       string   `json:"tenant"`
+	Network      string   `json:"network"`
+	SubnetCIDRs  []string `json:"subnet_cidrs"`
+	Stored       bool     `json:"stored"`
+	HNSID        string   `json:"hns_id,omitempty"`
+	ContrailUuid string   `json:"contrail_uuid,omitempty"`
+	Problems     []string `json:"problems,omitempty"`
+}
+
+// EndpointView joins what docker, the state store, HNS, Contrail and vRouter Agent know about
+// an endpoint of the driver. Problems describe where they disagree.
+type EndpointView struct {
+	DockerID      string   `json:"docker_id"`
+	NetworkID     string   `json:"network_id,omitempty"`
+	Tenant        string   `json:"tenant,omitempty"`
+	Network       string   `json:"network,omitempty"`
+	ContainerID   string   `json:"container_id,omitempty"`
+	ContainerName string   `json:"container_name,omitempty"`
+	Stored        bool     `json:"stored"`
+	HNSEndpointID string   `json:"hns_endpoint_id,omitempty"`
+	IPAddress     string   `json:"ip_address,omitempty"`
+	MacAddress    string   `json:"mac_address,omitempty"`
+	VMIUuid       string   `json:"vmi_uuid,omitempty"`
+	VMUuid        string   `json:"vm_uuid,omitempty"`
+	AgentPort     string   `json:"agent_port,omitempty"`
+	Problems      []string `json:"problems,omitempty"`
+}
+
+// dockerState are docker networks of the driver and their endpoints.
+type dockerState struct {
+	networks  []dockerTypes.NetworkResource
+	endpoints map[string]dockerEndpoint
+}
+
+type dockerEndpoint struct {
+	networkID     string
+	containerID   string
+	containerName string
+}
+
+func (d *ContrailDriver) dockerState() (*dockerState, error) {
+	docker, err := dockerClient.NewEnvClient()
+	if err != nil {
+		return nil, err
+	}
+
+	netList, err := docker.NetworkList(context.Background(), dockerTypes.NetworkListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &dockerState{endpoints: make(map[string]dockerEndpoint)}
+	for _, n := range netList {
+		if n.Driver != common.DriverName {
+			continue
+		}
+		// Network list doesn't contain endpoints, so every network has to be inspected.
+		dockerNetwork, err := docker.NetworkInspect(context.Background(), n.ID,
+			dockerTypes.NetworkInspectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for containerID, c := range dockerNetwork.Containers {
+			s.endpoints[c.EndpointID] = dockerEndpoint{
+				networkID:     n.ID,
+				containerID:   containerID,
+				containerName: c.Name,
+			}
+		}
+		s.networks = append(s.networks, dockerNetwork)
+	}
+	return s, nil
+}
+
+// networkKey identifies network by Contrail tenant, network and subnets, which is all that
+// docker, the store and HNS have in common.
+func networkKey(tenant, network string, subnetCIDRs []string) string {
+	sorted := append([]string(nil), subnetCIDRs...)
+	sort.Strings(sorted)
+	return fmt.Sprintf("%s:%s:%s", tenant, network, strings.Join(sorted, ","))
+}
+
+// InspectNetworks returns views of all networks known to docker, the store or HNS. Contrail is
+// queried for every one of them; its failures are reported as problems.
+func (d *ContrailDriver) InspectNetworks() ([]NetworkView, error) {
+	docker, err := d.dockerState()
+	if err != nil {
+		return nil, err
+	}
+	hnsNets, err := d.hnsMgr.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	hnsIDs := make(map[string]bool)
+	views := make(map[string]*NetworkView)
+	view := func(tenant, network string, subnetCIDRs []string) *NetworkView {
+		key := networkKey(tenant, network, subnetCIDRs)
+		if v, exists := views[key]; exists {
+			return v
+		}
+		keys = append(keys, key)
+		views[key] = &NetworkView{
+			Tenant:      tenant,
+			Network:     network,
+			SubnetCIDRs: subnetCIDRs,
+		}
+		return views[key]
+	}
+
+	for _, n := range docker.networks {
+		var subnetCIDRs []string
+		for _, cfg := range n.IPAM.Config {
+			subnetCIDRs = append(subnetCIDRs, cfg.Subnet)
+		}
+		v := view(n.Options["tenant"], n.Options["network"], subnetCIDRs)
+		v.DockerID = n.ID
+		v.Name = n.Name
+	}
+	for _, n := range d.store.ListNetworks() {
+		v := view(n.Tenant, n.Network, n.SubnetCIDRs)
+		v.Stored = true
+		v.HNSID = n.HNSID
+		v.ContrailUuid = n.ContrailUuid
+		if v.DockerID == "" {
+			v.DockerID = n.ID
+		}
+	}
+	for _, hnsNet := range hnsNets {
+		// hnsManager.ListNetworks() already sanitizes network name
+		splitName := strings.SplitN(hnsNet.Name, ":", 4)
+		subnetCIDRs, err := hnsManager.SubnetCIDRsFromNetName(hnsNet.Name)
+		if err != nil {
+			return nil, err
+		}
+		v := view(splitName[1], splitName[2], subnetCIDRs)
+		if v.HNSID != "" && v.HNSID != hnsNet.Id {
+			v.Problems = append(v.Problems, fmt.Sprintf("stored HNS network %s, but found %s",
+				v.HNSID, hnsNet.Id))
+		}
+		v.HNSID = hnsNet.Id
+		hnsIDs[hnsNet.Id] = true
+	}
+
+	var result []NetworkView
+	for _, key := range keys {
+		v := views[key]
+		d.inspectContrailNetwork(v)
+		if !dockerHasNetwork(docker, v.DockerID) {
+			v.Problems = append(v.Problems, "no docker network")
+		}
+		if !v.Stored {
+			v.Problems = append(v.Problems, "not recorded in state file")
+		}
+		if !hnsIDs[v.HNSID] {
+			v.Problems = append(v.Problems, "no HNS network")
+		}
+		result = append(result, *v)
+	}
+	return result, nil
+}
+
+func dockerHasNetwork(docker *dockerState, id string) bool {
+	for _, n := range docker.networks {
+		if n.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *ContrailDriver) inspectContrailNetwork(v *NetworkView) {
+	contrailNetwork, err := d.controller.GetNetwork(v.Tenant, v.Network)
+	if err != nil {
+		v.Problems = append(v.Problems, fmt.Sprintf("Contrail network: %v", err))
+		return
+	}
+	if v.ContrailUuid != "" && v.ContrailUuid != contrailNetwork.GetUuid() {
+		v.Problems = append(v.Problems, fmt.Sprintf(
+			"stored Contrail network %s, but found %s", v.ContrailUuid,
+			contrailNetwork.GetUuid()))
+	}
+	v.ContrailUuid = contrailNetwork.GetUuid()
+}
+
+// InspectEndpoints returns views of all endpoints known to docker, the store or HNS. Contrail is
+// queried for every one of them; its failures are reported as problems. If agentClient isn't
+// nil, vRouter Agent is asked whether it has ports of the endpoints.
+func (d *ContrailDriver) InspectEndpoints(agentClient *agent.Client) ([]EndpointView, error) {
+	docker, err := d.dockerState()
+	if err != nil {
+		return nil, err
+	}
+	hnsNets, err := d.hnsMgr.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	views := make(map[string]*EndpointView)
+	view := func(id string) *EndpointView {
+		if v, exists := views[id]; exists {
+			return v
+		}
+		ids = append(ids, id)
+		views[id] = &EndpointView{DockerID: id}
+		return views[id]
+	}
+
+	for id, ep := range docker.endpoints {
+		v := view(id)
+		v.NetworkID = ep.networkID
+		v.ContainerID = ep.containerID
+		v.ContainerName = ep.containerName
+		for _, n := range docker.networks {
+			if n.ID == ep.networkID {
+				v.Tenant = n.Options["tenant"]
+				v.Network = n.Options["network"]
+			}
+		}
+	}
+	for _, ep := range d.store.ListEndpoints() {
+		v := view(ep.ID)
+		v.Stored = true
+		v.NetworkID = ep.NetworkID
+		v.HNSEndpointID = ep.HNSEndpointID
+		v.VMIUuid = ep.VMIUuid
+		v.VMUuid = ep.VMUuid
+		if v.ContainerID == "" {
+			v.ContainerID = ep.ContainerID
+		}
+		if n := d.store.GetNetwork(ep.NetworkID); n != nil && v.Tenant == "" {
+			v.Tenant = n.Tenant
+			v.Network = n.Network
+		}
+	}
+	for _, hnsNet := range hnsNets {
+		// hnsManager.ListNetworks() already sanitizes network name
+		splitName := strings.SplitN(hnsNet.Name, ":", 4)
+		hnsEps, err := d.hns.ListHNSEndpointsOfNetwork(hnsNet.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, hnsEp := range hnsEps {
+			// HNS endpoints are named by docker endpoint ID
+			v := view(hnsEp.Name)
+			if v.HNSEndpointID != "" && v.HNSEndpointID != hnsEp.Id {
+				v.Problems = append(v.Problems, fmt.Sprintf(
+					"stored HNS endpoint %s, but found %s", v.HNSEndpointID, hnsEp.Id))
+			}
+			v.HNSEndpointID = hnsEp.Id
+			v.IPAddress = hnsEp.IPAddress.String()
+			v.MacAddress = hnsEp.MacAddress
+			if v.Tenant == "" {
+				v.Tenant = splitName[1]
+				v.Network = splitName[2]
+			}
+		}
+	}
+
+	var result []EndpointView
+	for _, id := range ids {
+		v := views[id]
+		if _, exists := docker.endpoints[id]; !exists {
+			v.Problems = append(v.Problems, "no docker endpoint")
+		}
+		if !v.Stored {
+			v.Problems = append(v.Problems, "not recorded in state file")
+		}
+		if v.IPAddress == "" {
+			v.Problems = append(v.Problems, "no HNS endpoint")
+		}
+		d.inspectContrailInterface(v)
+		if agentClient != nil && v.VMIUuid != "" {
+			inspectAgentPort(v, agentClient)
+		}
+		result = append(result, *v)
+	}
+	return result, nil
+}
+
+func (d *ContrailDriver) inspectContrailInterface(v *EndpointView) {
+	if v.Tenant == "" {
+		v.Problems = append(v.Problems, "unknown Contrail network")
+		return
+	}
+	fqName := fmt.Sprintf("%s:%s:%s", common.DomainName, v.Tenant,
+		controller.InterfaceName(v.Network, v.DockerID))
+	contrailVif, err := types.VirtualMachineInterfaceByName(d.controller.ApiClient, fqName)
+	if err != nil {
+		v.Problems = append(v.Problems, fmt.Sprintf("Contrail interface: %v", err))
+		return
+	}
+	if v.VMIUuid != "" && v.VMIUuid != contrailVif.GetUuid() {
+		v.Problems = append(v.Problems, fmt.Sprintf(
+			"stored Contrail interface %s, but found %s", v.VMIUuid, contrailVif.GetUuid()))
+	}
+	v.VMIUuid = contrailVif.GetUuid()
+
+	vmRefs, err := contrailVif.GetVirtualMachineRefs()
+	if err != nil {
+		v.Problems = append(v.Problems, fmt.Sprintf("Contrail virtual machine: %v", err))
+		return
+	}
+	if len(vmRefs) > 0 {
+		v.VMUuid = vmRefs[0].Uuid
+	} else if v.ContainerID != "" {
+		v.Problems = append(v.Problems, "Contrail interface has no virtual machine")
+	}
+}
+
+func inspectAgentPort(v *EndpointView, agentClient *agent.Client) {
+	err := agentClient.GetPort(v.VMIUuid)
+	switch {
+	case err == nil:
+		v.AgentPort = AgentPortAdded
+	case agent.IsNotFound(err):
+		v.AgentPort = AgentPortMissing
+		// Ports are only added to agent when endpoint joins a container.
+		if v.ContainerID != "" {
+			v.Problems = append(v.Problems, "no vRouter Agent port")
+		}
+	default:
+		v.Problems = append(v.Problems, fmt.Sprintf("vRouter Agent port: %v", err))
+	}
+}
+
+// InspectEndpoint returns view of endpoint whose docker endpoint ID, container ID or HNS endpoint
+// ID starts with id.
+func (d *ContrailDriver) InspectEndpoint(id string, agentClient *agent.Client) (*EndpointView,
+	error) {
+	if id == "" {
+		return nil, fmt.Errorf("Endpoint ID is empty")
+	}
+	views, err := d.InspectEndpoints(agentClient)
+	if err != nil {
+		return nil, err
+	}
+	var found []EndpointView
+	for _, v := range views {
+		for _, candidate := range []string{v.DockerID, v.ContainerID, v.HNSEndpointID} {
+			if candidate != "" && strings.HasPrefix(candidate, id) {
+				found = append(found, v)
+				break
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("No endpoint matches %s", id)
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("%d endpoints match %s, use a longer ID", len(found), id)
+}
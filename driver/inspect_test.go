@@ -0,0 +1,99 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package driver
+
+import (
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/hns"
+	dockerClient "github.com/docker/docker/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Inspecting", func() {
+
+	var docker *dockerClient.Client
+	var contrailNet *types.VirtualNetwork
+	var dockerNetID string
+	var containerID string
+
+	BeforeEach(func() {
+		contrailDriver, contrailController, project = startDriver()
+
+		err := contrailDriver.StartServing()
+		Expect(err).ToNot(HaveOccurred())
+
+		docker = getDockerClient()
+		contrailNet, dockerNetID, containerID = setupNetworksAndEndpoints(contrailController,
+			docker)
+	})
+	AfterEach(func() {
+		if contrailDriver.IsServing {
+			err := contrailDriver.StopServing()
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		cleanupAll()
+	})
+
+	It("joins views of network when docker, HNS and Contrail are in sync", func() {
+		nets, err := contrailDriver.InspectNetworks()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nets).To(HaveLen(1))
+		Expect(nets[0].DockerID).To(Equal(dockerNetID))
+		Expect(nets[0].Stored).To(BeTrue())
+		Expect(nets[0].HNSID).ToNot(BeEmpty())
+		Expect(nets[0].ContrailUuid).To(Equal(contrailNet.GetUuid()))
+		Expect(nets[0].Problems).To(BeEmpty())
+	})
+
+	It("joins views of endpoint when docker, HNS and Contrail are in sync", func() {
+		eps, err := contrailDriver.InspectEndpoints(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(eps).To(HaveLen(1))
+		Expect(eps[0].NetworkID).To(Equal(dockerNetID))
+		Expect(eps[0].ContainerID).To(Equal(containerID))
+		Expect(eps[0].Stored).To(BeTrue())
+		Expect(eps[0].HNSEndpointID).ToNot(BeEmpty())
+		Expect(eps[0].VMIUuid).ToNot(BeEmpty())
+		Expect(eps[0].VMUuid).ToNot(BeEmpty())
+		Expect(eps[0].Problems).To(BeEmpty())
+	})
+
+	It("finds endpoint by prefix of container ID", func() {
+		ep, err := contrailDriver.InspectEndpoint(containerID[:12], nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ep.ContainerID).To(Equal(containerID))
+
+		_, err = contrailDriver.InspectEndpoint("no such endpoint", nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports HNS endpoint that docker doesn't know", func() {
+		hnsNet, err := contrailDriver.hnsMgr.GetNetwork(tenantName, networkName,
+			[]string{subnetCIDR})
+		Expect(err).ToNot(HaveOccurred())
+		hnsEndpointID := hns.MockHNSEndpoint(hnsNet.Id)
+
+		ep, err := contrailDriver.InspectEndpoint(hnsEndpointID, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ep.Problems).To(ContainElement("no docker endpoint"))
+		Expect(ep.Problems).To(ContainElement("not recorded in state file"))
+	})
+})
@@ -0,0 +1,230 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Implemented according to
+// https://github.com/docker/libnetwork/blob/master/docs/ipam.md
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/docker/go-plugins-helpers/ipam"
+	"github.com/docker/libnetwork/netlabel"
+	log "github.com/sirupsen/logrus"
+)
+
+// requestAddressTypeOption is the RequestAddress option that docker uses to tell what the
+// requested address will be used for. Defined in libnetwork/ipamapi.
+const requestAddressTypeOption = "RequestAddressType"
+
+// ContrailIpam is a libnetwork remote IPAM driver that allocates pools and addresses
+// directly from Contrail.
+type ContrailIpam struct {
+	controller *controller.Controller
+}
+
+// PoolMeta identifies Contrail subnet that is behind a docker address pool.
+type PoolMeta struct {
+	tenant     string
+	network    string
+	subnetCIDR string
+}
+
+func NewIpam(c *controller.Controller) *ContrailIpam {
+	return &ContrailIpam{
+		controller: c,
+	}
+}
+
+func (i *ContrailIpam) GetCapabilities() (*ipam.CapabilitiesResponse, error) {
+	log.Debugln("=== IPAM GetCapabilities")
+	r := &ipam.CapabilitiesResponse{
+		RequiresMACAddress: false,
+	}
+	return r, nil
+}
+
+func (i *ContrailIpam) GetDefaultAddressSpaces() (*ipam.AddressSpacesResponse, error) {
+	log.Debugln("=== IPAM GetDefaultAddressSpaces")
+	r := &ipam.AddressSpacesResponse{
+		LocalDefaultAddressSpace:  common.IpamLocalAddressSpace,
+		GlobalDefaultAddressSpace: common.IpamGlobalAddressSpace,
+	}
+	return r, nil
+}
+
+func (i *ContrailIpam) RequestPool(req *ipam.RequestPoolRequest) (*ipam.RequestPoolResponse,
+	error) {
+	log.Debugln("=== IPAM RequestPool")
+	log.Debugln(req)
+
+	if req.SubPool != "" {
+		return nil, errors.New("Sub-pools are not supported by Contrail IPAM")
+	}
+
+	tenant, exists := req.Options["tenant"]
+	if !exists {
+		return nil, errors.New("Tenant not specified in IPAM options")
+	}
+
+	netName, exists := req.Options["network"]
+	if !exists {
+		return nil, errors.New("Network name not specified in IPAM options")
+	}
+
+	contrailNetwork, err := i.controller.GetNetwork(tenant, netName)
+	if err != nil {
+		return nil, err
+	}
+
+	var contrailIpam *types.IpamSubnetType
+	if req.Pool != "" {
+		contrailIpam, err = i.controller.GetIpamSubnet(contrailNetwork, req.Pool)
+	} else {
+		// docker asks separately for IPv4 and IPv6 pool; pick the only subnet of the family
+		family := controller.IPv4Family
+		if req.V6 {
+			family = controller.IPv6Family
+		}
+		contrailIpam, err = i.controller.GetIpamSubnetOfFamily(contrailNetwork, family)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pool := PoolMeta{
+		tenant:     tenant,
+		network:    netName,
+		subnetCIDR: contrailSubnetCIDR(contrailIpam),
+	}
+
+	r := &ipam.RequestPoolResponse{
+		PoolID: pool.ID(),
+		Pool:   pool.subnetCIDR,
+		Data:   map[string]string{},
+	}
+	return r, nil
+}
+
+func (i *ContrailIpam) ReleasePool(req *ipam.ReleasePoolRequest) error {
+	log.Debugln("=== IPAM ReleasePool")
+	log.Debugln(req)
+	// Subnets are owned by Contrail, so there is nothing to release here.
+	return nil
+}
+
+func (i *ContrailIpam) RequestAddress(req *ipam.RequestAddressRequest) (
+	*ipam.RequestAddressResponse, error) {
+	log.Debugln("=== IPAM RequestAddress")
+	log.Debugln(req)
+
+	pool, err := poolMetaFromID(req.PoolID)
+	if err != nil {
+		return nil, err
+	}
+
+	contrailNetwork, err := i.controller.GetNetwork(pool.tenant, pool.network)
+	if err != nil {
+		return nil, err
+	}
+
+	contrailIpam, err := i.controller.GetIpamSubnet(contrailNetwork, pool.subnetCIDR)
+	if err != nil {
+		return nil, err
+	}
+	prefixLen := contrailIpam.Subnet.IpPrefixLen
+
+	if req.Options[requestAddressTypeOption] == netlabel.Gateway {
+		gw, err := i.controller.GetDefaultGatewayIp(contrailIpam)
+		if err != nil {
+			return nil, err
+		}
+		r := &ipam.RequestAddressResponse{
+			Address: fmt.Sprintf("%s/%v", gw, prefixLen),
+		}
+		return r, nil
+	}
+
+	contrailIP, err := i.controller.AllocateInstanceIp(contrailNetwork, contrailIpam, req.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ipam.RequestAddressResponse{
+		Address: fmt.Sprintf("%s/%v", contrailIP.GetInstanceIpAddress(), prefixLen),
+	}
+	return r, nil
+}
+
+func (i *ContrailIpam) ReleaseAddress(req *ipam.ReleaseAddressRequest) error {
+	log.Debugln("=== IPAM ReleaseAddress")
+	log.Debugln(req)
+
+	pool, err := poolMetaFromID(req.PoolID)
+	if err != nil {
+		return err
+	}
+
+	contrailNetwork, err := i.controller.GetNetwork(pool.tenant, pool.network)
+	if err != nil {
+		return err
+	}
+
+	contrailIpam, err := i.controller.GetIpamSubnet(contrailNetwork, pool.subnetCIDR)
+	if err != nil {
+		return err
+	}
+	if net.ParseIP(req.Address).Equal(net.ParseIP(contrailIpam.DefaultGateway)) {
+		// gateway is not an instance IP, it is managed by Contrail
+		return nil
+	}
+
+	contrailIP, err := i.controller.GetInstanceIpByAddress(contrailNetwork, req.Address)
+	if err != nil {
+		return err
+	}
+	if contrailIP == nil {
+		// Instance IP is removed together with its interface during DeleteEndpoint.
+		log.Infoln("When handling ReleaseAddress, instance IP was already removed")
+		return nil
+	}
+
+	return i.controller.DeleteElementRecursive(contrailIP)
+}
+
+// ID returns docker pool ID. Contrail FQName parts can't contain colons, so the last part
+// (subnet CIDR) can be safely split off, even if it's an IPv6 CIDR.
+func (p *PoolMeta) ID() string {
+	return fmt.Sprintf("%s:%s:%s", p.tenant, p.network, p.subnetCIDR)
+}
+
+func poolMetaFromID(poolID string) (*PoolMeta, error) {
+	split := strings.SplitN(poolID, ":", 3)
+	if len(split) != 3 {
+		return nil, fmt.Errorf("Malformed pool ID: %s", poolID)
+	}
+	return &PoolMeta{
+		tenant:     split[0],
+		network:    split[1],
+		subnetCIDR: split[2],
+	}, nil
+}
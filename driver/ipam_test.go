@@ -0,0 +1,177 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package driver
+
+import (
+	"net"
+
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/docker/go-plugins-helpers/ipam"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Contrail IPAM Driver", func() {
+
+	var contrailIpam *ContrailIpam
+
+	BeforeEach(func() {
+		contrailDriver, contrailController, project = startDriver()
+		contrailIpam = NewIpam(contrailController)
+	})
+
+	requestPool := func() *ipam.RequestPoolResponse {
+		resp, err := contrailIpam.RequestPool(&ipam.RequestPoolRequest{
+			Options: map[string]string{
+				"tenant":  tenantName,
+				"network": networkName,
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	It("returns Contrail address spaces", func() {
+		resp, err := contrailIpam.GetDefaultAddressSpaces()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.LocalDefaultAddressSpace).To(Equal(common.IpamLocalAddressSpace))
+		Expect(resp.GlobalDefaultAddressSpace).To(Equal(common.IpamGlobalAddressSpace))
+	})
+
+	Specify("pool ID can be parsed back, even for IPv6 subnets", func() {
+		pool := PoolMeta{
+			tenant:     tenantName,
+			network:    networkName,
+			subnetCIDR: "fd00::/64",
+		}
+		parsed, err := poolMetaFromID(pool.ID())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*parsed).To(Equal(pool))
+	})
+
+	Context("on RequestPool request", func() {
+		It("responds with err if Contrail network is not specified", func() {
+			_, err := contrailIpam.RequestPool(&ipam.RequestPoolRequest{
+				Options: map[string]string{"tenant": tenantName},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+		It("responds with err if Contrail network doesn't exist", func() {
+			_, err := contrailIpam.RequestPool(&ipam.RequestPoolRequest{
+				Options: map[string]string{
+					"tenant":  tenantName,
+					"network": "nonexistingNetwork",
+				},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+		It("responds with Contrail subnet", func() {
+			_ = createContrailNetwork(contrailController)
+			resp := requestPool()
+			Expect(resp.Pool).To(Equal(subnetCIDR))
+		})
+		It("responds with the only Contrail IPv6 subnet if asked for IPv6 pool", func() {
+			contrailNet := createContrailNetwork(contrailController)
+			controller.AddSubnetWithDefaultGateway(contrailController.ApiClient, "fd00:10::",
+				"fd00:10::1", 64, contrailNet)
+			resp, err := contrailIpam.RequestPool(&ipam.RequestPoolRequest{
+				Options: map[string]string{
+					"tenant":  tenantName,
+					"network": networkName,
+				},
+				V6: true,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.Pool).To(Equal("fd00:10::/64"))
+		})
+		It("responds with the only Contrail IPv4 subnet of dual-stack network", func() {
+			contrailNet := createContrailNetwork(contrailController)
+			controller.AddSubnetWithDefaultGateway(contrailController.ApiClient, "fd00:10::",
+				"fd00:10::1", 64, contrailNet)
+			resp := requestPool()
+			Expect(resp.Pool).To(Equal(subnetCIDR))
+		})
+		It("responds with err if asked for IPv6 pool of IPv4-only network", func() {
+			_ = createContrailNetwork(contrailController)
+			_, err := contrailIpam.RequestPool(&ipam.RequestPoolRequest{
+				Options: map[string]string{
+					"tenant":  tenantName,
+					"network": networkName,
+				},
+				V6: true,
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("on RequestAddress request", func() {
+
+		var poolID string
+
+		BeforeEach(func() {
+			_ = createContrailNetwork(contrailController)
+			poolID = requestPool().PoolID
+		})
+
+		It("allocates an instance IP in Contrail subnet", func() {
+			resp, err := contrailIpam.RequestAddress(&ipam.RequestAddressRequest{
+				PoolID: poolID,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			ip, _, err := net.ParseCIDR(resp.Address)
+			Expect(err).ToNot(HaveOccurred())
+			_, subnet, _ := net.ParseCIDR(subnetCIDR)
+			Expect(subnet.Contains(ip)).To(BeTrue())
+
+			contrailNet, err := contrailController.GetNetwork(tenantName, networkName)
+			Expect(err).ToNot(HaveOccurred())
+			instIP, err := contrailController.GetInstanceIpByAddress(contrailNet, ip.String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instIP).ToNot(BeNil())
+
+			By("releasing the address removes the instance IP")
+			err = contrailIpam.ReleaseAddress(&ipam.ReleaseAddressRequest{
+				PoolID:  poolID,
+				Address: ip.String(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			instIP, err = contrailController.GetInstanceIpByAddress(contrailNet, ip.String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instIP).To(BeNil())
+		})
+
+		It("responds with Contrail default gateway when asked for gateway", func() {
+			resp, err := contrailIpam.RequestAddress(&ipam.RequestAddressRequest{
+				PoolID:  poolID,
+				Options: map[string]string{requestAddressTypeOption: "com.docker.network.gateway"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.Address).To(Equal(defaultGW + "/24"))
+		})
+
+		It("responds with err on malformed pool ID", func() {
+			_, err := contrailIpam.RequestAddress(&ipam.RequestAddressRequest{
+				PoolID: "malformed",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,310 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"math"
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/hyperv"
+	"github.com/codilime/contrail-windows-docker/metrics"
+	"github.com/docker/go-plugins-helpers/ipam"
+	"github.com/docker/go-plugins-helpers/network"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	handlerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "handler_requests_total",
+		Help:      "Number of requests from docker, by handler.",
+	}, []string{"handler"})
+	handlerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "handler_errors_total",
+		Help:      "Number of requests from docker that failed, by handler.",
+	}, []string{"handler"})
+	handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Duration of handling requests from docker, by handler.",
+		Buckets:   metrics.DefaultBuckets,
+	}, []string{"handler"})
+)
+
+func init() {
+	metrics.DefaultRegistry.MustRegister(handlerRequests, handlerErrors, handlerDuration)
+}
+
+// observeHandler records request to handler that started at start. It's deferred with pointer
+// to named error result, so that the final error is seen.
+func observeHandler(handler string, start time.Time, err *error) {
+	handlerRequests.WithLabelValues(handler).Inc()
+	handlerDuration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		handlerErrors.WithLabelValues(handler).Inc()
+	}
+}
+
+// extensionPollInterval is how often state of vRouter Forwarding Extension is checked for
+// metrics. Checking it runs PowerShell, which is too slow to do on every scrape.
+const extensionPollInterval = 30 * time.Second
+
+// RegisterMetrics registers gauges of networks and endpoints managed by the driver, of
+// connection to Contrail controller and of vRouter Forwarding Extension state in registry.
+// Extension state is checked in the background until returned stop function is called.
+// Handler metrics are always registered in metrics.DefaultRegistry.
+func (d *ContrailDriver) RegisterMetrics(registry prometheus.Registerer) (stop func()) {
+	extension := newExtensionMetrics(func() (bool, bool, error) {
+		enabled, err := hyperv.IsExtensionEnabled(d.vswitchName)
+		if err != nil {
+			return false, false, err
+		}
+		running, err := hyperv.IsExtensionRunning(d.vswitchName)
+		return enabled, running, err
+	})
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "networks",
+			Help:      "Number of docker networks managed by the driver.",
+		}, func() float64 {
+			return float64(len(d.store.ListNetworks()))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "endpoints",
+			Help:      "Number of docker endpoints managed by the driver.",
+		}, func() float64 {
+			return float64(len(d.store.ListEndpoints()))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "controller_connected",
+			Help: "Whether the driver is connected to Contrail controller (1) or still " +
+				"connecting to it in the background (0).",
+		}, func() float64 {
+			return metrics.Bool(d.controller.IsConnected())
+		}),
+		extension.enabled,
+		extension.running,
+		extension.known,
+	)
+	extension.start(extensionPollInterval)
+	return extension.stop
+}
+
+// extensionMetrics keeps gauges of vRouter Forwarding Extension state, which is checked in the
+// background. When the check fails, state is unknown: enabled and running gauges are NaN and
+// known gauge is 0, so that failed check can't be mistaken for disabled extension.
+type extensionMetrics struct {
+	check   func() (enabled, running bool, err error)
+	enabled prometheus.Gauge
+	running prometheus.Gauge
+	known   prometheus.Gauge
+
+	stopChan    chan interface{}
+	stoppedChan chan interface{}
+}
+
+func newExtensionMetrics(check func() (enabled, running bool, err error)) *extensionMetrics {
+	m := &extensionMetrics{
+		check: check,
+		enabled: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "hyperv_extension_enabled",
+			Help: "Whether vRouter Forwarding Extension is enabled on the vswitch (1) or " +
+				"not (0). NaN if it's unknown.",
+		}),
+		running: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "hyperv_extension_running",
+			Help: "Whether vRouter Forwarding Extension is running on the vswitch (1) or " +
+				"not (0). NaN if it's unknown.",
+		}),
+		known: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "hyperv_extension_state_known",
+			Help: "Whether the last check of vRouter Forwarding Extension state succeeded " +
+				"(1) or failed or wasn't done yet (0).",
+		}),
+		stopChan:    make(chan interface{}),
+		stoppedChan: make(chan interface{}),
+	}
+	m.enabled.Set(math.NaN())
+	m.running.Set(math.NaN())
+	return m
+}
+
+// start checks extension state every interval in the background.
+func (m *extensionMetrics) start(interval time.Duration) {
+	go m.run(interval)
+}
+
+// stop stops checking extension state and waits for the check to return, if it's running.
+func (m *extensionMetrics) stop() {
+	close(m.stopChan)
+	<-m.stoppedChan
+}
+
+func (m *extensionMetrics) run(interval time.Duration) {
+	defer close(m.stoppedChan)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.update()
+		select {
+		case <-m.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *extensionMetrics) update() {
+	enabled, running, err := m.check()
+	if err != nil {
+		log.Warnln("Failed to check state of vRouter Forwarding Extension:", err)
+		m.enabled.Set(math.NaN())
+		m.running.Set(math.NaN())
+		m.known.Set(0)
+		return
+	}
+	m.enabled.Set(metrics.Bool(enabled))
+	m.running.Set(metrics.Bool(running))
+	m.known.Set(1)
+}
+
+// instrumentedDriver records metrics of every request from docker to the network driver.
+type instrumentedDriver struct {
+	d network.Driver
+}
+
+func (i instrumentedDriver) GetCapabilities() (_ *network.CapabilitiesResponse, err error) {
+	defer observeHandler("GetCapabilities", time.Now(), &err)
+	return i.d.GetCapabilities()
+}
+
+func (i instrumentedDriver) CreateNetwork(req *network.CreateNetworkRequest) (err error) {
+	defer observeHandler("CreateNetwork", time.Now(), &err)
+	return i.d.CreateNetwork(req)
+}
+
+func (i instrumentedDriver) AllocateNetwork(req *network.AllocateNetworkRequest) (
+	_ *network.AllocateNetworkResponse, err error) {
+	defer observeHandler("AllocateNetwork", time.Now(), &err)
+	return i.d.AllocateNetwork(req)
+}
+
+func (i instrumentedDriver) DeleteNetwork(req *network.DeleteNetworkRequest) (err error) {
+	defer observeHandler("DeleteNetwork", time.Now(), &err)
+	return i.d.DeleteNetwork(req)
+}
+
+func (i instrumentedDriver) FreeNetwork(req *network.FreeNetworkRequest) (err error) {
+	defer observeHandler("FreeNetwork", time.Now(), &err)
+	return i.d.FreeNetwork(req)
+}
+
+func (i instrumentedDriver) CreateEndpoint(req *network.CreateEndpointRequest) (
+	_ *network.CreateEndpointResponse, err error) {
+	defer observeHandler("CreateEndpoint", time.Now(), &err)
+	return i.d.CreateEndpoint(req)
+}
+
+func (i instrumentedDriver) DeleteEndpoint(req *network.DeleteEndpointRequest) (err error) {
+	defer observeHandler("DeleteEndpoint", time.Now(), &err)
+	return i.d.DeleteEndpoint(req)
+}
+
+func (i instrumentedDriver) EndpointInfo(req *network.InfoRequest) (_ *network.InfoResponse,
+	err error) {
+	defer observeHandler("EndpointInfo", time.Now(), &err)
+	return i.d.EndpointInfo(req)
+}
+
+func (i instrumentedDriver) Join(req *network.JoinRequest) (_ *network.JoinResponse,
+	err error) {
+	defer observeHandler("Join", time.Now(), &err)
+	return i.d.Join(req)
+}
+
+func (i instrumentedDriver) Leave(req *network.LeaveRequest) (err error) {
+	defer observeHandler("Leave", time.Now(), &err)
+	return i.d.Leave(req)
+}
+
+func (i instrumentedDriver) DiscoverNew(req *network.DiscoveryNotification) (err error) {
+	defer observeHandler("DiscoverNew", time.Now(), &err)
+	return i.d.DiscoverNew(req)
+}
+
+func (i instrumentedDriver) DiscoverDelete(req *network.DiscoveryNotification) (err error) {
+	defer observeHandler("DiscoverDelete", time.Now(), &err)
+	return i.d.DiscoverDelete(req)
+}
+
+func (i instrumentedDriver) ProgramExternalConnectivity(
+	req *network.ProgramExternalConnectivityRequest) (err error) {
+	defer observeHandler("ProgramExternalConnectivity", time.Now(), &err)
+	return i.d.ProgramExternalConnectivity(req)
+}
+
+func (i instrumentedDriver) RevokeExternalConnectivity(
+	req *network.RevokeExternalConnectivityRequest) (err error) {
+	defer observeHandler("RevokeExternalConnectivity", time.Now(), &err)
+	return i.d.RevokeExternalConnectivity(req)
+}
+
+// instrumentedIpam records metrics of every request from docker to the IPAM driver. Handlers
+// are prefixed with "Ipam", because some of them are named like network driver's ones.
+type instrumentedIpam struct {
+	i ipam.Ipam
+}
+
+func (i instrumentedIpam) GetCapabilities() (_ *ipam.CapabilitiesResponse, err error) {
+	defer observeHandler("IpamGetCapabilities", time.Now(), &err)
+	return i.i.GetCapabilities()
+}
+
+func (i instrumentedIpam) GetDefaultAddressSpaces() (_ *ipam.AddressSpacesResponse,
+	err error) {
+	defer observeHandler("IpamGetDefaultAddressSpaces", time.Now(), &err)
+	return i.i.GetDefaultAddressSpaces()
+}
+
+func (i instrumentedIpam) RequestPool(req *ipam.RequestPoolRequest) (
+	_ *ipam.RequestPoolResponse, err error) {
+	defer observeHandler("IpamRequestPool", time.Now(), &err)
+	return i.i.RequestPool(req)
+}
+
+func (i instrumentedIpam) ReleasePool(req *ipam.ReleasePoolRequest) (err error) {
+	defer observeHandler("IpamReleasePool", time.Now(), &err)
+	return i.i.ReleasePool(req)
+}
+
+func (i instrumentedIpam) RequestAddress(req *ipam.RequestAddressRequest) (
+	_ *ipam.RequestAddressResponse, err error) {
+	defer observeHandler("IpamRequestAddress", time.Now(), &err)
+	return i.i.RequestAddress(req)
+}
+
+func (i instrumentedIpam) ReleaseAddress(req *ipam.ReleaseAddressRequest) (err error) {
+	defer observeHandler("IpamReleaseAddress", time.Now(), &err)
+	return i.i.ReleaseAddress(req)
+}
@@ -0,0 +1,82 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"errors"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var _ = Describe("Extension metrics", func() {
+
+	var enabled, running bool
+	var checkErr error
+	var checks int
+	var extension *extensionMetrics
+	var registry *prometheus.Registry
+
+	BeforeEach(func() {
+		enabled, running, checkErr, checks = true, false, nil, 0
+		extension = newExtensionMetrics(func() (bool, bool, error) {
+			checks++
+			return enabled, running, checkErr
+		})
+		registry = prometheus.NewRegistry()
+		registry.MustRegister(extension.enabled, extension.running, extension.known)
+	})
+
+	exported := func() string {
+		recorder := httptest.NewRecorder()
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(recorder,
+			httptest.NewRequest("GET", "/metrics", nil))
+		return recorder.Body.String()
+	}
+
+	It("reports unknown state before the first check", func() {
+		Expect(exported()).To(ContainSubstring("contrail_driver_hyperv_extension_enabled NaN\n"))
+		Expect(exported()).To(ContainSubstring("contrail_driver_hyperv_extension_state_known 0\n"))
+	})
+
+	It("reports state of the last check without checking on scrape", func() {
+		extension.update()
+		Expect(exported()).To(ContainSubstring("contrail_driver_hyperv_extension_enabled 1\n"))
+		Expect(exported()).To(ContainSubstring("contrail_driver_hyperv_extension_running 0\n"))
+		Expect(exported()).To(ContainSubstring("contrail_driver_hyperv_extension_state_known 1\n"))
+		Expect(checks).To(Equal(1))
+	})
+
+	It("reports unknown state when the check fails", func() {
+		extension.update()
+		checkErr = errors.New("PowerShell failed")
+		extension.update()
+		Expect(exported()).To(ContainSubstring("contrail_driver_hyperv_extension_enabled NaN\n"))
+		Expect(exported()).To(ContainSubstring("contrail_driver_hyperv_extension_running NaN\n"))
+		Expect(exported()).To(ContainSubstring("contrail_driver_hyperv_extension_state_known 0\n"))
+	})
+
+	It("checks state in the background until stopped", func() {
+		extension.start(time.Millisecond)
+		Eventually(func() string { return exported() }).Should(
+			ContainSubstring("contrail_driver_hyperv_extension_state_known 1\n"))
+		extension.stop()
+	})
+})
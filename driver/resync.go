@@ -0,0 +1,164 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Juniper/contrail-go-api/types"
+	"github.com/codilime/contrail-windows-docker/agent"
+	"github.com/codilime/contrail-windows-docker/controller"
+	"github.com/codilime/contrail-windows-docker/hns"
+	log "github.com/sirupsen/logrus"
+)
+
+// ResyncAgentPorts adds interfaces of all containers connected to Contrail networks on this host
+// to vRouter Agent again. It's meant to be called after agent restarts, because agent forgets
+// ports added through its API. The ports are rebuilt from HNS endpoints and their Contrail
+// interfaces, so that endpoints the driver doesn't remember are handled too. Endpoints that
+// haven't joined a container yet are skipped, because Join adds them. Port files are rewritten
+// as well. It returns the number of enqueued ports.
+func (d *ContrailDriver) ResyncAgentPorts() (int, error) {
+	hnsNets, err := d.hnsMgr.ListNetworks()
+	if err != nil {
+		return 0, err
+	}
+
+	enqueued := 0
+	var lastErr error
+	for _, hnsNet := range hnsNets {
+		// hnsManager.ListNetworks() already sanitizes network name
+		splitName := strings.SplitN(hnsNet.Name, ":", 4)
+		tenantName := splitName[1]
+		networkName := splitName[2]
+
+		contrailNetwork, err := d.controller.GetNetwork(tenantName, networkName)
+		if err != nil {
+			log.Errorf("Failed to resync ports of HNS network %s: %v", hnsNet.Name, err)
+			lastErr = err
+			continue
+		}
+
+		hnsEps, err := d.hns.ListHNSEndpointsOfNetwork(hnsNet.Id)
+		if err != nil {
+			return enqueued, err
+		}
+		for i := range hnsEps {
+			hnsEp := &hnsEps[i]
+			port, err := d.joinedEndpointPort(contrailNetwork, tenantName, networkName, hnsEp)
+			if err != nil {
+				log.Errorf("Failed to resync port of endpoint %s: %v", hnsEp.Name, err)
+				lastErr = err
+				continue
+			}
+			if port == nil {
+				continue
+			}
+			log.Infoln("Resyncing vRouter Agent port", port.VifUuid, "of container",
+				port.DisplayName)
+			if d.AgentPortFiles != nil {
+				if err := d.AgentPortFiles.Write(*port); err != nil {
+					lastErr = err
+				}
+			}
+			d.agentQueue.AddPort(*port)
+			enqueued++
+		}
+	}
+	return enqueued, lastErr
+}
+
+// joinedEndpointPort returns port of HNS endpoint, or nil if the endpoint hasn't joined
+// a container.
+func (d *ContrailDriver) joinedEndpointPort(contrailNetwork *types.VirtualNetwork, tenantName,
+	networkName string, hnsEp *hns.HNSEndpoint) (*agent.Port, error) {
+	contrailVif, err := d.controller.GetExistingInterface(contrailNetwork, tenantName,
+		controller.InterfaceName(networkName, hnsEp.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	vmRefs, err := contrailVif.GetVirtualMachineRefs()
+	if err != nil {
+		return nil, err
+	}
+	if len(vmRefs) == 0 {
+		return nil, nil
+	}
+	// virtual-machine of a container is named by container ID
+	vmRef := vmRefs[0]
+	containerID := vmRef.To[len(vmRef.To)-1]
+
+	contrailMac, err := d.controller.GetInterfaceMac(contrailVif)
+	if err != nil {
+		return nil, err
+	}
+
+	port := d.agentPort(contrailNetwork, contrailVif, contrailMac, vmRef.Uuid, containerID,
+		hnsEp)
+	return &port, nil
+}
+
+// CompleteDegradedJoins completes Contrail part of joins of endpoints that joined containers
+// while Contrail controller was unavailable: their interfaces are attached to instances of
+// containers and added to vRouter Agent. Deletions of endpoints whose interfaces couldn't be
+// removed are completed too. It's meant to be called when the controller is connected. It
+// returns the number of completed joins and deletions.
+func (d *ContrailDriver) CompleteDegradedJoins() (int, error) {
+	completed := 0
+	var lastErr error
+	for _, storedEp := range d.store.ListEndpoints() {
+		if storedEp.ContainerID == "" || storedEp.VMUuid != "" {
+			continue
+		}
+		if err := d.completeDegradedJoin(storedEp.ID, storedEp.NetworkID,
+			storedEp.ContainerID); err != nil {
+			log.Errorf("Failed to complete join of endpoint %s: %v", storedEp.ID, err)
+			lastErr = err
+			continue
+		}
+		log.Infoln("Completed join of endpoint", storedEp.ID, "and container",
+			storedEp.ContainerID)
+		completed++
+	}
+	for _, deletedEp := range d.store.ListDeletedEndpoints() {
+		if err := d.completeDeletion(deletedEp); err != nil {
+			log.Errorf("Failed to complete deletion of endpoint %s: %v", deletedEp.ID, err)
+			lastErr = err
+			continue
+		}
+		log.Infoln("Completed deletion of endpoint", deletedEp.ID)
+		completed++
+	}
+	return completed, lastErr
+}
+
+func (d *ContrailDriver) completeDegradedJoin(endpointID, networkID, containerID string) error {
+	hnsEp, err := d.hns.GetHNSEndpointByName(endpointID)
+	if err != nil {
+		return err
+	}
+	if hnsEp == nil {
+		return errors.New("Such HNS endpoint doesn't exist")
+	}
+	meta, err := d.networkMetaFromDockerNetwork(networkID)
+	if err != nil {
+		return err
+	}
+	_, err = d.joinContrail(meta, endpointID, containerID, hnsEp)
+	return err
+}
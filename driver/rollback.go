@@ -0,0 +1,66 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// rollback undoes completed steps of a multi-step request when one of its later steps fails.
+// Handlers register a compensating action after every step, defer run() and call commit() once
+// all steps succeeded:
+//
+//	var undo rollback
+//	defer undo.run()
+//	...
+//	undo.add("HNS endpoint "+id, func() error { return d.hns.DeleteHNSEndpoint(id) })
+//	...
+//	undo.commit()
+type rollback struct {
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	description string
+	undo        func() error
+}
+
+// add registers compensating action of a step that has just completed. Description should
+// identify the object by its UUID, so that operators can find it if the action fails.
+func (r *rollback) add(description string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{
+		description: description,
+		undo:        undo,
+	})
+}
+
+// commit forgets compensating actions, so that completed steps are kept.
+func (r *rollback) commit() {
+	r.steps = nil
+}
+
+// run undoes registered steps in reverse order. Failed actions don't stop the rollback.
+func (r *rollback) run() {
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		log.Infoln("Rolling back", step.description)
+		if err := step.undo(); err != nil {
+			log.Errorf("Failed to roll back %s, it has to be removed manually: %v",
+				step.description, err)
+		}
+	}
+	r.steps = nil
+}
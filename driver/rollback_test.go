@@ -0,0 +1,66 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rollback", func() {
+
+	var undo rollback
+	var undone []string
+
+	BeforeEach(func() {
+		undo = rollback{}
+		undone = nil
+		for _, name := range []string{"first", "second", "third"} {
+			name := name
+			undo.add(name, func() error {
+				undone = append(undone, name)
+				return nil
+			})
+		}
+	})
+
+	It("undoes steps in reverse order", func() {
+		undo.run()
+		Expect(undone).To(Equal([]string{"third", "second", "first"}))
+	})
+
+	It("continues when a step can't be undone", func() {
+		undo.add("failing", func() error {
+			return errors.New("cannot undo")
+		})
+		undo.run()
+		Expect(undone).To(Equal([]string{"third", "second", "first"}))
+	})
+
+	It("doesn't undo anything after commit", func() {
+		undo.commit()
+		undo.run()
+		Expect(undone).To(BeEmpty())
+	})
+
+	It("undoes every step only once", func() {
+		undo.run()
+		undo.run()
+		Expect(undone).To(HaveLen(3))
+	})
+})
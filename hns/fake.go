@@ -0,0 +1,176 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pborman/uuid"
+)
+
+// errNotFound is what HNS responds with when object of requested ID doesn't exist.
+var errNotFound = errors.New("HNS failed with error : Element not found.")
+
+// FakeService is Service that keeps networks and endpoints in memory. Like HNS, it assigns IDs
+// and MAC addresses, accepts network of an endpoint given either by ID or by name, and refuses
+// endpoints in networks that don't exist.
+type FakeService struct {
+	mutex     sync.Mutex
+	networks  []HNSNetwork
+	endpoints []HNSEndpoint
+	macs      int
+}
+
+// NewFakeService creates FakeService without any networks and endpoints.
+func NewFakeService() *FakeService {
+	return &FakeService{}
+}
+
+func (s *FakeService) CreateHNSNetwork(configuration *HNSNetwork) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	net := *configuration
+	net.Id = newID()
+	net.Subnets = append([]Subnet(nil), configuration.Subnets...)
+	s.networks = append(s.networks, net)
+	return net.Id, nil
+}
+
+func (s *FakeService) DeleteHNSNetwork(hnsID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, net := range s.networks {
+		if net.Id == hnsID {
+			s.networks = append(s.networks[:i], s.networks[i+1:]...)
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (s *FakeService) ListHNSNetworks() ([]HNSNetwork, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]HNSNetwork(nil), s.networks...), nil
+}
+
+func (s *FakeService) GetHNSNetwork(hnsID string) (*HNSNetwork, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, net := range s.networks {
+		if net.Id == hnsID {
+			return &net, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *FakeService) GetHNSNetworkByName(name string) (*HNSNetwork, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, net := range s.networks {
+		if net.Name == name {
+			return &net, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *FakeService) CreateHNSEndpoint(configuration *HNSEndpoint) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ep := *configuration
+	found := false
+	for _, net := range s.networks {
+		if net.Id == ep.VirtualNetwork ||
+			(ep.VirtualNetwork == "" && net.Name == ep.VirtualNetworkName) {
+			ep.VirtualNetwork = net.Id
+			ep.VirtualNetworkName = net.Name
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", errNotFound
+	}
+	ep.Id = newID()
+	if ep.MacAddress == "" {
+		s.macs++
+		ep.MacAddress = fmt.Sprintf("00-15-5D-00-%02X-%02X", s.macs/256%256, s.macs%256)
+	}
+	s.endpoints = append(s.endpoints, ep)
+	return ep.Id, nil
+}
+
+func (s *FakeService) DeleteHNSEndpoint(endpointID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, ep := range s.endpoints {
+		if ep.Id == endpointID {
+			s.endpoints = append(s.endpoints[:i], s.endpoints[i+1:]...)
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (s *FakeService) GetHNSEndpoint(endpointID string) (*HNSEndpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, ep := range s.endpoints {
+		if ep.Id == endpointID {
+			return &ep, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *FakeService) GetHNSEndpointByName(name string) (*HNSEndpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, ep := range s.endpoints {
+		if ep.Name == name {
+			return &ep, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *FakeService) ListHNSEndpoints() ([]HNSEndpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]HNSEndpoint(nil), s.endpoints...), nil
+}
+
+func (s *FakeService) ListHNSEndpointsOfNetwork(netID string) ([]HNSEndpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var epsInNetwork []HNSEndpoint
+	for _, ep := range s.endpoints {
+		if ep.VirtualNetwork == netID {
+			epsInNetwork = append(epsInNetwork, ep)
+		}
+	}
+	return epsInNetwork, nil
+}
+
+// newID returns ID formatted like IDs assigned by HNS.
+func newID() string {
+	return strings.ToUpper(uuid.New())
+}
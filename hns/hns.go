@@ -13,17 +13,23 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build windows
+// +build windows
+
 package hns
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/Microsoft/hcsshim"
 	log "github.com/sirupsen/logrus"
 	"github.com/codilime/contrail-windows-docker/common"
 )
 
-func CreateHNSNetwork(configuration *hcsshim.HNSNetwork) (string, error) {
+func CreateHNSNetwork(configuration *HNSNetwork) (_ string, err error) {
+	defer observeCall("CreateHNSNetwork", time.Now(), &err)
+
 	log.Infoln("Creating HNS network")
 	configBytes, err := json.Marshal(configuration)
 	if err != nil {
@@ -52,7 +58,9 @@ func CreateHNSNetwork(configuration *hcsshim.HNSNetwork) (string, error) {
 	return response.Id, nil
 }
 
-func DeleteHNSNetwork(hnsID string) error {
+func DeleteHNSNetwork(hnsID string) (err error) {
+	defer observeCall("DeleteHNSNetwork", time.Now(), &err)
+
 	log.Infoln("Deleting HNS network", hnsID)
 
 	toDelete, err := GetHNSNetwork(hnsID)
@@ -97,27 +105,41 @@ func DeleteHNSNetwork(hnsID string) error {
 	return nil
 }
 
-func ListHNSNetworks() ([]hcsshim.HNSNetwork, error) {
+func ListHNSNetworks() (_ []HNSNetwork, err error) {
+	defer observeCall("ListHNSNetworks", time.Now(), &err)
+
 	log.Infoln("Listing HNS networks")
 	nets, err := hcsshim.HNSListNetworkRequest("GET", "", "")
 	if err != nil {
 		log.Errorln(err)
 		return nil, err
 	}
-	return nets, nil
+	var converted []HNSNetwork
+	if err := convert(nets, &converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
 }
 
-func GetHNSNetwork(hnsID string) (*hcsshim.HNSNetwork, error) {
+func GetHNSNetwork(hnsID string) (_ *HNSNetwork, err error) {
+	defer observeCall("GetHNSNetwork", time.Now(), &err)
+
 	log.Infoln("Getting HNS network", hnsID)
 	net, err := hcsshim.HNSNetworkRequest("GET", hnsID, "")
 	if err != nil {
 		log.Errorln(err)
 		return nil, err
 	}
-	return net, nil
+	converted := &HNSNetwork{}
+	if err := convert(net, converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
 }
 
-func GetHNSNetworkByName(name string) (*hcsshim.HNSNetwork, error) {
+func GetHNSNetworkByName(name string) (_ *HNSNetwork, err error) {
+	defer observeCall("GetHNSNetworkByName", time.Now(), &err)
+
 	log.Infoln("Getting HNS network by name:", name)
 	nets, err := hcsshim.HNSListNetworkRequest("GET", "", "")
 	if err != nil {
@@ -126,13 +148,19 @@ func GetHNSNetworkByName(name string) (*hcsshim.HNSNetwork, error) {
 	}
 	for _, n := range nets {
 		if n.Name == name {
-			return &n, nil
+			converted := &HNSNetwork{}
+			if err := convert(&n, converted); err != nil {
+				return nil, err
+			}
+			return converted, nil
 		}
 	}
 	return nil, nil
 }
 
-func CreateHNSEndpoint(configuration *hcsshim.HNSEndpoint) (string, error) {
+func CreateHNSEndpoint(configuration *HNSEndpoint) (_ string, err error) {
+	defer observeCall("CreateHNSEndpoint", time.Now(), &err)
+
 	log.Infoln("Creating HNS endpoint")
 	configBytes, err := json.Marshal(configuration)
 	if err != nil {
@@ -148,9 +176,11 @@ func CreateHNSEndpoint(configuration *hcsshim.HNSEndpoint) (string, error) {
 	return response.Id, nil
 }
 
-func DeleteHNSEndpoint(endpointID string) error {
+func DeleteHNSEndpoint(endpointID string) (err error) {
+	defer observeCall("DeleteHNSEndpoint", time.Now(), &err)
+
 	log.Infoln("Deleting HNS endpoint", endpointID)
-	_, err := hcsshim.HNSEndpointRequest("DELETE", endpointID, "")
+	_, err = hcsshim.HNSEndpointRequest("DELETE", endpointID, "")
 	if err != nil {
 		log.Errorln(err)
 		return err
@@ -158,17 +188,25 @@ func DeleteHNSEndpoint(endpointID string) error {
 	return nil
 }
 
-func GetHNSEndpoint(endpointID string) (*hcsshim.HNSEndpoint, error) {
+func GetHNSEndpoint(endpointID string) (_ *HNSEndpoint, err error) {
+	defer observeCall("GetHNSEndpoint", time.Now(), &err)
+
 	log.Infoln("Getting HNS endpoint", endpointID)
 	endpoint, err := hcsshim.HNSEndpointRequest("GET", endpointID, "")
 	if err != nil {
 		log.Errorln(err)
 		return nil, err
 	}
-	return endpoint, nil
+	converted := &HNSEndpoint{}
+	if err := convert(endpoint, converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
 }
 
-func GetHNSEndpointByName(name string) (*hcsshim.HNSEndpoint, error) {
+func GetHNSEndpointByName(name string) (_ *HNSEndpoint, err error) {
+	defer observeCall("GetHNSEndpointByName", time.Now(), &err)
+
 	log.Infoln("Getting HNS endpoint by name:", name)
 	eps, err := hcsshim.HNSListEndpointRequest()
 	if err != nil {
@@ -177,26 +215,36 @@ func GetHNSEndpointByName(name string) (*hcsshim.HNSEndpoint, error) {
 	}
 	for _, ep := range eps {
 		if ep.Name == name {
-			return &ep, nil
+			converted := &HNSEndpoint{}
+			if err := convert(&ep, converted); err != nil {
+				return nil, err
+			}
+			return converted, nil
 		}
 	}
 	return nil, nil
 }
 
-func ListHNSEndpoints() ([]hcsshim.HNSEndpoint, error) {
+func ListHNSEndpoints() (_ []HNSEndpoint, err error) {
+	defer observeCall("ListHNSEndpoints", time.Now(), &err)
+
 	endpoints, err := hcsshim.HNSListEndpointRequest()
 	if err != nil {
 		return nil, err
 	}
-	return endpoints, nil
+	var converted []HNSEndpoint
+	if err := convert(endpoints, &converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
 }
 
-func ListHNSEndpointsOfNetwork(netID string) ([]hcsshim.HNSEndpoint, error) {
+func ListHNSEndpointsOfNetwork(netID string) ([]HNSEndpoint, error) {
 	eps, err := ListHNSEndpoints()
 	if err != nil {
 		return nil, err
 	}
-	var epsInNetwork []hcsshim.HNSEndpoint
+	var epsInNetwork []HNSEndpoint
 	for _, ep := range eps {
 		if ep.VirtualNetwork == netID {
 			epsInNetwork = append(epsInNetwork, ep)
@@ -204,3 +252,60 @@ func ListHNSEndpointsOfNetwork(netID string) ([]hcsshim.HNSEndpoint, error) {
 	}
 	return epsInNetwork, nil
 }
+
+// convert copies HNS object of hcsshim type to type of this package. Both are encoded in JSON
+// the same way, as in HNS requests.
+func convert(from, to interface{}) error {
+	encoded, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, to)
+}
+
+// WindowsService calls HNS of the host with functions of this package.
+type WindowsService struct{}
+
+func (WindowsService) CreateHNSNetwork(configuration *HNSNetwork) (string, error) {
+	return CreateHNSNetwork(configuration)
+}
+
+func (WindowsService) DeleteHNSNetwork(hnsID string) error {
+	return DeleteHNSNetwork(hnsID)
+}
+
+func (WindowsService) ListHNSNetworks() ([]HNSNetwork, error) {
+	return ListHNSNetworks()
+}
+
+func (WindowsService) GetHNSNetwork(hnsID string) (*HNSNetwork, error) {
+	return GetHNSNetwork(hnsID)
+}
+
+func (WindowsService) GetHNSNetworkByName(name string) (*HNSNetwork, error) {
+	return GetHNSNetworkByName(name)
+}
+
+func (WindowsService) CreateHNSEndpoint(configuration *HNSEndpoint) (string, error) {
+	return CreateHNSEndpoint(configuration)
+}
+
+func (WindowsService) DeleteHNSEndpoint(endpointID string) error {
+	return DeleteHNSEndpoint(endpointID)
+}
+
+func (WindowsService) GetHNSEndpoint(endpointID string) (*HNSEndpoint, error) {
+	return GetHNSEndpoint(endpointID)
+}
+
+func (WindowsService) GetHNSEndpointByName(name string) (*HNSEndpoint, error) {
+	return GetHNSEndpointByName(name)
+}
+
+func (WindowsService) ListHNSEndpoints() ([]HNSEndpoint, error) {
+	return ListHNSEndpoints()
+}
+
+func (WindowsService) ListHNSEndpointsOfNetwork(netID string) ([]HNSEndpoint, error) {
+	return ListHNSEndpointsOfNetwork(netID)
+}
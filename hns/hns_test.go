@@ -13,6 +13,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build windows
+// +build windows
+
 package hns
 
 import (
@@ -158,7 +161,7 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("HNS endpoint operations work", func() {
-			hnsEndpointConfig := &hcsshim.HNSEndpoint{
+			hnsEndpointConfig := &HNSEndpoint{
 				VirtualNetwork: testHnsNetID,
 				Name:           "ep_name",
 			}
@@ -184,7 +187,7 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("Listing HNS endpoints works", func() {
-			hnsEndpointConfig := &hcsshim.HNSEndpoint{
+			hnsEndpointConfig := &HNSEndpoint{
 				VirtualNetwork: testHnsNetID,
 			}
 
@@ -212,7 +215,7 @@ var _ = Describe("HNS wrapper", func() {
 		Specify("Getting HNS endpoint by name works", func() {
 			names := []string{"name1", "name2", "name3"}
 			for _, name := range names {
-				hnsEndpointConfig := &hcsshim.HNSEndpoint{
+				hnsEndpointConfig := &HNSEndpoint{
 					VirtualNetwork: testHnsNetID,
 					Name:           name,
 				}
@@ -237,10 +240,10 @@ var _ = Describe("HNS wrapper", func() {
 				Expect(err).ToNot(HaveOccurred())
 			})
 			Specify("Listing HNS endpoints of specific network works", func() {
-				config1 := &hcsshim.HNSEndpoint{
+				config1 := &HNSEndpoint{
 					VirtualNetwork: testHnsNetID,
 				}
-				config2 := &hcsshim.HNSEndpoint{
+				config2 := &HNSEndpoint{
 					VirtualNetwork: secondHNSNetID,
 				}
 
@@ -279,7 +282,7 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("Creating endpoint in same subnet works", func() {
-			_, err := CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+			_, err := CreateHNSEndpoint(&HNSEndpoint{
 				VirtualNetwork: testHnsNetID,
 				IPAddress:      net.ParseIP("10.0.0.4"),
 			})
@@ -288,7 +291,7 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("Creating endpoint in different subnet fails", func() {
-			_, err := CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+			_, err := CreateHNSEndpoint(&HNSEndpoint{
 				VirtualNetwork: testHnsNetID,
 				IPAddress:      net.ParseIP("10.1.0.4"),
 			})
@@ -297,13 +300,13 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("Creating two endpoints with same IP works in same subnet fails", func() {
-			_, err := CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+			_, err := CreateHNSEndpoint(&HNSEndpoint{
 				VirtualNetwork: testHnsNetID,
 				IPAddress:      net.ParseIP("10.0.0.4"),
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			_, err = CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+			_, err = CreateHNSEndpoint(&HNSEndpoint{
 				VirtualNetwork: testHnsNetID,
 				IPAddress:      net.ParseIP("10.0.0.4"),
 			})
@@ -318,7 +321,7 @@ var _ = Describe("HNS wrapper", func() {
 		}
 		DescribeTable("Creating an endpoint with specific MACs",
 			func(t MACTestCase) {
-				epID, err := CreateHNSEndpoint(&hcsshim.HNSEndpoint{
+				epID, err := CreateHNSEndpoint(&HNSEndpoint{
 					VirtualNetwork: testHnsNetID,
 					MacAddress:     t.MAC,
 				})
@@ -348,7 +351,7 @@ var _ = Describe("HNS wrapper", func() {
 		)
 
 		Specify("Creating multiple endpoints with conflicting MACs works", func() {
-			cfg := &hcsshim.HNSEndpoint{
+			cfg := &HNSEndpoint{
 				VirtualNetwork: testHnsNetID,
 				MacAddress:     "11-22-33-44-55-66",
 			}
@@ -360,7 +363,7 @@ var _ = Describe("HNS wrapper", func() {
 		})
 
 		Specify("Creating endpoint with name containing special characters works", func() {
-			cfg := &hcsshim.HNSEndpoint{
+			cfg := &HNSEndpoint{
 				VirtualNetwork: testHnsNetID,
 				Name:           "A:B123/123",
 			}
@@ -432,13 +435,13 @@ var _ = Describe("HNS race conditions workarounds", func() {
 
 	Context("subnet is specified in new HNS switch config", func() {
 
-		subnets := []hcsshim.Subnet{
+		subnets := []Subnet{
 			{
 				AddressPrefix:  "10.0.0.0/24",
 				GatewayAddress: "10.0.0.1",
 			},
 		}
-		configuration := &hcsshim.HNSNetwork{
+		configuration := &HNSNetwork{
 			Type:    "transparent",
 			Subnets: subnets,
 		}
@@ -506,7 +509,7 @@ var _ = Describe("HNS race conditions workarounds", func() {
 
 	Context("subnet is NOT specified in new HNS switch config", func() {
 
-		configuration := &hcsshim.HNSNetwork{
+		configuration := &HNSNetwork{
 			Type: "transparent",
 		}
 
@@ -13,22 +13,24 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build windows
+// +build windows
+
 package hns
 
 import (
-	"github.com/Microsoft/hcsshim"
 	"github.com/codilime/contrail-windows-docker/common"
 	. "github.com/onsi/gomega"
 )
 
 func MockHNSNetwork(netAdapter common.AdapterName, name, subnetCIDR, defaultGW string) string {
-	subnets := []hcsshim.Subnet{
+	subnets := []Subnet{
 		{
 			AddressPrefix:  subnetCIDR,
 			GatewayAddress: defaultGW,
 		},
 	}
-	netConfig := &hcsshim.HNSNetwork{
+	netConfig := &HNSNetwork{
 		Name:               name,
 		Type:               "transparent",
 		NetworkAdapterName: string(netAdapter),
@@ -41,7 +43,7 @@ func MockHNSNetwork(netAdapter common.AdapterName, name, subnetCIDR, defaultGW s
 }
 
 func MockHNSEndpoint(netID string) string {
-	epConfig := &hcsshim.HNSEndpoint{
+	epConfig := &HNSEndpoint{
 		VirtualNetwork: netID,
 	}
 	epID, err := CreateHNSEndpoint(epConfig)
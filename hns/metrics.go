@@ -0,0 +1,50 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import (
+	"time"
+
+	"github.com/codilime/contrail-windows-docker/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	callDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "hns_call_duration_seconds",
+		Help:      "Duration of calls to HNS, by function of hns package.",
+		Buckets:   metrics.DefaultBuckets,
+	}, []string{"call"})
+	callErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "hns_call_errors_total",
+		Help:      "Number of failed calls to HNS, by function of hns package.",
+	}, []string{"call"})
+)
+
+func init() {
+	metrics.DefaultRegistry.MustRegister(callDuration, callErrors)
+}
+
+// observeCall records duration of call that started at start, and its error. It's deferred
+// with pointer to named error result, so that the final error is seen.
+func observeCall(call string, start time.Time, err *error) {
+	callDuration.WithLabelValues(call).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		callErrors.WithLabelValues(call).Inc()
+	}
+}
@@ -0,0 +1,65 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hns
+
+import "net"
+
+// HNSNetwork is HNS network, as encoded in HNS requests. Only settings used by the driver are
+// kept, with the same JSON names as in hcsshim, so that hcsshim isn't needed outside Windows.
+type HNSNetwork struct {
+	Id                 string   `json:"ID,omitempty"`
+	Name               string   `json:",omitempty"`
+	Type               string   `json:",omitempty"`
+	NetworkAdapterName string   `json:",omitempty"`
+	Subnets            []Subnet `json:",omitempty"`
+}
+
+// Subnet is subnet of HNS network.
+type Subnet struct {
+	AddressPrefix  string `json:",omitempty"`
+	GatewayAddress string `json:",omitempty"`
+}
+
+// HNSEndpoint is HNS endpoint, as encoded in HNS requests. Like HNSNetwork, it only has
+// settings used by the driver.
+type HNSEndpoint struct {
+	Id                 string `json:"ID,omitempty"`
+	Name               string `json:",omitempty"`
+	VirtualNetwork     string `json:",omitempty"`
+	VirtualNetworkName string `json:",omitempty"`
+	MacAddress         string `json:",omitempty"`
+	IPAddress          net.IP `json:",omitempty"`
+	DNSSuffix          string `json:",omitempty"`
+	DNSServerList      string `json:",omitempty"`
+	GatewayAddress     string `json:",omitempty"`
+}
+
+// Service is what the driver needs from Host Networking Service. WindowsService calls HNS of
+// the host, and FakeService keeps networks and endpoints in memory, so that code using HNS can
+// be tested on any OS. Getters by name return nil without error if there's no such object.
+type Service interface {
+	CreateHNSNetwork(configuration *HNSNetwork) (string, error)
+	DeleteHNSNetwork(hnsID string) error
+	ListHNSNetworks() ([]HNSNetwork, error)
+	GetHNSNetwork(hnsID string) (*HNSNetwork, error)
+	GetHNSNetworkByName(name string) (*HNSNetwork, error)
+	CreateHNSEndpoint(configuration *HNSEndpoint) (string, error)
+	DeleteHNSEndpoint(endpointID string) error
+	GetHNSEndpoint(endpointID string) (*HNSEndpoint, error)
+	GetHNSEndpointByName(name string) (*HNSEndpoint, error)
+	ListHNSEndpoints() ([]HNSEndpoint, error)
+	ListHNSEndpointsOfNetwork(netID string) ([]HNSEndpoint, error)
+}
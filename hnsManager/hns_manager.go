@@ -18,53 +18,76 @@ package hnsManager
 import (
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 
-	"github.com/Microsoft/hcsshim"
 	"github.com/codilime/contrail-windows-docker/common"
 	"github.com/codilime/contrail-windows-docker/hns"
 )
 
+const subnetCIDRSeparator = ","
+
 // HNSManager manages HNS networks that are used by the driver.
 type HNSManager struct {
-	// TODO JW-154: store networks here that you know about. If not found here, look in HNS.
-	// for now, just look in HNS by name.
+	// Networks created by the driver are recorded in its state store (see state package),
+	// together with their HNS IDs. Here, just look in HNS by name.
+	service hns.Service
 }
 
-func contrailHNSNetName(tenant, netName, subnetCIDR string) string {
-	return fmt.Sprintf("%s:%s:%s:%s", common.HNSNetworkPrefix, tenant, netName, subnetCIDR)
+// NewHNSManager creates manager of networks in HNS service.
+func NewHNSManager(service hns.Service) *HNSManager {
+	return &HNSManager{service: service}
 }
 
-func (m *HNSManager) CreateNetwork(netAdapter common.AdapterName, tenantName, networkName,
-	subnetCIDR, defaultGW string) (*hcsshim.HNSNetwork, error) {
+// contrailHNSNetName returns name of HNS network, like "Contrail:tenant:network:CIDR1,CIDR2".
+// Subnet CIDRs are the last field, so that colons in IPv6 prefixes don't break splitting.
+func contrailHNSNetName(tenant, netName string, subnetCIDRs []string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", common.HNSNetworkPrefix, tenant, netName,
+		strings.Join(subnetCIDRs, subnetCIDRSeparator))
+}
 
-	hnsNetName := contrailHNSNetName(tenantName, networkName, subnetCIDR)
+// SubnetCIDRsFromNetName returns subnet CIDRs encoded in name of Contrail HNS network.
+func SubnetCIDRsFromNetName(hnsNetName string) ([]string, error) {
+	splitName := strings.SplitN(hnsNetName, ":", 4)
+	if len(splitName) != 4 || splitName[0] != common.HNSNetworkPrefix {
+		return nil, fmt.Errorf("%s is not a Contrail HNS network name", hnsNetName)
+	}
+	subnetCIDRs := strings.Split(splitName[3], subnetCIDRSeparator)
+	for _, cidr := range subnetCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, err
+		}
+	}
+	return subnetCIDRs, nil
+}
 
-	net, err := hns.GetHNSNetworkByName(hnsNetName)
-	if net != nil {
-		return nil, errors.New("Such HNS network already exists")
+func (m *HNSManager) CreateNetwork(netAdapter common.AdapterName, tenantName, networkName string,
+	subnets []hns.Subnet) (*hns.HNSNetwork, error) {
+
+	var subnetCIDRs []string
+	for _, s := range subnets {
+		subnetCIDRs = append(subnetCIDRs, s.AddressPrefix)
 	}
+	hnsNetName := contrailHNSNetName(tenantName, networkName, subnetCIDRs)
 
-	subnets := []hcsshim.Subnet{
-		{
-			AddressPrefix:  subnetCIDR,
-			GatewayAddress: defaultGW,
-		},
+	net, err := m.service.GetHNSNetworkByName(hnsNetName)
+	if net != nil {
+		return nil, errors.New("Such HNS network already exists")
 	}
 
-	configuration := &hcsshim.HNSNetwork{
+	configuration := &hns.HNSNetwork{
 		Name:               hnsNetName,
 		Type:               "transparent",
 		NetworkAdapterName: string(netAdapter),
 		Subnets:            subnets,
 	}
 
-	hnsNetworkID, err := hns.CreateHNSNetwork(configuration)
+	hnsNetworkID, err := m.service.CreateHNSNetwork(configuration)
 	if err != nil {
 		return nil, err
 	}
 
-	hnsNetwork, err := hns.GetHNSNetwork(hnsNetworkID)
+	hnsNetwork, err := m.service.GetHNSNetwork(hnsNetworkID)
 	if err != nil {
 		return nil, err
 	}
@@ -72,10 +95,10 @@ func (m *HNSManager) CreateNetwork(netAdapter common.AdapterName, tenantName, ne
 	return hnsNetwork, nil
 }
 
-func (m *HNSManager) GetNetwork(tenantName, networkName, subnetCIDR string) (*hcsshim.HNSNetwork,
-	error) {
-	hnsNetName := contrailHNSNetName(tenantName, networkName, subnetCIDR)
-	hnsNetwork, err := hns.GetHNSNetworkByName(hnsNetName)
+func (m *HNSManager) GetNetwork(tenantName, networkName string, subnetCIDRs []string) (
+	*hns.HNSNetwork, error) {
+	hnsNetName := contrailHNSNetName(tenantName, networkName, subnetCIDRs)
+	hnsNetwork, err := m.service.GetHNSNetworkByName(hnsNetName)
 	if err != nil {
 		return nil, err
 	}
@@ -85,12 +108,12 @@ func (m *HNSManager) GetNetwork(tenantName, networkName, subnetCIDR string) (*hc
 	return hnsNetwork, nil
 }
 
-func (m *HNSManager) DeleteNetwork(tenantName, networkName, subnetCIDR string) error {
-	hnsNetwork, err := m.GetNetwork(tenantName, networkName, subnetCIDR)
+func (m *HNSManager) DeleteNetwork(tenantName, networkName string, subnetCIDRs []string) error {
+	hnsNetwork, err := m.GetNetwork(tenantName, networkName, subnetCIDRs)
 	if err != nil {
 		return err
 	}
-	endpoints, err := hns.ListHNSEndpoints()
+	endpoints, err := m.service.ListHNSEndpoints()
 	if err != nil {
 		return err
 	}
@@ -100,21 +123,18 @@ func (m *HNSManager) DeleteNetwork(tenantName, networkName, subnetCIDR string) e
 			return errors.New("Cannot delete network with active endpoints")
 		}
 	}
-	return hns.DeleteHNSNetwork(hnsNetwork.Id)
+	return m.service.DeleteHNSNetwork(hnsNetwork.Id)
 }
 
-func (m *HNSManager) ListNetworks() ([]hcsshim.HNSNetwork, error) {
-	var validNets []hcsshim.HNSNetwork
-	nets, err := hns.ListHNSNetworks()
+func (m *HNSManager) ListNetworks() ([]hns.HNSNetwork, error) {
+	var validNets []hns.HNSNetwork
+	nets, err := m.service.ListHNSNetworks()
 	if err != nil {
 		return validNets, err
 	}
 	for _, net := range nets {
-		splitName := strings.Split(net.Name, ":")
-		if len(splitName) == 4 {
-			if splitName[0] == common.HNSNetworkPrefix {
-				validNets = append(validNets, net)
-			}
+		if _, err := SubnetCIDRsFromNetName(net.Name); err == nil {
+			validNets = append(validNets, net)
 		}
 	}
 	return validNets, nil
@@ -13,6 +13,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build windows
+// +build windows
+
 package hnsManager
 
 import (
@@ -57,10 +60,19 @@ var _ = Describe("HNS manager", func() {
 		defaultGW   = "10.0.0.1"
 	)
 
-	var hnsMgr *HNSManager
+	var (
+		hnsMgr      *HNSManager
+		subnetCIDRs = []string{subnetCIDR}
+		subnets     = []hns.Subnet{
+			{
+				AddressPrefix:  subnetCIDR,
+				GatewayAddress: defaultGW,
+			},
+		}
+	)
 
 	BeforeEach(func() {
-		hnsMgr = &HNSManager{}
+		hnsMgr = NewHNSManager(hns.WindowsService{})
 	})
 
 	AfterEach(func() {
@@ -73,11 +85,11 @@ var _ = Describe("HNS manager", func() {
 	Context("specified network does not exist", func() {
 		Specify("creating a new HNS network works", func() {
 			_, err := hnsMgr.CreateNetwork(common.AdapterName(netAdapter), tenantName, networkName,
-				subnetCIDR, defaultGW)
+				subnets)
 			Expect(err).ToNot(HaveOccurred())
 		})
 		Specify("getting the HNS network returns error", func() {
-			net, err := hnsMgr.GetNetwork(tenantName, networkName, subnetCIDR)
+			net, err := hnsMgr.GetNetwork(tenantName, networkName, subnetCIDRs)
 			Expect(err).To(HaveOccurred())
 			Expect(net).To(BeNil())
 		})
@@ -93,13 +105,13 @@ var _ = Describe("HNS manager", func() {
 
 		Specify("creating a new network with same params returns error", func() {
 			net, err := hnsMgr.CreateNetwork(common.AdapterName(netAdapter), tenantName,
-				networkName, subnetCIDR, defaultGW)
+				networkName, subnets)
 			Expect(err).To(HaveOccurred())
 			Expect(net).To(BeNil())
 		})
 
 		Specify("getting the network returns it", func() {
-			net, err := hnsMgr.GetNetwork(tenantName, networkName, subnetCIDR)
+			net, err := hnsMgr.GetNetwork(tenantName, networkName, subnetCIDRs)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(net.Id).To(Equal(existingNetID))
 		})
@@ -118,7 +130,7 @@ var _ = Describe("HNS manager", func() {
 			})
 
 			Specify("deleting the network returns error", func() {
-				err := hnsMgr.DeleteNetwork(tenantName, networkName, subnetCIDR)
+				err := hnsMgr.DeleteNetwork(tenantName, networkName, subnetCIDRs)
 				Expect(err).To(HaveOccurred())
 
 				eps, err := hns.ListHNSEndpoints()
@@ -131,7 +143,7 @@ var _ = Describe("HNS manager", func() {
 			Specify("deleting the network removes it", func() {
 				netsBefore, err := hns.ListHNSNetworks()
 				Expect(err).ToNot(HaveOccurred())
-				err = hnsMgr.DeleteNetwork(tenantName, networkName, subnetCIDR)
+				err = hnsMgr.DeleteNetwork(tenantName, networkName, subnetCIDRs)
 				Expect(err).ToNot(HaveOccurred())
 				netsAfter, err := hns.ListHNSNetworks()
 				Expect(err).ToNot(HaveOccurred())
@@ -140,6 +152,40 @@ var _ = Describe("HNS manager", func() {
 		})
 	})
 
+	Context("specified network is dual-stack", func() {
+		const (
+			subnetCIDRv6 = "fd00:10::/64"
+			defaultGWv6  = "fd00:10::1"
+		)
+		var dualStackSubnets []hns.Subnet
+		BeforeEach(func() {
+			dualStackSubnets = append(subnets, hns.Subnet{
+				AddressPrefix:  subnetCIDRv6,
+				GatewayAddress: defaultGWv6,
+			})
+		})
+
+		Specify("creating it configures both subnets", func() {
+			net, err := hnsMgr.CreateNetwork(common.AdapterName(netAdapter), tenantName,
+				networkName, dualStackSubnets)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(net.Subnets).To(HaveLen(2))
+
+			net, err = hnsMgr.GetNetwork(tenantName, networkName,
+				[]string{subnetCIDR, subnetCIDRv6})
+			Expect(err).ToNot(HaveOccurred())
+
+			By("its name can be parsed back, despite colons in IPv6 CIDR")
+			cidrs, err := SubnetCIDRsFromNetName(net.Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cidrs).To(Equal([]string{subnetCIDR, subnetCIDRv6}))
+
+			nets, err := hnsMgr.ListNetworks()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nets).To(HaveLen(1))
+		})
+	})
+
 	Describe("Listing Contrail networks", func() {
 		BeforeEach(func() {
 			names := []string{
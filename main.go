@@ -13,32 +13,41 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build windows
+// +build windows
+
 package main
 
 import (
 	"flag"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
-	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/codilime/contrail-windows-docker/admin"
+	"github.com/codilime/contrail-windows-docker/agent"
 	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/codilime/contrail-windows-docker/config"
 	"github.com/codilime/contrail-windows-docker/controller"
 	"github.com/codilime/contrail-windows-docker/driver"
+	"github.com/codilime/contrail-windows-docker/hns"
+	"github.com/codilime/contrail-windows-docker/metrics"
+	"github.com/codilime/contrail-windows-docker/state"
+	"github.com/codilime/contrail-windows-docker/transport"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/debug"
 )
 
 type WinService struct {
-	adapter        string
-	controllerIP   string
-	controllerPort int
-	vswitchName    string
-	logDir         string
-	logLevel       log.Level
-	keys           controller.KeystoneEnvs
+	config      *config.Config
+	vswitchName string
+	transport   transport.Transport
+	keys        controller.KeystoneEnvs
 }
 
 func main() {
@@ -48,56 +57,90 @@ func main() {
 		log.Fatalf("Don't know if the session is interactive: %v", err)
 	}
 
-	var adapter = flag.String("adapter", "Ethernet0",
-		"net adapter for HNS switch, must be physical")
-	var controllerIP = flag.String("controllerIP", "127.0.0.1",
-		"IP address of Contrail Controller API")
-	var controllerPort = flag.Int("controllerPort", 8082,
-		"port of Contrail Controller API")
-	var logPath = flag.String("logPath", common.LogFilepath(), "log filepath")
-	var logLevelString = flag.String("logLevel", "Info",
-		"log verbosity (possible values: Debug|Info|Warn|Error|Fatal|Panic)")
-	var vswitchNameWildcard = flag.String("vswitchName", "Layered <adapter>",
-		"Name of Transparent virtual switch. Special wildcard \"<adapter>\" will be interpretted "+
-			"as value of netAdapter parameter. For example, if netAdapter is \"Ethernet0\", then "+
-			"vswitchName will equal \"Layered Ethernet0\". You can use Get-VMSwitch PowerShell "+
-			"command to check how the switch is called on your version of OS.")
-	var forceAsInteractive = flag.Bool("forceAsInteractive", false,
-		"if true, will act as if ran from interactive mode. This is useful when running this "+
-			"service from remote powershell session, because they're not interactive.")
-	var os_auth_url = flag.String("os_auth_url", "", "Keystone auth url. If empty, will read "+
-		"from environment variable")
-	var os_username = flag.String("os_username", "", "Contrail username. If empty, "+
-		"will read from environment variable")
-	var os_tenant_name = flag.String("os_tenant_name", "", "Tenant name. If empty, will read "+
-		"environment variable")
-	var os_password = flag.String("os_password", "", "Contrail password. If empty, will read "+
-		"environment variable")
-	var os_token = flag.String("os_token", "", "Keystone token. If empty, will read "+
-		"environment variable")
+	loader := config.NewLoader(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [command [command flags] [args]]\n",
+			os.Args[0])
+		fmt.Fprintln(os.Stderr, "Without command, runs as a service. Flags:")
+		flag.PrintDefaults()
+		admin.Usage(os.Stderr)
+	}
 	flag.Parse()
 
-	if *forceAsInteractive {
+	cfg, err := loader.LoadUnvalidated()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		// Invalid configuration can still be shown, so that it's easier to fix.
+		if flag.NArg() > 0 && flag.Arg(0) == "config" {
+			env := &admin.Env{Out: os.Stdout, Config: cfg, ConfigErr: err}
+			if err := admin.Run(env, flag.Args()); err != nil {
+				log.Error(err)
+			}
+			os.Exit(1)
+		}
+		log.Error(err)
+		return
+	}
+	cfg.ApplyTunables()
+
+	if cfg.Default.ForceAsInteractive {
 		isInteractive = true
 	}
 
-	vswitchName := strings.Replace(*vswitchNameWildcard, "<adapter>", *adapter, -1)
+	driverTransport, err := transport.New(cfg.TransportConfig())
+	if err != nil {
+		log.Error(err)
+		return
+	}
 
-	logLevel, err := log.ParseLevel(*logLevelString)
+	logLevel, err := log.ParseLevel(cfg.Default.LogLevel)
 	if err != nil {
 		log.Error(err)
 		return
 	}
 	log.SetLevel(logLevel)
 
-	log.Infoln("Logging to", path.Dir(*logPath))
+	winService := &WinService{
+		config:      cfg,
+		vswitchName: cfg.VSwitchName(),
+		transport:   driverTransport,
+		keys: controller.KeystoneEnvs{
+			Os_auth_url:    cfg.Keystone.AuthURL,
+			Os_username:    cfg.Keystone.Username,
+			Os_tenant_name: cfg.Keystone.TenantName,
+			Os_password:    cfg.Keystone.Password,
+			Os_token:       cfg.Keystone.Token,
+
+			Os_user_id:             cfg.Keystone.UserID,
+			Os_user_domain_name:    cfg.Keystone.UserDomainName,
+			Os_user_domain_id:      cfg.Keystone.UserDomainID,
+			Os_project_name:        cfg.Keystone.ProjectName,
+			Os_project_id:          cfg.Keystone.ProjectID,
+			Os_project_domain_name: cfg.Keystone.ProjectDomainName,
+			Os_project_domain_id:   cfg.Keystone.ProjectDomainID,
+		},
+	}
+
+	if flag.NArg() > 0 {
+		if err := winService.runAdminCommand(flag.Args()); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	logPath := cfg.Default.LogPath
+	log.Infoln("Logging to", path.Dir(logPath))
 
-	err = os.MkdirAll(filepath.Dir(*logPath), 0755)
+	err = os.MkdirAll(filepath.Dir(logPath), 0755)
 	if err != nil {
 		log.Errorln("When trying to create log dir:", err)
 	}
 
-	logFile, err := os.OpenFile(*logPath, os.O_WRONLY|os.O_CREATE, 0755)
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE, 0755)
 	if err != nil {
 		log.Errorln("When trying to open log file:", err)
 	}
@@ -106,24 +149,6 @@ func main() {
 	fileLoggerHook := common.NewLogToFileHook(logFile)
 	log.AddHook(fileLoggerHook)
 
-	keys := &controller.KeystoneEnvs{
-		Os_auth_url:    *os_auth_url,
-		Os_username:    *os_username,
-		Os_tenant_name: *os_tenant_name,
-		Os_password:    *os_password,
-		Os_token:       *os_token,
-	}
-	keys.LoadFromEnvironment()
-
-	winService := &WinService{
-		adapter:        *adapter,
-		controllerIP:   *controllerIP,
-		controllerPort: *controllerPort,
-		vswitchName:    vswitchName,
-		logLevel:       logLevel,
-		keys:           *keys,
-	}
-
 	svcRunFunc := debug.Run
 	if !isInteractive {
 		svcRunFunc = svc.Run
@@ -142,19 +167,87 @@ func (ws *WinService) Execute(args []string, winChangeReqChan <-chan svc.ChangeR
 	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
 	winStatusChan <- svc.Status{State: svc.StartPending}
 
-	c, err := controller.NewController(ws.controllerIP, ws.controllerPort, &ws.keys)
+	agentConfig := ws.config.Agent
+	agentClient := agent.NewClient(agentConfig.URL, agentConfig.Timeout)
+	agentQueue := agent.NewQueue(agentClient, agentConfig.MaxRetries, agentConfig.Backoff)
+	defer agentQueue.Close()
+
+	connector, err := ws.newConnector()
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	// If Contrail controller is unavailable, the driver starts anyway, so that containers
+	// that don't need Contrail keep working, and connects to the controller in the background.
+	c, err := connector.Connect()
+	degraded := err != nil
+	if degraded {
+		log.Errorf("Failed to connect to Contrail controller, starting in degraded mode: %v",
+			err)
+		c = controller.NewDisconnectedController()
+	}
+
+	d, err := ws.newDriver(c, agentQueue)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	// Docker can't call the driver until its spec file is published, so it's safe to remove
+	// orphans now.
+	if gcMode := ws.config.Default.GCMode; gcMode != "off" {
+		if degraded {
+			log.Warnln("Garbage collection skipped, because Contrail controller is unavailable")
+		} else if orphans, err := d.CollectGarbage(gcMode == "dry-run"); err != nil {
+			log.Errorf("Garbage collection failed: %v", err)
+		} else {
+			log.Infof("Garbage collection found %d orphaned objects", len(orphans))
+		}
+	}
+	if metricsAddr := ws.config.Default.MetricsAddress; metricsAddr != "" {
+		stopMetrics := d.RegisterMetrics(metrics.DefaultRegistry)
+		defer stopMetrics()
+		metricsServer, err := serveMetrics(metricsAddr)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		defer metricsServer.Close()
+	}
 
-	d := driver.NewDriver(ws.adapter, ws.vswitchName, c)
 	if err = d.StartServing(); err != nil {
 		log.Error(err)
 		return
 	}
 	defer d.StopServing()
 
+	if degraded {
+		stopConnecting := make(chan struct{})
+		defer close(stopConnecting)
+		go func() {
+			if !c.KeepConnecting(connector.Connect, stopConnecting) {
+				return
+			}
+			completed, err := d.CompleteDegradedJoins()
+			if err != nil {
+				log.Errorf("Completing joins and deletions made without Contrail failed: %v",
+					err)
+			}
+			log.Infof("Completed %d joins and deletions made without Contrail", completed)
+		}()
+	}
+
+	if agentConfig.PollInterval > 0 {
+		agentMonitor := agent.NewMonitor(agentClient, agentQueue, agentConfig.PollInterval,
+			func() {
+				if _, err := d.ResyncAgentPorts(); err != nil {
+					log.Errorf("Resyncing vRouter Agent ports failed: %v", err)
+				}
+			})
+		agentMonitor.Start()
+		defer agentMonitor.Stop()
+	}
+
 	winStatusChan <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
 
 win_svc_loop:
@@ -176,3 +269,84 @@ win_svc_loop:
 	winStatusChan <- svc.Status{State: svc.StopPending}
 	return
 }
+
+// newConnector prepares connecting to Contrail controller configured by flags.
+func (ws *WinService) newConnector() (*controller.Connector, error) {
+	endpoints, err := ws.config.ControllerEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	ctrl := ws.config.Controller
+	return controller.NewConnector(endpoints, &ws.keys, &controller.TLSConfig{
+		Scheme:             ctrl.Scheme,
+		CAFile:             ctrl.CAFile,
+		CertFile:           ctrl.CertFile,
+		KeyFile:            ctrl.KeyFile,
+		InsecureSkipVerify: ctrl.InsecureSkipVerify,
+	})
+}
+
+// newDriver creates driver configured by flags, that uses Contrail controller c.
+func (ws *WinService) newDriver(c *controller.Controller,
+	agentQueue *agent.Queue) (*driver.ContrailDriver, error) {
+	store, err := state.NewStore(ws.config.Default.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	d := driver.NewDriver(ws.config.Default.Adapter, ws.vswitchName, ws.config.Default.Scope,
+		c, agentQueue, store, hns.WindowsService{})
+	d.RequireAgentPort = ws.config.Agent.RequirePort
+	d.Transport = ws.transport
+	if portsDir := ws.config.Agent.PortsDir; portsDir != "" {
+		d.AgentPortFiles = agent.NewPortFiles(portsDir)
+	}
+	return d, nil
+}
+
+// runAdminCommand runs subcommand of admin package instead of the service.
+func (ws *WinService) runAdminCommand(args []string) error {
+	agentClient := agent.NewClient(ws.config.Agent.URL, ws.config.Agent.Timeout)
+	// Commands exit right away, so requests to agent (like deleting ports of orphans) aren't
+	// retried.
+	agentQueue := agent.NewQueue(agentClient, 0, agent.DefaultBackoff)
+	defer agentQueue.Close()
+
+	env := &admin.Env{
+		Out:         os.Stdout,
+		VSwitchName: ws.vswitchName,
+		Config:      ws.config,
+		Agent:       agentClient,
+		HNS:         hns.WindowsService{},
+		NewDriver: func() (*driver.ContrailDriver, error) {
+			connector, err := ws.newConnector()
+			if err != nil {
+				return nil, err
+			}
+			c, err := connector.Connect()
+			if err != nil {
+				return nil, err
+			}
+			return ws.newDriver(c, agentQueue)
+		},
+	}
+	return admin.Run(env, args)
+}
+
+// serveMetrics starts serving metrics of metrics.DefaultRegistry in the background.
+func serveMetrics(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorln("Serving metrics failed:", err)
+		}
+	}()
+	log.Infoln("Serving metrics on", listener.Addr())
+	return server, nil
+}
@@ -0,0 +1,49 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics holds the Prometheus registry that packages of the driver register their
+// metrics in, and serves it to Prometheus.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes names of all metrics of the driver.
+const Namespace = "contrail_driver"
+
+// DefaultBuckets are upper bounds (in seconds) of histogram buckets, suitable for latencies of
+// calls that take from milliseconds to tens of seconds, like HNS calls.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// DefaultRegistry is the registry that packages register their metrics in. It's separate from
+// prometheus.DefaultRegisterer, so that only metrics of the driver are exported.
+var DefaultRegistry = prometheus.NewRegistry()
+
+// Handler serves metrics of DefaultRegistry to Prometheus.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(DefaultRegistry, promhttp.HandlerOpts{})
+}
+
+// Bool converts b to gauge value.
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("metrics_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Metrics test suite",
+		[]Reporter{junitReporter})
+}
+
+var _ = Describe("Metrics", func() {
+
+	served := func() (string, string) {
+		recorder := httptest.NewRecorder()
+		Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		body, err := ioutil.ReadAll(recorder.Result().Body)
+		Expect(err).ToNot(HaveOccurred())
+		return recorder.Header().Get("Content-Type"), string(body)
+	}
+
+	It("serves metrics of the default registry over HTTP", func() {
+		requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "test_requests_total",
+			Help:      "Requests.",
+		}, []string{"handler"})
+		DefaultRegistry.MustRegister(requests)
+		defer DefaultRegistry.Unregister(requests)
+		requests.WithLabelValues("Join").Inc()
+
+		contentType, body := served()
+		Expect(contentType).To(HavePrefix("text/plain"))
+		Expect(body).To(ContainSubstring(
+			"contrail_driver_test_requests_total{handler=\"Join\"} 1\n"))
+	})
+
+	It("doesn't serve metrics of other registries", func() {
+		_, body := served()
+		Expect(body).ToNot(ContainSubstring("go_goroutines"))
+	})
+
+	It("converts booleans to gauge values", func() {
+		Expect(Bool(true)).To(Equal(1.0))
+		Expect(Bool(false)).To(Equal(0.0))
+	})
+})
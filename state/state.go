@@ -0,0 +1,244 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Network records Contrail and HNS objects that back a docker network.
+type Network struct {
+	ID           string   `json:"id"`
+	Tenant       string   `json:"tenant"`
+	Network      string   `json:"network"`
+	ContrailUuid string   `json:"contrail_uuid"`
+	SubnetCIDRs  []string `json:"subnet_cidrs"`
+	HNSID        string   `json:"hns_id"`
+
+	FloatingIPPool string   `json:"floating_ip_pool,omitempty"`
+	SecurityGroups []string `json:"security_groups,omitempty"`
+}
+
+// Endpoint records Contrail and HNS objects that back a docker endpoint. VMUuid and
+// ContainerID are only known after the endpoint joins a container. IPv6Address of dual-stack
+// endpoints is only kept here, because HNS endpoints have no IPv6 settings. Deleted endpoints
+// are tombstones of docker endpoints whose Contrail interface couldn't be removed with them; they
+// are kept only until the interface is removed.
+type Endpoint struct {
+	ID              string   `json:"id"`
+	NetworkID       string   `json:"network_id"`
+	VMIUuid         string   `json:"vmi_uuid"`
+	InstanceIPUuids []string `json:"instance_ip_uuids"`
+	HNSEndpointID   string   `json:"hns_endpoint_id"`
+	VMUuid          string   `json:"vm_uuid,omitempty"`
+	ContainerID     string   `json:"container_id,omitempty"`
+	IPv6Address     string   `json:"ipv6_address,omitempty"`
+	Deleted         bool     `json:"deleted,omitempty"`
+}
+
+type storeContents struct {
+	Networks  map[string]Network  `json:"networks"`
+	Endpoints map[string]Endpoint `json:"endpoints"`
+}
+
+// Store keeps docker networks and endpoints in a JSON file, so that driver can look up objects
+// it created directly and still knows about them after service restart. Every modification is
+// written to disk before it returns.
+type Store struct {
+	path     string
+	mutex    sync.Mutex
+	contents storeContents
+}
+
+// NewStore loads store from file at path. If the file doesn't exist, store is empty and the file
+// is created on first modification.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		contents: storeContents{
+			Networks:  make(map[string]Network),
+			Endpoints: make(map[string]Endpoint),
+		},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Infoln("State file", path, "doesn't exist yet, starting with empty state")
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.contents); err != nil {
+		log.Errorf("Malformed state file %s: %v", path, err)
+		return nil, err
+	}
+	if s.contents.Networks == nil {
+		s.contents.Networks = make(map[string]Network)
+	}
+	if s.contents.Endpoints == nil {
+		s.contents.Endpoints = make(map[string]Endpoint)
+	}
+	return s, nil
+}
+
+// Path returns path of the file that backs the store.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// GetNetwork returns network with given docker ID or nil if it's not stored.
+func (s *Store) GetNetwork(id string) *Network {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n, exists := s.contents.Networks[id]
+	if !exists {
+		return nil
+	}
+	return &n
+}
+
+// SaveNetwork adds network to the store or replaces the one with the same ID.
+func (s *Store) SaveNetwork(n Network) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.contents.Networks[n.ID] = n
+	return s.save()
+}
+
+// RemoveNetwork removes network from the store. Removing a network that isn't stored is not an
+// error.
+func (s *Store) RemoveNetwork(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.contents.Networks[id]; !exists {
+		return nil
+	}
+	delete(s.contents.Networks, id)
+	return s.save()
+}
+
+// ListNetworks returns all stored networks, sorted by ID.
+func (s *Store) ListNetworks() []Network {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var networks []Network
+	for _, n := range s.contents.Networks {
+		networks = append(networks, n)
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].ID < networks[j].ID })
+	return networks
+}
+
+// GetEndpoint returns endpoint with given docker ID or nil if it's not stored or deleted.
+func (s *Store) GetEndpoint(id string) *Endpoint {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ep, exists := s.contents.Endpoints[id]
+	if !exists || ep.Deleted {
+		return nil
+	}
+	return &ep
+}
+
+// SaveEndpoint adds endpoint to the store or replaces the one with the same ID.
+func (s *Store) SaveEndpoint(ep Endpoint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.contents.Endpoints[ep.ID] = ep
+	return s.save()
+}
+
+// DeleteEndpoint replaces endpoint with its tombstone. Deleting an endpoint that isn't stored is
+// not an error.
+func (s *Store) DeleteEndpoint(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ep, exists := s.contents.Endpoints[id]
+	if !exists {
+		return nil
+	}
+	ep.Deleted = true
+	s.contents.Endpoints[id] = ep
+	return s.save()
+}
+
+// RemoveEndpoint removes endpoint or its tombstone from the store. Removing an endpoint that isn't
+// stored is not an error.
+func (s *Store) RemoveEndpoint(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.contents.Endpoints[id]; !exists {
+		return nil
+	}
+	delete(s.contents.Endpoints, id)
+	return s.save()
+}
+
+// ListEndpoints returns all stored endpoints that aren't deleted, sorted by ID.
+func (s *Store) ListEndpoints() []Endpoint {
+	return s.listEndpoints(false)
+}
+
+// ListDeletedEndpoints returns tombstones of deleted endpoints, sorted by ID.
+func (s *Store) ListDeletedEndpoints() []Endpoint {
+	return s.listEndpoints(true)
+}
+
+func (s *Store) listEndpoints(deleted bool) []Endpoint {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var endpoints []Endpoint
+	for _, ep := range s.contents.Endpoints {
+		if ep.Deleted == deleted {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].ID < endpoints[j].ID })
+	return endpoints
+}
+
+// save writes contents to a temporary file first and then renames it, so that a crash in the
+// middle of writing doesn't leave a truncated state file behind.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.contents, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		log.Errorf("When trying to create state dir: %v", err)
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Errorf("When trying to write state file: %v", err)
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		log.Errorf("When trying to replace state file: %v", err)
+		return err
+	}
+	return nil
+}
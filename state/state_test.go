@@ -0,0 +1,164 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func TestState(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("state_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "State store test suite", []Reporter{junitReporter})
+}
+
+var _ = Describe("State store", func() {
+
+	var dir string
+	var path string
+	var store *Store
+
+	testNetwork := Network{
+		ID:           "docker_net_id",
+		Tenant:       "agatka",
+		Network:      "test_net",
+		ContrailUuid: "contrail_net_uuid",
+		SubnetCIDRs:  []string{"10.0.0.0/24", "fd00::/64"},
+		HNSID:        "hns_net_id",
+	}
+	testEndpoint := Endpoint{
+		ID:              "docker_ep_id",
+		NetworkID:       "docker_net_id",
+		VMIUuid:         "vmi_uuid",
+		InstanceIPUuids: []string{"iip_uuid", "iip_v6_uuid"},
+		HNSEndpointID:   "hns_ep_id",
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "state_test")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "subdir", "state.json")
+
+		store, err = NewStore(path)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("is empty if state file doesn't exist", func() {
+		Expect(store.ListNetworks()).To(BeEmpty())
+		Expect(store.ListEndpoints()).To(BeEmpty())
+		Expect(store.GetNetwork(testNetwork.ID)).To(BeNil())
+		Expect(store.GetEndpoint(testEndpoint.ID)).To(BeNil())
+	})
+
+	It("stores networks and endpoints", func() {
+		Expect(store.SaveNetwork(testNetwork)).To(Succeed())
+		Expect(store.SaveEndpoint(testEndpoint)).To(Succeed())
+
+		Expect(store.GetNetwork(testNetwork.ID)).To(Equal(&testNetwork))
+		Expect(store.GetEndpoint(testEndpoint.ID)).To(Equal(&testEndpoint))
+		Expect(store.ListNetworks()).To(Equal([]Network{testNetwork}))
+		Expect(store.ListEndpoints()).To(Equal([]Endpoint{testEndpoint}))
+	})
+
+	It("replaces entries with the same ID", func() {
+		Expect(store.SaveEndpoint(testEndpoint)).To(Succeed())
+
+		joined := testEndpoint
+		joined.VMUuid = "vm_uuid"
+		joined.ContainerID = "container_id"
+		Expect(store.SaveEndpoint(joined)).To(Succeed())
+
+		Expect(store.ListEndpoints()).To(Equal([]Endpoint{joined}))
+	})
+
+	It("removes entries", func() {
+		Expect(store.SaveNetwork(testNetwork)).To(Succeed())
+		Expect(store.SaveEndpoint(testEndpoint)).To(Succeed())
+
+		Expect(store.RemoveEndpoint(testEndpoint.ID)).To(Succeed())
+		Expect(store.RemoveNetwork(testNetwork.ID)).To(Succeed())
+
+		Expect(store.GetNetwork(testNetwork.ID)).To(BeNil())
+		Expect(store.GetEndpoint(testEndpoint.ID)).To(BeNil())
+	})
+
+	It("keeps tombstones of deleted endpoints apart until they're removed", func() {
+		Expect(store.SaveEndpoint(testEndpoint)).To(Succeed())
+		Expect(store.DeleteEndpoint(testEndpoint.ID)).To(Succeed())
+
+		deleted := testEndpoint
+		deleted.Deleted = true
+		Expect(store.GetEndpoint(testEndpoint.ID)).To(BeNil())
+		Expect(store.ListEndpoints()).To(BeEmpty())
+		Expect(store.ListDeletedEndpoints()).To(Equal([]Endpoint{deleted}))
+
+		reopened, err := NewStore(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reopened.ListDeletedEndpoints()).To(Equal([]Endpoint{deleted}))
+
+		Expect(store.RemoveEndpoint(testEndpoint.ID)).To(Succeed())
+		Expect(store.ListDeletedEndpoints()).To(BeEmpty())
+	})
+
+	It("doesn't fail when removing entries that aren't stored", func() {
+		Expect(store.RemoveNetwork("nonexistent")).To(Succeed())
+		Expect(store.RemoveEndpoint("nonexistent")).To(Succeed())
+		Expect(store.DeleteEndpoint("nonexistent")).To(Succeed())
+	})
+
+	It("survives reopening", func() {
+		Expect(store.SaveNetwork(testNetwork)).To(Succeed())
+		Expect(store.SaveEndpoint(testEndpoint)).To(Succeed())
+
+		reopened, err := NewStore(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reopened.GetNetwork(testNetwork.ID)).To(Equal(&testNetwork))
+		Expect(reopened.GetEndpoint(testEndpoint.ID)).To(Equal(&testEndpoint))
+	})
+
+	It("returns copies that don't modify the store", func() {
+		Expect(store.SaveNetwork(testNetwork)).To(Succeed())
+
+		n := store.GetNetwork(testNetwork.ID)
+		n.HNSID = "modified"
+
+		Expect(store.GetNetwork(testNetwork.ID).HNSID).To(Equal(testNetwork.HNSID))
+	})
+
+	It("refuses to load malformed state file", func() {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte("{not json"), 0644)).To(Succeed())
+
+		_, err := NewStore(path)
+		Expect(err).To(HaveOccurred())
+	})
+})
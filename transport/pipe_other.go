@@ -0,0 +1,33 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package transport
+
+import "errors"
+
+// PipeTransport can't listen, because named pipes are only available on Windows.
+type PipeTransport struct{}
+
+// NewPipeTransport creates a named pipe transport, which can't listen on this OS.
+func NewPipeTransport(specDir string) *PipeTransport {
+	return &PipeTransport{}
+}
+
+func (t *PipeTransport) Listen(pluginName string) (Listener, error) {
+	return nil, errors.New("Named pipes are only supported on Windows")
+}
@@ -0,0 +1,148 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/codilime/contrail-windows-docker/common"
+	"github.com/docker/go-connections/sockets"
+	log "github.com/sirupsen/logrus"
+)
+
+// PipeTransport serves plugins on named pipes named after plugins.
+type PipeTransport struct {
+	specDir string
+}
+
+// NewPipeTransport creates a named pipe transport.
+func NewPipeTransport(specDir string) *PipeTransport {
+	return &PipeTransport{specDir: specDir}
+}
+
+func (t *PipeTransport) Listen(pluginName string) (Listener, error) {
+	pipeAddr := PipeAddr(pluginName)
+	pipeConfig := winio.PipeConfig{
+		// This will set permissions for Service, System, Adminstrator group and account to
+		// have full access
+		SecurityDescriptor: "D:(A;ID;FA;;;SY)(A;ID;FA;;;BA)(A;ID;FA;;;LA)(A;ID;FA;;;LS)",
+		MessageMode:        true,
+		InputBufferSize:    4096,
+		OutputBufferSize:   4096,
+	}
+
+	listener, err := winio.ListenPipe(pipeAddr, &pipeConfig)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintln("When setting up listener:", err))
+	}
+
+	l := &pipeListener{
+		Listener: listener,
+		pipeAddr: pipeAddr,
+		url:      "npipe://" + listener.Addr().String(),
+	}
+	l.specFile, err = writeURLSpecFile(t.specDir, pluginName, l.url)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+type pipeListener struct {
+	net.Listener
+	pipeAddr string
+	url      string
+	specFile string
+}
+
+func (l *pipeListener) URL() string {
+	return l.url
+}
+
+func (l *pipeListener) WaitUntilReady() error {
+	return waitForPipe(l.pipeAddr, true)
+}
+
+// Close also waits until the pipe disappears, so that it can be created again right away.
+func (l *pipeListener) Close() error {
+	log.Infoln("Closing npipe listener", l.pipeAddr)
+	err := l.Listener.Close()
+	if err != nil {
+		log.Warnln("When closing listener:", err)
+	}
+
+	removeSpecFile(l.specFile)
+
+	if err := waitForPipe(l.pipeAddr, false); err != nil {
+		log.Warnln("Failed to properly close named pipe, but will continue anyways:", err)
+	}
+	return err
+}
+
+func waitForPipe(pipeAddr string, waitUntilExists bool) error {
+	timeStarted := time.Now()
+	for {
+		if time.Since(timeStarted) > common.PipePollingTimeout {
+			return errors.New("Waited for pipe file for too long.")
+		}
+
+		_, err := os.Stat(pipeAddr)
+
+		// if waitUntilExists is true, we wait for the file to appear in filesystem.
+		// else, we wait for the file to disappear from the filesystem.
+		if fileExists := !os.IsNotExist(err); fileExists == waitUntilExists {
+			break
+		} else {
+			log.Errorf("Waiting for pipe file, but: %s", err)
+		}
+
+		time.Sleep(common.PipePollingRate)
+	}
+
+	time.Sleep(time.Second * 1)
+
+	if waitUntilExists {
+		return waitUntilPipeDialable(pipeAddr)
+	}
+
+	return nil
+}
+
+func waitUntilPipeDialable(pipeAddr string) error {
+	timeStarted := time.Now()
+	for {
+		if time.Since(timeStarted) > common.PipePollingTimeout {
+			return errors.New("Waited for pipe to be dialable for too long.")
+		}
+
+		timeout := time.Millisecond * 10
+		conn, err := sockets.DialPipe(pipeAddr, timeout)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+
+		log.Errorf("Waiting until dialable, but: %s", err)
+
+		time.Sleep(common.PipePollingRate)
+	}
+}
@@ -0,0 +1,179 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TCPTransport serves plugins on TCP ports, optionally over TLS. Plugins without TLS are
+// published in .spec files, and plugins with TLS in .json files, which also tell docker which
+// certificates to use.
+type TCPTransport struct {
+	host    string
+	tls     *TLSConfig
+	specDir string
+}
+
+// NewTCPTransport creates a transport listening on host. If tlsConfig is nil, TLS is not used.
+func NewTCPTransport(host string, tlsConfig *TLSConfig, specDir string) *TCPTransport {
+	return &TCPTransport{
+		host:    host,
+		tls:     tlsConfig,
+		specDir: specDir,
+	}
+}
+
+func (t *TCPTransport) Listen(pluginName string) (Listener, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(t.host, "0"))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintln("When setting up listener:", err))
+	}
+
+	l := &socketListener{Listener: listener}
+	if t.tls == nil {
+		l.url = "tcp://" + listener.Addr().String()
+		l.specFile, err = writeURLSpecFile(t.specDir, pluginName, l.url)
+	} else {
+		var serverConfig *tls.Config
+		serverConfig, err = t.serverTLSConfig()
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		l.Listener = tls.NewListener(listener, serverConfig)
+		l.url = "https://" + listener.Addr().String()
+		l.specFile, err = writeJSONSpecFile(t.specDir, jsonSpec{
+			Name: pluginName,
+			Addr: l.url,
+			TLSConfig: &jsonSpecTLS{
+				CAFile:   t.tls.CAFile,
+				CertFile: t.tls.ClientCertFile,
+				KeyFile:  t.tls.ClientKeyFile,
+			},
+		})
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (t *TCPTransport) serverTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.tls.CertFile, t.tls.KeyFile)
+	if err != nil {
+		log.Errorf("When loading TLS certificate: %v", err)
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if t.tls.ClientCertFile != "" {
+		caCert, err := ioutil.ReadFile(t.tls.CAFile)
+		if err != nil {
+			log.Errorf("When loading TLS CA certificate: %v", err)
+			return nil, err
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("No certificates found in %s", t.tls.CAFile)
+		}
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// UnixTransport serves plugins on Unix sockets named after plugins.
+type UnixTransport struct {
+	socketDir string
+	specDir   string
+}
+
+// NewUnixTransport creates a transport with sockets in socketDir.
+func NewUnixTransport(socketDir, specDir string) *UnixTransport {
+	return &UnixTransport{
+		socketDir: socketDir,
+		specDir:   specDir,
+	}
+}
+
+func (t *UnixTransport) Listen(pluginName string) (Listener, error) {
+	listener, err := listenOnUnixSocket(t.socketDir, pluginName)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintln("When setting up listener:", err))
+	}
+
+	l := &socketListener{
+		Listener: listener,
+		url:      "unix://" + listener.Addr().String(),
+	}
+	l.specFile, err = writeURLSpecFile(t.specDir, pluginName, l.url)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// listenOnUnixSocket creates socket of plugin in socketDir. Socket left behind by a previous
+// run that didn't close it is replaced.
+func listenOnUnixSocket(socketDir, pluginName string) (net.Listener, error) {
+	if err := os.MkdirAll(socketDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(socketDir, pluginName+".sock")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// Closing the listener removes the socket file.
+	return net.Listen("unix", path)
+}
+
+// socketListener is a TCP or Unix socket listener. Sockets accept connections as soon as they
+// are created, so there's nothing to wait for.
+type socketListener struct {
+	net.Listener
+	url      string
+	specFile string
+}
+
+func (l *socketListener) URL() string {
+	return l.url
+}
+
+func (l *socketListener) WaitUntilReady() error {
+	return nil
+}
+
+func (l *socketListener) Close() error {
+	log.Infoln("Closing listener", l.url)
+	err := l.Listener.Close()
+	removeSpecFile(l.specFile)
+	return err
+}
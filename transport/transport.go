@@ -0,0 +1,179 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transport makes plugin APIs reachable by docker: it listens for requests and publishes
+// plugin spec files, which tell docker where to send them.
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of transports.
+const (
+	NamedPipe  = "npipe"
+	TCP        = "tcp"
+	UnixSocket = "unix"
+)
+
+// Listener is a net.Listener of plugin API that is published to docker. Closing it also
+// unpublishes it.
+type Listener interface {
+	net.Listener
+	// URL is the address docker sends requests to, like npipe:////./pipe/Contrail.
+	URL() string
+	// WaitUntilReady returns once the listener can be dialed.
+	WaitUntilReady() error
+}
+
+// Transport creates listeners of plugins.
+type Transport interface {
+	// Listen starts listening for requests to plugin and publishes its spec file.
+	Listen(pluginName string) (Listener, error)
+}
+
+// TLSConfig are files of TLS certificates. All of them are PEM encoded.
+type TLSConfig struct {
+	// CertFile and KeyFile are certificate and private key the plugin API is served with.
+	CertFile string
+	KeyFile  string
+	// CAFile is certificate of CA that signed CertFile, which docker uses to verify plugins.
+	// If client certificate is set, it must be signed by this CA too.
+	CAFile string
+	// ClientCertFile and ClientKeyFile are given to docker to authenticate itself with. If
+	// they're empty, docker is not required to present a certificate.
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// Config chooses a transport and configures it.
+type Config struct {
+	// Kind is one of NamedPipe, TCP or UnixSocket.
+	Kind string
+	// SpecDir is the directory where docker looks for plugin spec files.
+	SpecDir string
+	// TCPHost is the address TCP transport listens on. Every plugin gets a port chosen by the
+	// system, which is published in its spec file.
+	TCPHost string
+	// TLS enables TLS in TCP transport, if not nil.
+	TLS *TLSConfig
+	// SocketDir is the directory where Unix socket transport creates sockets.
+	SocketDir string
+}
+
+// New creates transport specified by config.
+func New(config Config) (Transport, error) {
+	switch config.Kind {
+	case NamedPipe:
+		return NewPipeTransport(config.SpecDir), nil
+	case TCP:
+		if config.TCPHost == "" {
+			return nil, errors.New("TCP host is empty")
+		}
+		if config.TLS != nil {
+			if err := config.TLS.validate(); err != nil {
+				return nil, err
+			}
+		}
+		return NewTCPTransport(config.TCPHost, config.TLS, config.SpecDir), nil
+	case UnixSocket:
+		if config.SocketDir == "" {
+			return nil, errors.New("Unix socket directory is empty")
+		}
+		return NewUnixTransport(config.SocketDir, config.SpecDir), nil
+	}
+	return nil, fmt.Errorf("Unknown transport: %s", config.Kind)
+}
+
+func (c *TLSConfig) validate() error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("TLS certificate and key are required")
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return errors.New("TLS client certificate and key have to be specified together")
+	}
+	if c.ClientCertFile != "" && c.CAFile == "" {
+		return errors.New("TLS CA certificate is required to verify client certificates")
+	}
+	return nil
+}
+
+// PipeAddr returns address of named pipe of plugin.
+func PipeAddr(pluginName string) string {
+	return "//./pipe/" + pluginName
+}
+
+// SpecFilePath returns path to spec file of plugin with URL in specDir.
+func SpecFilePath(specDir, pluginName string) string {
+	return filepath.Join(specDir, pluginName+".spec")
+}
+
+// JSONSpecFilePath returns path to spec file of plugin with TLS settings in specDir.
+func JSONSpecFilePath(specDir, pluginName string) string {
+	return filepath.Join(specDir, pluginName+".json")
+}
+
+// jsonSpec is the format of .json spec files, as expected by docker.
+type jsonSpec struct {
+	Name      string
+	Addr      string
+	TLSConfig *jsonSpecTLS `json:",omitempty"`
+}
+
+type jsonSpecTLS struct {
+	InsecureSkipVerify bool
+	CAFile             string `json:",omitempty"`
+	CertFile           string `json:",omitempty"`
+	KeyFile            string `json:",omitempty"`
+}
+
+func writeSpecFile(path string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.New(fmt.Sprintln("When setting up plugin spec directory:", err))
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		return errors.New(fmt.Sprintln("When creating spec file:", err))
+	}
+	return nil
+}
+
+func writeURLSpecFile(specDir, pluginName, url string) (string, error) {
+	path := SpecFilePath(specDir, pluginName)
+	return path, writeSpecFile(path, []byte(url))
+}
+
+func writeJSONSpecFile(specDir string, spec jsonSpec) (string, error) {
+	contents, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := JSONSpecFilePath(specDir, spec.Name)
+	return path, writeSpecFile(path, contents)
+}
+
+func removeSpecFile(path string) {
+	log.Infoln("Removing spec file", path)
+	if err := os.Remove(path); err != nil {
+		log.Warnln("When removing spec file:", err)
+	}
+}
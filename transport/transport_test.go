@@ -0,0 +1,304 @@
+//
+// Copyright (c) 2017 Juniper Networks, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	log.SetLevel(log.DebugLevel)
+}
+
+func TestTransport(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("transport_junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Transport test suite",
+		[]Reporter{junitReporter})
+}
+
+const pluginName = "TestPlugin"
+
+var _ = Describe("Transport", func() {
+
+	var dir string
+	var specDir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "transport")
+		Expect(err).ToNot(HaveOccurred())
+		specDir = filepath.Join(dir, "plugins")
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	// serveEcho accepts connections and echoes one line back.
+	serveEcho := func(l Listener) {
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				buf := make([]byte, 64)
+				n, _ := conn.Read(buf)
+				conn.Write(buf[:n])
+				conn.Close()
+			}
+		}()
+	}
+
+	expectEcho := func(conn net.Conn) {
+		defer conn.Close()
+		_, err := conn.Write([]byte("ping"))
+		Expect(err).ToNot(HaveOccurred())
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(buf[:n])).To(Equal("ping"))
+	}
+
+	expectRemoved := func(path string) {
+		_, err := os.Stat(path)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	}
+
+	Context("TCP", func() {
+		It("publishes URL of listener in spec file", func() {
+			l, err := NewTCPTransport("127.0.0.1", nil, specDir).Listen(pluginName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l.WaitUntilReady()).To(Succeed())
+			serveEcho(l)
+
+			url, err := ioutil.ReadFile(SpecFilePath(specDir, pluginName))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(url)).To(Equal(l.URL()))
+			Expect(l.URL()).To(HavePrefix("tcp://127.0.0.1:"))
+
+			conn, err := net.Dial("tcp", strings.TrimPrefix(l.URL(), "tcp://"))
+			Expect(err).ToNot(HaveOccurred())
+			expectEcho(conn)
+
+			Expect(l.Close()).To(Succeed())
+			expectRemoved(SpecFilePath(specDir, pluginName))
+		})
+
+		It("listens on separate ports for every plugin", func() {
+			t := NewTCPTransport("127.0.0.1", nil, specDir)
+			l1, err := t.Listen(pluginName)
+			Expect(err).ToNot(HaveOccurred())
+			defer l1.Close()
+			l2, err := t.Listen(pluginName + "2")
+			Expect(err).ToNot(HaveOccurred())
+			defer l2.Close()
+
+			Expect(l1.URL()).ToNot(Equal(l2.URL()))
+		})
+
+		Context("with TLS", func() {
+
+			var tlsConfig *TLSConfig
+			var caPool *x509.CertPool
+
+			BeforeEach(func() {
+				tlsConfig, caPool = writeTestCertificates(dir)
+			})
+
+			It("publishes TLS settings in JSON spec file", func() {
+				l, err := NewTCPTransport("127.0.0.1", tlsConfig, specDir).Listen(pluginName)
+				Expect(err).ToNot(HaveOccurred())
+				serveEcho(l)
+
+				data, err := ioutil.ReadFile(JSONSpecFilePath(specDir, pluginName))
+				Expect(err).ToNot(HaveOccurred())
+				var spec jsonSpec
+				Expect(json.Unmarshal(data, &spec)).To(Succeed())
+				Expect(spec.Name).To(Equal(pluginName))
+				Expect(spec.Addr).To(Equal(l.URL()))
+				Expect(spec.Addr).To(HavePrefix("https://"))
+				Expect(spec.TLSConfig).ToNot(BeNil())
+				Expect(spec.TLSConfig.InsecureSkipVerify).To(BeFalse())
+				Expect(spec.TLSConfig.CAFile).To(Equal(tlsConfig.CAFile))
+				Expect(spec.TLSConfig.CertFile).To(Equal(tlsConfig.ClientCertFile))
+				Expect(spec.TLSConfig.KeyFile).To(Equal(tlsConfig.ClientKeyFile))
+
+				clientCert, err := tls.LoadX509KeyPair(tlsConfig.ClientCertFile,
+					tlsConfig.ClientKeyFile)
+				Expect(err).ToNot(HaveOccurred())
+				conn, err := tls.Dial("tcp", strings.TrimPrefix(l.URL(), "https://"),
+					&tls.Config{
+						RootCAs:      caPool,
+						ServerName:   "127.0.0.1",
+						Certificates: []tls.Certificate{clientCert},
+					})
+				Expect(err).ToNot(HaveOccurred())
+				expectEcho(conn)
+
+				Expect(l.Close()).To(Succeed())
+				expectRemoved(JSONSpecFilePath(specDir, pluginName))
+			})
+
+			It("refuses clients without certificate", func() {
+				l, err := NewTCPTransport("127.0.0.1", tlsConfig, specDir).Listen(pluginName)
+				Expect(err).ToNot(HaveOccurred())
+				defer l.Close()
+				serveEcho(l)
+
+				conn, err := tls.Dial("tcp", strings.TrimPrefix(l.URL(), "https://"),
+					&tls.Config{
+						RootCAs:    caPool,
+						ServerName: "127.0.0.1",
+					})
+				if err == nil {
+					// TLS 1.3 reports missing client certificate on first read
+					defer conn.Close()
+					conn.Write([]byte("ping"))
+					_, err = conn.Read(make([]byte, 64))
+				}
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("Unix socket", func() {
+		It("publishes URL of socket in spec file", func() {
+			socketDir := filepath.Join(dir, "sockets")
+			l, err := NewUnixTransport(socketDir, specDir).Listen(pluginName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(l.WaitUntilReady()).To(Succeed())
+			serveEcho(l)
+
+			socketPath := filepath.Join(socketDir, pluginName+".sock")
+			Expect(l.URL()).To(Equal("unix://" + socketPath))
+			url, err := ioutil.ReadFile(SpecFilePath(specDir, pluginName))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(url)).To(Equal(l.URL()))
+
+			conn, err := net.Dial("unix", socketPath)
+			Expect(err).ToNot(HaveOccurred())
+			expectEcho(conn)
+
+			Expect(l.Close()).To(Succeed())
+			expectRemoved(SpecFilePath(specDir, pluginName))
+			expectRemoved(socketPath)
+		})
+	})
+
+	Context("config", func() {
+		It("rejects unknown transport", func() {
+			_, err := New(Config{Kind: "carrier-pigeon"})
+			Expect(err).To(HaveOccurred())
+		})
+		It("rejects TLS without server certificate", func() {
+			_, err := New(Config{Kind: TCP, TCPHost: "127.0.0.1", TLS: &TLSConfig{}})
+			Expect(err).To(HaveOccurred())
+		})
+		It("rejects client certificate without CA", func() {
+			_, err := New(Config{Kind: TCP, TCPHost: "127.0.0.1", TLS: &TLSConfig{
+				CertFile:       "cert.pem",
+				KeyFile:        "key.pem",
+				ClientCertFile: "client.pem",
+				ClientKeyFile:  "client-key.pem",
+			}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+// writeTestCertificates writes CA, server and client certificates to dir.
+func writeTestCertificates(dir string) (*TLSConfig, *x509.CertPool) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey,
+		caKey)
+	Expect(err).ToNot(HaveOccurred())
+	caCert, err := x509.ParseCertificate(caDER)
+	Expect(err).ToNot(HaveOccurred())
+
+	writeCert := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		Expect(err).ToNot(HaveOccurred())
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		Expect(err).ToNot(HaveOccurred())
+
+		certPath := filepath.Join(dir, name+".pem")
+		keyPath := filepath.Join(dir, name+"-key.pem")
+		Expect(ioutil.WriteFile(certPath,
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(keyPath,
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+			0600)).To(Succeed())
+		return certPath, keyPath
+	}
+
+	caPath := filepath.Join(dir, "ca.pem")
+	Expect(ioutil.WriteFile(caPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0644)).To(Succeed())
+	certPath, keyPath := writeCert("server", 2, x509.ExtKeyUsageServerAuth)
+	clientCertPath, clientKeyPath := writeCert("client", 3, x509.ExtKeyUsageClientAuth)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return &TLSConfig{
+		CertFile:       certPath,
+		KeyFile:        keyPath,
+		CAFile:         caPath,
+		ClientCertFile: clientCertPath,
+		ClientKeyFile:  clientKeyPath,
+	}, pool
+}